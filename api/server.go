@@ -0,0 +1,579 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sharkchain/core"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"sort"
+	"strconv"
+
+	"github.com/go-kit/log"
+)
+
+type APIError struct {
+	Error string
+}
+
+type AssetBalance struct {
+	Asset   uint32
+	Balance uint64
+	// Vested and Locked split the part of the balance granted by vesting schedules.
+	Vested uint64
+	Locked uint64
+}
+
+type AssetsResponse struct {
+	Address string
+	// Height is the block the balances are read after, the latest when not
+	// given.
+	Height uint32 `json:",omitempty"`
+	Assets []AssetBalance
+}
+
+type StorageResponse struct {
+	Key    string
+	Value  string
+	Height uint32
+}
+
+type SupplyResponse struct {
+	Asset  uint32
+	Supply uint64
+}
+
+type ReceiptResponse struct {
+	TxHash  string
+	Height  uint32
+	Success bool
+	Err     string
+	Ops     []core.OpResult
+}
+
+type HTLCResponse struct {
+	ID        string
+	Sender    string
+	Recipient string
+	HashLock  string
+	Timeout   uint32
+	Asset     uint32
+	Amount    uint64
+	Status    string
+	Preimage  string
+}
+
+type ChannelResponse struct {
+	ID              string
+	Sender          string
+	Recipient       string
+	Asset           uint32
+	Deposit         uint64
+	ChallengePeriod uint32
+	Status          string
+	ClosingAmount   uint64
+	ClosesAt        uint32
+}
+
+type ParamsResponse struct {
+	BlockTime          string
+	MaxBlockSize       uint32
+	MinFee             uint64
+	Reward             core.RewardSchedule
+	Consensus          string
+	Validators         []string
+	Powers             []uint64            `json:",omitempty"`
+	PoW                *core.PoWParams     `json:",omitempty"`
+	Staking            *core.StakingParams `json:",omitempty"`
+	CheckpointInterval uint32
+	SnapshotInterval   uint32
+	MinChallengePeriod uint32
+}
+
+type StateDiffResponse struct {
+	Height    uint32
+	BlockHash string
+	Accounts  []AccountDiffResponse
+	Supply    []core.SupplyDiff
+	Storage   []StorageDiffResponse
+	Dropped   []string
+}
+
+type AccountDiffResponse struct {
+	Address    string
+	Created    bool
+	Balances   []core.BalanceDiff
+	Vesting    []core.VestingSchedule `json:",omitempty"`
+	OldVesting []core.VestingSchedule `json:",omitempty"`
+}
+
+type StorageDiffResponse struct {
+	Key     string
+	Old     string
+	New     string
+	Created bool
+	Deleted bool
+}
+
+type CheckpointResponse struct {
+	Height    uint32
+	Hash      string
+	StateRoot string `json:",omitempty"`
+}
+
+type ValidatorResponse struct {
+	Address     string
+	Key         string
+	Stake       uint64
+	Delegations []DelegationResponse
+	JailedUntil uint32
+}
+
+type DelegationResponse struct {
+	Delegator string
+	Amount    uint64
+}
+
+type ProposalResponse struct {
+	ID               string
+	Proposer         string
+	Kind             string
+	Params           *ParamsResponse `json:",omitempty"`
+	AddValidators    []string
+	RemoveValidators []string
+	ActivationHeight uint32
+	Status           string
+	Votes            int
+	Approvals        uint64
+}
+
+type ServerConfig struct {
+	Logger     log.Logger
+	ListenAddr string
+}
+
+type Server struct {
+	ServerConfig
+
+	txChan chan *core.Transaction
+	bc     *core.Blockchain
+}
+
+func NewServer(cfg ServerConfig, bc *core.Blockchain, txChan chan *core.Transaction) *Server {
+	return &Server{
+		ServerConfig: cfg,
+		bc:           bc,
+		txChan:       txChan,
+	}
+}
+
+func (s *Server) Start() error {
+	return http.ListenAndServe(s.ListenAddr, s.routes())
+}
+
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tx", s.handlePostTx)
+	mux.HandleFunc("GET /account/{address}/assets", s.handleGetAssets)
+	mux.HandleFunc("GET /supply/{asset}", s.handleGetSupply)
+	mux.HandleFunc("GET /receipt/{hash}", s.handleGetReceipt)
+	mux.HandleFunc("GET /htlc/{id}", s.handleGetHTLC)
+	mux.HandleFunc("GET /channel/{id}", s.handleGetChannel)
+	mux.HandleFunc("GET /params", s.handleGetParams)
+	mux.HandleFunc("GET /proposal/{id}", s.handleGetProposal)
+	mux.HandleFunc("GET /validator/{address}", s.handleGetValidator)
+	mux.HandleFunc("GET /checkpoints", s.handleGetCheckpoints)
+	mux.HandleFunc("GET /snapshots", s.handleGetSnapshots)
+	mux.HandleFunc("GET /storage/{key}", s.handleGetStorage)
+	mux.HandleFunc("GET /diff/{height}", s.handleGetStateDiff)
+
+	return mux
+}
+
+func (s *Server) handlePostTx(w http.ResponseWriter, r *http.Request) {
+	tx := &core.Transaction{}
+	if err := tx.Decode(core.NewGobTxDecoder(r.Body)); err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	s.txChan <- tx
+
+	writeJSON(w, http.StatusOK, tx.Hash(core.TxHasher{}).String())
+}
+
+func (s *Server) handleGetAssets(w http.ResponseWriter, r *http.Request) {
+	address, err := parseAddress(r.PathValue("address"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	height, historical, err := parseHeight(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	var (
+		balances map[core.AssetID]uint64
+		vesting  map[core.AssetID]core.VestingBalance
+	)
+	if historical {
+		balances, err = s.bc.GetBalancesAt(address, height)
+		if err == nil {
+			vesting, err = s.bc.GetVestingBalancesAt(address, height)
+		}
+	} else {
+		balances, err = s.bc.GetBalances(address)
+		if err == nil {
+			vesting, err = s.bc.GetVestingBalances(address)
+		}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	resp := AssetsResponse{
+		Address: address.String(),
+		Height:  height,
+		Assets:  []AssetBalance{},
+	}
+	for asset, balance := range balances {
+		resp.Assets = append(resp.Assets, AssetBalance{
+			Asset:   uint32(asset),
+			Balance: balance,
+			Vested:  vesting[asset].Vested,
+			Locked:  vesting[asset].Locked,
+		})
+	}
+	sort.Slice(resp.Assets, func(i, j int) bool {
+		return resp.Assets[i].Asset < resp.Assets[j].Asset
+	})
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetSupply(w http.ResponseWriter, r *http.Request) {
+	asset, err := strconv.ParseUint(r.PathValue("asset"), 10, 32)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SupplyResponse{
+		Asset:  uint32(asset),
+		Supply: s.bc.TotalSupply(core.AssetID(asset)),
+	})
+}
+
+func (s *Server) handleGetReceipt(w http.ResponseWriter, r *http.Request) {
+	hash, err := parseHash(r.PathValue("hash"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	receipt, err := s.bc.GetReceipt(hash)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ReceiptResponse{
+		TxHash:  receipt.TxHash.String(),
+		Height:  receipt.Height,
+		Success: receipt.Success(),
+		Err:     receipt.Err,
+		Ops:     receipt.Ops,
+	})
+}
+
+func (s *Server) handleGetHTLC(w http.ResponseWriter, r *http.Request) {
+	id, err := parseHash(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	htlc, err := s.bc.GetHTLC(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, HTLCResponse{
+		ID:        htlc.ID.String(),
+		Sender:    htlc.Sender.String(),
+		Recipient: htlc.Recipient.String(),
+		HashLock:  htlc.HashLock.String(),
+		Timeout:   htlc.Timeout,
+		Asset:     uint32(htlc.Asset),
+		Amount:    htlc.Amount,
+		Status:    htlc.Status.String(),
+		Preimage:  hex.EncodeToString(htlc.Preimage),
+	})
+}
+
+func (s *Server) handleGetChannel(w http.ResponseWriter, r *http.Request) {
+	id, err := parseHash(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	channel, err := s.bc.GetChannel(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ChannelResponse{
+		ID:              channel.ID.String(),
+		Sender:          channel.Sender.Address().String(),
+		Recipient:       channel.Recipient.Address().String(),
+		Asset:           uint32(channel.Asset),
+		Deposit:         channel.Deposit,
+		ChallengePeriod: channel.ChallengePeriod,
+		Status:          channel.Status.String(),
+		ClosingAmount:   channel.ClosingAmount,
+		ClosesAt:        channel.ClosesAt,
+	})
+}
+
+func (s *Server) handleGetParams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, toParamsResponse(s.bc.Params()))
+}
+
+func (s *Server) handleGetStorage(w http.ResponseWriter, r *http.Request) {
+	key, err := hex.DecodeString(r.PathValue("key"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+	height, historical, err := parseHeight(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+	if !historical {
+		height = s.bc.Height()
+	}
+
+	value, err := s.bc.GetStorageAt(key, height)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StorageResponse{
+		Key:    hex.EncodeToString(key),
+		Value:  hex.EncodeToString(value),
+		Height: height,
+	})
+}
+
+func (s *Server) handleGetStateDiff(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseUint(r.PathValue("height"), 10, 32)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	d, err := s.bc.GetStateDiff(uint32(height))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	resp := StateDiffResponse{
+		Height:    d.Height,
+		BlockHash: d.BlockHash.String(),
+		Accounts:  []AccountDiffResponse{},
+		Supply:    d.Supply,
+		Storage:   []StorageDiffResponse{},
+		Dropped:   []string{},
+	}
+	for _, hash := range d.Dropped {
+		resp.Dropped = append(resp.Dropped, hash.String())
+	}
+	for _, a := range d.Accounts {
+		resp.Accounts = append(resp.Accounts, AccountDiffResponse{
+			Address:    a.Address.String(),
+			Created:    a.Created,
+			Balances:   a.Balances,
+			Vesting:    a.Vesting,
+			OldVesting: a.OldVesting,
+		})
+	}
+	for _, change := range d.Storage {
+		resp.Storage = append(resp.Storage, StorageDiffResponse{
+			Key:     hex.EncodeToString(change.Key),
+			Old:     hex.EncodeToString(change.Old),
+			New:     hex.EncodeToString(change.New),
+			Created: change.Created,
+			Deleted: change.Deleted,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetCheckpoints(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, toCheckpointResponses(s.bc.Checkpoints()))
+}
+
+// handleGetSnapshots returns the checkpoints of the snapshots the node
+// serves, a new node trusting one of them can fast sync from it.
+func (s *Server) handleGetSnapshots(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, toCheckpointResponses(s.bc.Snapshots()))
+}
+
+func toCheckpointResponses(checkpoints []core.Checkpoint) []CheckpointResponse {
+	resp := []CheckpointResponse{}
+	for _, c := range checkpoints {
+		checkpoint := CheckpointResponse{Height: c.Height, Hash: c.Hash.String()}
+		if !c.StateRoot.IsZero() {
+			checkpoint.StateRoot = c.StateRoot.String()
+		}
+		resp = append(resp, checkpoint)
+	}
+
+	return resp
+}
+
+func (s *Server) handleGetProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := parseHash(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	proposal, err := s.bc.GetProposal(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	resp := ProposalResponse{
+		ID:               proposal.ID.String(),
+		Proposer:         proposal.Proposer.String(),
+		Kind:             proposal.Kind.String(),
+		AddValidators:    toHexKeys(proposal.AddValidators),
+		RemoveValidators: toHexKeys(proposal.RemoveValidators),
+		ActivationHeight: proposal.ActivationHeight,
+		Status:           proposal.Status.String(),
+		Votes:            len(proposal.Voters),
+		Approvals:        proposal.Approvals,
+	}
+	if proposal.Kind == core.ProposalParams {
+		params := toParamsResponse(proposal.Params)
+		resp.Params = &params
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetValidator(w http.ResponseWriter, r *http.Request) {
+	address, err := parseAddress(r.PathValue("address"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+
+	info, err := s.bc.GetValidatorInfo(address)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, APIError{Error: err.Error()})
+		return
+	}
+
+	resp := ValidatorResponse{
+		Address:     info.Address.String(),
+		Key:         hex.EncodeToString(info.Key),
+		Stake:       info.Stake,
+		Delegations: []DelegationResponse{},
+		JailedUntil: info.JailedUntil,
+	}
+	for _, d := range info.Delegations {
+		resp.Delegations = append(resp.Delegations, DelegationResponse{
+			Delegator: d.Delegator.String(),
+			Amount:    d.Amount,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func toParamsResponse(params core.Params) ParamsResponse {
+	return ParamsResponse{
+		BlockTime:          params.BlockTime.String(),
+		MaxBlockSize:       params.MaxBlockSize,
+		MinFee:             params.MinFee,
+		Reward:             params.Reward,
+		Consensus:          params.Consensus.String(),
+		Validators:         toHexKeys(params.Validators),
+		Powers:             params.Powers,
+		PoW:                params.PoW,
+		Staking:            params.Staking,
+		CheckpointInterval: params.CheckpointInterval,
+		SnapshotInterval:   params.SnapshotInterval,
+		MinChallengePeriod: params.MinChallengePeriod,
+	}
+}
+
+func toHexKeys(keys []crypto.PublicKey) []string {
+	hexKeys := []string{}
+	for _, k := range keys {
+		hexKeys = append(hexKeys, hex.EncodeToString(k))
+	}
+
+	return hexKeys
+}
+
+// parseHeight returns the height given by the query of the request, if any.
+func parseHeight(r *http.Request) (uint32, bool, error) {
+	value := r.URL.Query().Get("height")
+	if value == "" {
+		return 0, false, nil
+	}
+
+	height, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid height %q", value)
+	}
+
+	return uint32(height), true, nil
+}
+
+func parseHash(s string) (types.Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return types.Hash{}, err
+	}
+	if len(b) != len(types.Hash{}) {
+		return types.Hash{}, fmt.Errorf("invalid hash length %d", len(b))
+	}
+
+	return types.HashFromBytes(b), nil
+}
+
+func parseAddress(s string) (types.Address, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return types.Address{}, err
+	}
+	if len(b) != len(types.Address{}) {
+		return types.Address{}, fmt.Errorf("invalid address length %d", len(b))
+	}
+
+	return types.AddressFromBytes(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
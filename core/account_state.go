@@ -1,8 +1,12 @@
 package core
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"maps"
 	"sharkchain/types"
+	"slices"
 	"sync"
 )
 
@@ -11,29 +15,118 @@ var (
 	ErrInsufficientBalance = errors.New("insufficient account balance")
 )
 
+// AssetID identifies an asset an account can hold a balance of.
+type AssetID uint32
+
+// NativeAsset is the native coin of the chain.
+const NativeAsset AssetID = 0
+
+// AssetAmount is an amount of a single asset.
+type AssetAmount struct {
+	Asset  AssetID
+	Amount uint64
+}
+
 type Account struct {
-	Address types.Address
-	Balance uint64
+	Address  types.Address
+	Balances map[AssetID]uint64
+	// Vesting holds the schedules locking part of the balances.
+	Vesting []VestingSchedule
+}
+
+func NewAccount(address types.Address) *Account {
+	return &Account{
+		Address:  address,
+		Balances: make(map[AssetID]uint64),
+	}
+}
+
+// Balance returns the balance of the given asset, zero if the account never held it.
+func (a *Account) Balance(asset AssetID) uint64 {
+	return a.Balances[asset]
+}
+
+// NonZeroBalances returns a copy of the balances, leaving out the empty ones.
+func (a *Account) NonZeroBalances() map[AssetID]uint64 {
+	balances := make(map[AssetID]uint64, len(a.Balances))
+	for asset, balance := range a.Balances {
+		if balance > 0 {
+			balances[asset] = balance
+		}
+	}
+
+	return balances
+}
+
+// VestingBalances returns the vested and locked amount per asset of all
+// schedules at the given block.
+func (a *Account) VestingBalances(height uint32, timestamp int64) map[AssetID]VestingBalance {
+	balances := make(map[AssetID]VestingBalance)
+	for _, schedule := range a.Vesting {
+		locked := schedule.Locked(height, timestamp)
+		b := balances[schedule.Asset]
+		b.Locked += locked
+		b.Vested += schedule.Amount - locked
+		balances[schedule.Asset] = b
+	}
+
+	return balances
+}
+
+// Locked returns the part of the balance of the given asset that has not vested yet.
+func (a *Account) Locked(asset AssetID, height uint32, timestamp int64) uint64 {
+	locked := uint64(0)
+	for _, schedule := range a.Vesting {
+		if schedule.Asset == asset {
+			locked += schedule.Locked(height, timestamp)
+		}
+	}
+
+	return locked
 }
 
 type AccountState struct {
 	mu       sync.RWMutex
 	accounts map[types.Address]*Account
+	// supply is the total amount ever minted per asset
+	supply map[AssetID]uint64
+	// journal records every change since the last Commit so they can be reverted
+	journal []accountChange
+
+	// height and timestamp of the block being applied, used to compute
+	// how much of a vesting balance is locked.
+	height    uint32
+	timestamp int64
+}
+
+// accountChange is a journal entry holding the value before the change.
+type accountChange struct {
+	address types.Address
+	asset   AssetID
+	prev    uint64
+	// created is set when the change brought the account into existence
+	created bool
+	// supply is set when the change is to the total supply of the asset
+	supply bool
+	// vesting is set when the schedules changed, vestingBefore holds them
+	// as they were.
+	vesting       bool
+	vestingBefore []VestingSchedule
 }
 
 func NewAccountState() *AccountState {
 	return &AccountState{
 		accounts: make(map[types.Address]*Account),
+		supply:   make(map[AssetID]uint64),
 	}
 }
 
+// CreateAccount returns the account of the given address, creating it if needed.
 func (s *AccountState) CreateAccount(address types.Address) *Account {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	acc := &Account{Address: address}
-	s.accounts[address] = acc
-	return acc
+	return s.getOrCreateAccountWithoutLock(address)
 }
 
 func (s *AccountState) GetAccount(address types.Address) (*Account, error) {
@@ -52,7 +145,7 @@ func (s *AccountState) getAccountWithoutLock(address types.Address) (*Account, e
 	return account, nil
 }
 
-func (s *AccountState) GetBalance(address types.Address) (uint64, error) {
+func (s *AccountState) GetBalance(address types.Address, asset AssetID) (uint64, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -61,35 +154,319 @@ func (s *AccountState) GetBalance(address types.Address) (uint64, error) {
 		return 0, err
 	}
 
-	return account.Balance, nil
+	return account.Balance(asset), nil
 }
 
-func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
+// GetBalances returns a copy of every non-zero balance held by the given address.
+func (s *AccountState) GetBalances(address types.Address) (map[AssetID]uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.NonZeroBalances(), nil
+}
+
+func (s *AccountState) Transfer(from, to types.Address, asset AssetID, amount uint64) error {
+	return s.TransferAssets(from, to, []AssetAmount{{Asset: asset, Amount: amount}})
+}
+
+// TransferAssets moves all given amounts from one account to another. Either
+// every amount is transferred or, if any balance is insufficient, none is.
+func (s *AccountState) TransferAssets(from, to types.Address, amounts []AssetAmount) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	fromAccount, err := s.getAccountWithoutLock(from)
+	return s.transferWithoutLock(from, to, amounts)
+}
+
+func (s *AccountState) transferWithoutLock(from, to types.Address, amounts []AssetAmount) error {
+	if err := s.canSpendWithoutLock(from, amounts); err != nil {
+		return err
+	}
+
+	fromAccount := s.accounts[from]
+	toAccount := s.getOrCreateAccountWithoutLock(to)
+
+	for asset, amount := range sumAmounts(amounts) {
+		// the coinbase mints whatever it does not hold
+		if fromAccount.Balance(asset) >= amount {
+			s.setBalance(fromAccount, asset, fromAccount.Balance(asset)-amount)
+		} else {
+			s.setSupply(asset, s.supply[asset]+amount)
+		}
+		s.setBalance(toAccount, asset, toAccount.Balance(asset)+amount)
+	}
+
+	return nil
+}
+
+// CanSpend checks that the given address holds all given amounts at once.
+func (s *AccountState) CanSpend(address types.Address, amounts []AssetAmount) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.canSpendWithoutLock(address, amounts)
+}
+
+func (s *AccountState) canSpendWithoutLock(address types.Address, amounts []AssetAmount) error {
+	account, err := s.getAccountWithoutLock(address)
 	if err != nil {
 		return err
 	}
 
-	if fromAccount.Address.String() != "996fb92427ae41e4649b934ca495991b7852b855" {
-		if fromAccount.Balance < amount {
+	if isCoinbase(address) {
+		return nil
+	}
+
+	for asset, amount := range sumAmounts(amounts) {
+		balance := account.Balance(asset)
+		locked := account.Locked(asset, s.height, s.timestamp)
+		if balance < locked || balance-locked < amount {
 			return ErrInsufficientBalance
 		}
 	}
 
-	if fromAccount.Balance != 0 {
-		fromAccount.Balance -= amount
-	}
+	return nil
+}
+
+// SetBlock sets the block whose height and timestamp determine which vesting
+// amounts are unlocked.
+func (s *AccountState) SetBlock(height uint32, timestamp int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.height = height
+	s.timestamp = timestamp
+}
+
+// TransferVesting transfers the amount of the schedule and locks it in the
+// receiving account until it vests. The schedules of the account which have
+// fully vested are dropped, they lock nothing anymore.
+func (s *AccountState) TransferVesting(from, to types.Address, schedule VestingSchedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if s.accounts[to] == nil {
-		s.accounts[to] = &Account{
-			Address: to,
+	vesting := []VestingSchedule{}
+	if account, err := s.getAccountWithoutLock(to); err == nil {
+		for _, v := range account.Vesting {
+			if v.Locked(s.height, s.timestamp) > 0 {
+				vesting = append(vesting, v)
+			}
 		}
 	}
+	if len(vesting) >= MaxVestingSchedules {
+		return fmt.Errorf("(%s) already has %d vesting schedules", to, len(vesting))
+	}
+
+	amounts := []AssetAmount{{Asset: schedule.Asset, Amount: schedule.Amount}}
+	if err := s.transferWithoutLock(from, to, amounts); err != nil {
+		return err
+	}
+
+	account := s.accounts[to]
+	s.journal = append(s.journal, accountChange{
+		address:       to,
+		vesting:       true,
+		vestingBefore: account.Vesting,
+	})
+	account.Vesting = append(vesting, schedule)
+
+	return nil
+}
+
+// GetVestingBalances returns the vested and locked amount per asset of all
+// schedules attached to the given address.
+func (s *AccountState) GetVestingBalances(address types.Address) (map[AssetID]VestingBalance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.VestingBalances(s.height, s.timestamp), nil
+}
+
+// Mint credits newly created coins to the given address.
+func (s *AccountState) Mint(to types.Address, asset AssetID, amount uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account := s.getOrCreateAccountWithoutLock(to)
+	s.setBalance(account, asset, account.Balance(asset)+amount)
+	s.setSupply(asset, s.supply[asset]+amount)
+}
+
+// Burn destroys the given amount held by the given address.
+func (s *AccountState) Burn(from types.Address, asset AssetID, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	s.accounts[to].Balance += amount
+	account, err := s.getAccountWithoutLock(from)
+	if err != nil {
+		return err
+	}
+	if account.Balance(asset) < amount {
+		return ErrInsufficientBalance
+	}
+
+	s.setBalance(account, asset, account.Balance(asset)-amount)
+	s.setSupply(asset, s.supply[asset]-amount)
 
 	return nil
 }
+
+// TotalSupply returns the amount of the given asset minted so far.
+func (s *AccountState) TotalSupply(asset AssetID) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.supply[asset]
+}
+
+// Snapshot returns an identifier for the current state which can be passed
+// to RevertToSnapshot until the next Commit.
+func (s *AccountState) Snapshot() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.journal)
+}
+
+// RevertToSnapshot undoes every change made since the given snapshot was taken.
+func (s *AccountState) RevertToSnapshot(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.undo(s.journal[id:])
+	s.journal = s.journal[:id]
+}
+
+// Commit makes all changes so far permanent and invalidates older snapshots.
+// It returns the changes, see StateDiff.
+func (s *AccountState) Commit() []accountChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := s.journal
+	s.journal = nil
+
+	return changes
+}
+
+func (s *AccountState) undo(changes []accountChange) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		switch {
+		case change.created:
+			delete(s.accounts, change.address)
+		case change.vesting:
+			account := s.accounts[change.address]
+			account.Vesting = change.vestingBefore
+		case change.supply:
+			s.supply[change.asset] = change.prev
+		default:
+			s.accounts[change.address].Balances[change.asset] = change.prev
+		}
+	}
+}
+
+// accountBefore returns a copy of the account as it was before the given
+// blocks, which have to be the last ones, oldest first.
+func (s *AccountState) accountBefore(address types.Address, diffs []*StateDiff) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	current, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return nil, err
+	}
+	account := &Account{
+		Address:  address,
+		Balances: maps.Clone(current.Balances),
+		Vesting:  slices.Clone(current.Vesting),
+	}
+
+	// the first change of a value holds the value before all of them
+	var (
+		restored        = make(map[AssetID]bool)
+		restoredVesting bool
+	)
+	for _, d := range diffs {
+		for _, a := range d.Accounts {
+			if a.Address != address {
+				continue
+			}
+			if a.Created {
+				return nil, ErrAccountNotFound
+			}
+
+			for _, b := range a.Balances {
+				if !restored[b.Asset] {
+					account.Balances[b.Asset] = b.Old
+					restored[b.Asset] = true
+				}
+			}
+			if len(a.Vesting) > 0 && !restoredVesting {
+				account.Vesting = slices.Clone(a.OldVesting)
+				restoredVesting = true
+			}
+		}
+	}
+
+	return account, nil
+}
+
+func (s *AccountState) getOrCreateAccountWithoutLock(address types.Address) *Account {
+	if s.accounts[address] == nil {
+		s.accounts[address] = NewAccount(address)
+		s.journal = append(s.journal, accountChange{address: address, created: true})
+	}
+
+	return s.accounts[address]
+}
+
+func (s *AccountState) setBalance(account *Account, asset AssetID, balance uint64) {
+	s.journal = append(s.journal, accountChange{
+		address: account.Address,
+		asset:   asset,
+		prev:    account.Balance(asset),
+	})
+	account.Balances[asset] = balance
+}
+
+func (s *AccountState) setSupply(asset AssetID, supply uint64) {
+	s.journal = append(s.journal, accountChange{
+		asset:  asset,
+		prev:   s.supply[asset],
+		supply: true,
+	})
+	s.supply[asset] = supply
+}
+
+// sumAmounts adds up the amounts per asset, the same asset may be listed more than once.
+func sumAmounts(amounts []AssetAmount) map[AssetID]uint64 {
+	sum := make(map[AssetID]uint64, len(amounts))
+	for _, a := range amounts {
+		sum[a.Asset] += a.Amount
+	}
+
+	return sum
+}
+
+// ModuleAddress returns the address of the account holding the funds escrowed
+// by a native module, e.g. "htlc". Nobody owns a key for it.
+func ModuleAddress(name string) types.Address {
+	h := sha256.Sum256([]byte("module/" + name))
+
+	return types.AddressFromBytes(h[len(h)-20:])
+}
+
+func isCoinbase(address types.Address) bool {
+	return address.String() == "996fb92427ae41e4649b934ca495991b7852b855"
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestAccountStateTransferFailInsufficientBalance(t *testing.T) {
+	state := NewAccountState()
+	from := crypto.GeneratePrivateKey().PublicKey().Address()
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	state.CreateAccount(from)
+	assert.Equal(t, ErrInsufficientBalance, state.Transfer(from, to, NativeAsset, 10))
+}
+
+func TestAccountStateTransferAssets(t *testing.T) {
+	state := NewAccountState()
+	coinbase := crypto.PublicKey{}.Address()
+	from := crypto.GeneratePrivateKey().PublicKey().Address()
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	state.CreateAccount(coinbase)
+	assert.Nil(t, state.TransferAssets(coinbase, from, []AssetAmount{
+		{Asset: NativeAsset, Amount: 100},
+		{Asset: 7, Amount: 50},
+	}))
+
+	assert.Nil(t, state.TransferAssets(from, to, []AssetAmount{
+		{Asset: NativeAsset, Amount: 40},
+		{Asset: 7, Amount: 50},
+	}))
+
+	balances, err := state.GetBalances(to)
+	assert.Nil(t, err)
+	assert.Equal(t, map[AssetID]uint64{NativeAsset: 40, 7: 50}, balances)
+
+	// asset 7 is drained, the native part must not be moved either
+	assert.Equal(t, ErrInsufficientBalance, state.TransferAssets(from, to, []AssetAmount{
+		{Asset: NativeAsset, Amount: 10},
+		{Asset: 7, Amount: 1},
+	}))
+
+	balances, err = state.GetBalances(from)
+	assert.Nil(t, err)
+	assert.Equal(t, map[AssetID]uint64{NativeAsset: 60}, balances)
+}
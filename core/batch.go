@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sharkchain/crypto"
+)
+
+var ErrNestedBatch = errors.New("batch transactions can't be nested")
+
+// BatchTx carries an ordered list of operations under the signature and nonce
+// of the enclosing tx. Either every operation applies or none does.
+type BatchTx struct {
+	Ops []BatchOp
+}
+
+// BatchOp is a single operation of a batch, it is executed as if it was a tx
+// sent by the signer of the batch.
+type BatchOp struct {
+	TxInner any
+	Data    []byte
+	To      crypto.PublicKey
+	Value   uint64
+	Asset   AssetID
+}
+
+// transaction returns the operation as a tx sent by the sender of the batch.
+// It carries the nonce of the batch, the same operation in another batch
+// stands for another tx.
+func (op BatchOp) transaction(batch *Transaction) *Transaction {
+	return &Transaction{
+		TxInner:  op.TxInner,
+		Data:     op.Data,
+		From:     batch.From,
+		Multisig: batch.Multisig,
+		To:       op.To,
+		Value:    op.Value,
+		Asset:    op.Asset,
+		Nonce:    batch.Nonce,
+	}
+}
+
+// handleBatch executes the operations of the batch in order and stops at the
+// first one that fails. Reverting the applied ones is up to the caller.
+func (bc *Blockchain) handleBatch(tx *Transaction, b *Block, batch BatchTx, receipt *Receipt) error {
+	receipt.Ops = make([]OpResult, len(batch.Ops))
+	for i := range receipt.Ops {
+		receipt.Ops[i].Status = OpSkipped
+	}
+
+	for i, op := range batch.Ops {
+		if err := bc.executeTransaction(op.transaction(tx), b); err != nil {
+			for j := 0; j < i; j++ {
+				receipt.Ops[j].Status = OpReverted
+			}
+			receipt.Ops[i] = OpResult{Status: OpFailed, Err: err.Error()}
+
+			return fmt.Errorf("batch operation %d failed: %w", i, err)
+		}
+
+		receipt.Ops[i].Status = OpApplied
+	}
+
+	return nil
+}
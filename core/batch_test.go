@@ -0,0 +1,123 @@
+package core
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestBatchTxApplied(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender := crypto.GeneratePrivateKey()
+	alice := crypto.GeneratePrivateKey().PublicKey()
+	bob := crypto.GeneratePrivateKey().PublicKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+	bc.accountState.Mint(sender.PublicKey().Address(), 1, 10)
+
+	tx := &Transaction{
+		TxInner: BatchTx{Ops: []BatchOp{
+			{To: alice, Value: 30},
+			{To: bob, Asset: 1, Value: 10},
+		}},
+	}
+	assert.Nil(t, tx.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, crypto.GeneratePrivateKey(), []*Transaction{tx})))
+
+	receipt, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success())
+	assert.Equal(t, []OpResult{{Status: OpApplied}, {Status: OpApplied}}, receipt.Ops)
+
+	balance, err := bc.accountState.GetBalance(alice.Address(), NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(30), balance)
+
+	balance, err = bc.accountState.GetBalance(bob.Address(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), balance)
+}
+
+func TestBatchTxRevertedAsAWhole(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender := crypto.GeneratePrivateKey()
+	alice := crypto.GeneratePrivateKey().PublicKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	tx := &Transaction{
+		Fee: 5,
+		TxInner: BatchTx{Ops: []BatchOp{
+			{To: alice, Value: 30},
+			{To: alice, Value: 100},
+			{To: alice, Value: 1},
+		}},
+	}
+	assert.Nil(t, tx.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, crypto.GeneratePrivateKey(), []*Transaction{tx})))
+
+	receipt, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success())
+	assert.Equal(t, OpReverted, receipt.Ops[0].Status)
+	assert.Equal(t, OpFailed, receipt.Ops[1].Status)
+	assert.Equal(t, OpSkipped, receipt.Ops[2].Status)
+
+	// only the fee is paid
+	balance, err := bc.accountState.GetBalance(sender.PublicKey().Address(), NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(95), balance)
+
+	_, err = bc.accountState.GetAccount(alice.Address())
+	assert.Equal(t, ErrAccountNotFound, err)
+}
+
+func TestBatchTxReplayed(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	alice := crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	batch := func(nonce int64) *Transaction {
+		tx := &Transaction{
+			Nonce: nonce,
+			TxInner: BatchTx{Ops: []BatchOp{
+				{To: alice.PublicKey(), Value: 10},
+				{TxInner: ChannelOpenTx{Recipient: alice.PublicKey(), Deposit: 10, ChallengePeriod: bc.Params().MinChallengePeriod}},
+			}},
+		}
+		assert.Nil(t, tx.Sign(sender))
+		return tx
+	}
+	tx := batch(0)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+
+	b := nextBlock(t, bc, validator, []*Transaction{tx})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+	assertBalance(t, bc, sender.PublicKey().Address(), 80)
+
+	// the same operations under another nonce
+	again := batch(1)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{again})))
+	receipt, err := bc.GetReceipt(again.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success())
+	assertBalance(t, bc, sender.PublicKey().Address(), 60)
+}
+
+func TestBatchTxEncodeDecode(t *testing.T) {
+	tx := &Transaction{
+		TxInner: BatchTx{Ops: []BatchOp{
+			{To: crypto.GeneratePrivateKey().PublicKey(), Value: 30},
+		}},
+	}
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewGobTxEncoder(buf)))
+
+	txDecoded := new(Transaction)
+	assert.Nil(t, txDecoded.Decode(NewGobTxDecoder(buf)))
+	assert.Nil(t, txDecoded.Verify())
+	assert.Equal(t, tx.TxInner, txDecoded.TxInner)
+}
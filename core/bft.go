@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sharkchain/crypto"
+	"sharkchain/types"
+)
+
+type VoteType byte
+
+const (
+	Prevote VoteType = iota + 1
+	Precommit
+)
+
+func (t VoteType) String() string {
+	switch t {
+	case Prevote:
+		return "prevote"
+	case Precommit:
+		return "precommit"
+	default:
+		return "unknown"
+	}
+}
+
+// Vote is a prevote or precommit of a validator in a round of BFT
+// consensus. A zero BlockHash votes for no block.
+type Vote struct {
+	Type      VoteType
+	Height    uint32
+	Round     uint32
+	BlockHash types.Hash
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+func (v *Vote) Hash() types.Hash {
+	buf := &bytes.Buffer{}
+	buf.WriteString("vote")
+	binary.Write(buf, binary.LittleEndian, v.Type)
+	binary.Write(buf, binary.LittleEndian, v.Height)
+	binary.Write(buf, binary.LittleEndian, v.Round)
+	buf.Write(v.BlockHash.ToSlice())
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+func (v *Vote) Sign(signer crypto.Signer) error {
+	var (
+		sig *crypto.Signature
+		err error
+	)
+	if bs, ok := signer.(BlockSigner); ok {
+		sig, err = bs.SignVote(v)
+	} else {
+		hash := v.Hash()
+		sig, err = signer.Sign(hash.ToSlice())
+	}
+	if err != nil {
+		return err
+	}
+
+	v.Validator = signer.PublicKey()
+	v.Signature = sig
+
+	return nil
+}
+
+func (v *Vote) Verify() error {
+	if v.Signature == nil {
+		return fmt.Errorf("%s has no signature", v.Type)
+	}
+
+	hash := v.Hash()
+	if !v.Signature.Verify(v.Validator, hash.ToSlice()) {
+		return fmt.Errorf("invalid %s signature", v.Type)
+	}
+
+	return nil
+}
+
+// BlockProposal proposes a block for a round of BFT consensus. ValidRound is
+// the round the block got a 2/3 majority of prevotes in, -1 for a new block.
+// A block proposed again in a later round keeps the signature of its
+// producer, the proposal is signed by the proposer of the round.
+type BlockProposal struct {
+	Height     uint32
+	Round      uint32
+	ValidRound int32
+	Block      *Block
+	Proposer   crypto.PublicKey
+	Signature  *crypto.Signature
+}
+
+func (p *BlockProposal) Hash() types.Hash {
+	buf := &bytes.Buffer{}
+	buf.WriteString("proposal")
+	binary.Write(buf, binary.LittleEndian, p.Height)
+	binary.Write(buf, binary.LittleEndian, p.Round)
+	binary.Write(buf, binary.LittleEndian, p.ValidRound)
+	blockHash := p.Block.Hash(BlockHasher{})
+	buf.Write(blockHash.ToSlice())
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+func (p *BlockProposal) Sign(signer crypto.Signer) error {
+	var (
+		sig *crypto.Signature
+		err error
+	)
+	if bs, ok := signer.(BlockSigner); ok {
+		sig, err = bs.SignProposal(p)
+	} else {
+		hash := p.Hash()
+		sig, err = signer.Sign(hash.ToSlice())
+	}
+	if err != nil {
+		return err
+	}
+
+	p.Proposer = signer.PublicKey()
+	p.Signature = sig
+
+	return nil
+}
+
+func (p *BlockProposal) Verify() error {
+	if p.Block == nil || p.Block.Header == nil {
+		return errors.New("proposal has no block")
+	}
+	if p.Block.Height != p.Height {
+		return fmt.Errorf("proposal for height (%d) carries block (%d)", p.Height, p.Block.Height)
+	}
+	if p.Signature == nil {
+		return errors.New("proposal has no signature")
+	}
+
+	hash := p.Hash()
+	if !p.Signature.Verify(p.Proposer, hash.ToSlice()) {
+		return errors.New("invalid proposal signature")
+	}
+
+	return nil
+}
+
+// CommitCertificate proves that more than 2/3 of the validators precommitted
+// the block in the same round. Blocks carrying one are final.
+type CommitCertificate struct {
+	Height     uint32
+	Round      uint32
+	BlockHash  types.Hash
+	Precommits []*Vote
+}
+
+func (c *CommitCertificate) Verify(params Params) error {
+	signed := make(map[types.Address]bool, len(c.Precommits))
+	power := uint64(0)
+	for _, v := range c.Precommits {
+		if v.Type != Precommit || v.Height != c.Height || v.Round != c.Round || v.BlockHash != c.BlockHash {
+			return fmt.Errorf("commit certificate of block (%d) holds a foreign vote", c.Height)
+		}
+
+		address := v.Validator.Address()
+		if params.Power(address) == 0 {
+			return fmt.Errorf("commit certificate of block (%d) holds a vote of (%s) which is not a validator", c.Height, address)
+		}
+		if signed[address] {
+			return fmt.Errorf("commit certificate of block (%d) holds two votes of (%s)", c.Height, address)
+		}
+		if err := v.Verify(); err != nil {
+			return err
+		}
+
+		signed[address] = true
+		power += params.Power(address)
+	}
+
+	if !HasQuorum(power, params.TotalPower()) {
+		return fmt.Errorf("commit certificate of block (%d) has a power of %d out of %d", c.Height, power, params.TotalPower())
+	}
+
+	return nil
+}
+
+// HasQuorum reports whether the voting power is more than 2/3 of total.
+func HasQuorum(power, total uint64) bool {
+	hi, lo := bits.Mul64(power, 3)
+	totalHi, totalLo := bits.Mul64(total, 2)
+
+	return hi > totalHi || (hi == totalHi && lo > totalLo)
+}
+
+// BFTEngine accepts blocks committed by more than 2/3 of the validators in
+// propose, prevote and precommit rounds. The rounds are run by the network
+// layer, the engine checks their outcome.
+type BFTEngine struct {
+	SignerEngine
+}
+
+func (e *BFTEngine) VerifySeal(bc *Blockchain, b *Block) error {
+	if b.Difficulty != 0 || b.Nonce != 0 {
+		return fmt.Errorf("block (%d) is mined but the chain does not use proof-of-work", b.Height)
+	}
+
+	c := b.Commit
+	if c == nil {
+		return fmt.Errorf("block (%d) has no commit certificate", b.Height)
+	}
+	if c.Height != b.Height || c.BlockHash != b.Hash(BlockHasher{}) {
+		return fmt.Errorf("commit certificate of block (%d) is for another block", b.Height)
+	}
+	if err := c.Verify(e.params); err != nil {
+		return err
+	}
+
+	// the block may have been proposed again in a later round than the one
+	// it was produced in
+	if !e.params.IsValidator(b.Validator.Address()) {
+		return fmt.Errorf("block (%d) signed by (%s) which is not a validator", b.Height, b.Validator.Address())
+	}
+
+	return nil
+}
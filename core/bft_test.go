@@ -0,0 +1,74 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func newBFTBlockchain(t *testing.T, n int) (*Blockchain, []crypto.PrivateKey) {
+	bc, validators := newBlockchainWithValidators(t, n)
+	setParams(t, bc, func(p *Params) { p.Consensus = ConsensusBFT })
+
+	return bc, validators
+}
+
+// commitBlock adds the precommits of the given validators to the block.
+func commitBlock(t *testing.T, b *Block, round uint32, validators []crypto.PrivateKey) {
+	b.Commit = &CommitCertificate{
+		Height:    b.Height,
+		Round:     round,
+		BlockHash: b.Hash(BlockHasher{}),
+	}
+	for _, v := range validators {
+		vote := &Vote{Type: Precommit, Height: b.Height, Round: round, BlockHash: b.Hash(BlockHasher{})}
+		assert.Nil(t, vote.Sign(v))
+		b.Commit.Precommits = append(b.Commit.Precommits, vote)
+	}
+}
+
+func TestAddCommittedBlock(t *testing.T) {
+	bc, validators := newBFTBlockchain(t, 4)
+
+	b := nextBlock(t, bc, validators[0], nil)
+	assert.NotNil(t, bc.AddBlock(b))
+
+	// two out of four is not enough
+	commitBlock(t, b, 0, validators[:2])
+	assert.NotNil(t, bc.AddBlock(b))
+
+	commitBlock(t, b, 1, validators[1:])
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.FinalizedHeight())
+}
+
+func TestCommitCertificateVerify(t *testing.T) {
+	bc, validators := newBFTBlockchain(t, 4)
+	params := bc.Params()
+
+	b := nextBlock(t, bc, validators[0], nil)
+	commitBlock(t, b, 0, validators[:3])
+	assert.Nil(t, b.Commit.Verify(params))
+
+	// the same validator twice
+	b.Commit.Precommits[2] = b.Commit.Precommits[1]
+	assert.NotNil(t, b.Commit.Verify(params))
+
+	commitBlock(t, b, 0, []crypto.PrivateKey{validators[0], validators[1], crypto.GeneratePrivateKey()})
+	assert.NotNil(t, b.Commit.Verify(params))
+
+	// a vote for another round
+	commitBlock(t, b, 0, validators[:3])
+	b.Commit.Precommits[0].Round = 1
+	assert.NotNil(t, b.Commit.Verify(params))
+}
+
+func TestValidateProposal(t *testing.T) {
+	bc, validators := newBFTBlockchain(t, 4)
+
+	b := nextBlock(t, bc, validators[0], nil)
+	assert.Nil(t, bc.ValidateProposal(b))
+	assert.NotNil(t, bc.AddBlock(b))
+
+	assert.NotNil(t, bc.ValidateProposal(nextBlock(t, bc, crypto.GeneratePrivateKey(), []*Transaction{{}})))
+}
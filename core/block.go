@@ -16,7 +16,16 @@ type Header struct {
 	PrevBlockHash types.Hash
 	Timestamp     int64
 	Height        uint32
-	Nonce         uint64
+	// Difficulty and Nonce are only set on mined blocks, the hash of the
+	// header has to meet the difficulty.
+	Difficulty uint64
+	Nonce      uint64
+	// EvidenceHash commits to the evidence of the block, it is zero when
+	// the block carries none.
+	EvidenceHash types.Hash
+	// VRFProof proves that the producer was elected for the slot of the
+	// block, it is only set under ConsensusVRF.
+	VRFProof []byte
 }
 
 func (h *Header) Bytes() []byte {
@@ -33,6 +42,12 @@ type Block struct {
 	Transactions []*Transaction
 	Validator    crypto.PublicKey
 	Signature    *crypto.Signature
+	// Commit is set on blocks agreed on in BFT rounds, it is not part of
+	// the hash.
+	Commit *CommitCertificate
+	// Evidence of validators that signed conflicting blocks, they are
+	// slashed when the block is added.
+	Evidence []*DoubleSignEvidence
 
 	// cached version of the header hash
 	hash types.Hash
@@ -68,25 +83,38 @@ func (b *Block) AddTransaction(tx *Transaction) {
 	b.DataHash = hash
 }
 
-func (b *Block) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(b.Header.Bytes())
+// AddEvidence includes evidence in the block, before it is signed.
+func (b *Block) AddEvidence(e *DoubleSignEvidence) {
+	b.Evidence = append(b.Evidence, e)
+	b.EvidenceHash = CalculateEvidenceHash(b.Evidence)
+}
+
+// Sign signs the hash of the header, ECDSA would only cover the first bytes
+// of the encoded header. A BlockSigner is handed the header itself.
+func (b *Block) Sign(signer crypto.Signer) error {
+	var (
+		sig *crypto.Signature
+		err error
+	)
+	if bs, ok := signer.(BlockSigner); ok {
+		sig, err = bs.SignHeader(b.Header)
+	} else {
+		hash := BlockHasher{}.Hash(b.Header)
+		sig, err = signer.Sign(hash.ToSlice())
+	}
 	if err != nil {
 		return err
 	}
 
-	b.Validator = privKey.PublicKey()
+	b.Validator = signer.PublicKey()
 	b.Signature = sig
 
 	return nil
 }
 
 func (b *Block) Verify() error {
-	if b.Signature == nil {
-		return fmt.Errorf("block has no signature")
-	}
-
-	if !b.Signature.Verify(b.Validator, b.Header.Bytes()) {
-		return fmt.Errorf("invalid block signature")
+	if err := b.VerifySignature(); err != nil {
+		return err
 	}
 
 	// also verify all tx
@@ -99,6 +127,24 @@ func (b *Block) Verify() error {
 	return nil
 }
 
+// VerifySignature checks the signature of the producer only, not the ones
+// of the transactions.
+func (b *Block) VerifySignature() error {
+	if b.Signature == nil {
+		return fmt.Errorf("block has no signature")
+	}
+	if err := b.Validator.Validate(); err != nil {
+		return err
+	}
+
+	hash := BlockHasher{}.Hash(b.Header)
+	if !b.Signature.Verify(b.Validator, hash.ToSlice()) {
+		return fmt.Errorf("invalid block signature")
+	}
+
+	return nil
+}
+
 func (b *Block) Decode(dec Decoder[*Block]) error {
 	return dec.Decode(b)
 }
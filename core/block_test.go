@@ -16,10 +16,14 @@ func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
 	privKey := crypto.GeneratePrivateKey()
 	tx := randomTxWithSignature(t)
 
+	dataHash, err := CalculateDataHash([]*Transaction{tx})
+	assert.Nil(t, err)
+
 	header := &Header{
 		Version:       1,
 		PrevBlockHash: prevBlockHash,
 		Height:        height,
+		DataHash:      dataHash,
 		Timestamp:     time.Now().UnixNano(),
 	}
 
@@ -54,4 +58,9 @@ func TestVerifyBlock(t *testing.T) {
 	b.Validator = otherPrivKey.PublicKey()
 
 	assert.NotNil(t, b.Verify())
+
+	// the signature covers the whole header
+	b.Validator = privKey.PublicKey()
+	b.Nonce++
+	assert.NotNil(t, b.Verify())
 }
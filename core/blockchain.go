@@ -3,6 +3,7 @@ package core
 import (
 	"fmt"
 	"github.com/go-kit/log"
+	"math/big"
 	"sharkchain/crypto"
 	"sharkchain/types"
 	"sync"
@@ -12,13 +13,45 @@ type Blockchain struct {
 	logger log.Logger
 	store  Storage
 
-	lock       sync.RWMutex
-	headers    []*Header
-	blocks     []*Block
-	txStore    map[types.Hash]*Transaction
-	blockStore map[types.Hash]*Block
+	// addLock serializes adding blocks, a reorg spans several steps.
+	addLock sync.Mutex
+
+	lock sync.RWMutex
+	// base is the height of the first header, above zero when the chain
+	// started from a snapshot. Up to the snapshot only headers are held, the
+	// blocks and diffs there are nil.
+	base    uint32
+	headers []*Header
+	blocks  []*Block
+	// diffs holds the state changes of every block of the chain.
+	diffs        []*StateDiff
+	txStore      map[types.Hash]*Transaction
+	blockStore   map[types.Hash]*Block
+	receiptStore map[types.Hash]*Receipt
+	// snapshotTxs are the hashes of the txs up to the snapshot the chain
+	// started from.
+	snapshotTxs map[types.Hash]bool
+	// totalWork is the work of all blocks, the fork choice follows the
+	// chain with the most work.
+	totalWork *big.Int
+	// work is the total work of the chain ending at every known block.
+	work map[types.Hash]*big.Int
+	// sideBlocks are the blocks of competing branches by hash.
+	sideBlocks map[types.Hash]*Block
+	// reorgHandler is called with the blocks a reorg removed and added.
+	reorgHandler func(removed, added []*Block)
+	// finalized is the height of the last block with a commit certificate
+	// or a trusted checkpoint, it can never be reverted.
+	finalized uint32
+	// trusted are the trusted checkpoints by height.
+	trusted map[uint32]Checkpoint
+	// checkpoints are the finalized blocks recorded as checkpoints.
+	checkpoints []Checkpoint
+	// snapshots are the latest snapshots of the state, oldest first.
+	snapshots []*Snapshot
 
 	accountState *AccountState
+	forkSchedule ForkSchedule
 
 	stateLock       sync.RWMutex
 	collectionState map[types.Hash]*CollectionTx
@@ -41,13 +74,18 @@ func NewBlockchain(l log.Logger, genesis *Block) (*Blockchain, error) {
 	bc := &Blockchain{
 		contractState:   NewState(),
 		headers:         []*Header{},
+		totalWork:       new(big.Int),
+		work:            make(map[types.Hash]*big.Int),
+		sideBlocks:      make(map[types.Hash]*Block),
 		store:           NewMemoryStore(),
 		logger:          l,
 		accountState:    accountState,
+		forkSchedule:    DefaultForkSchedule,
 		collectionState: make(map[types.Hash]*CollectionTx),
 		mintState:       make(map[types.Hash]*MintTx),
 		blockStore:      make(map[types.Hash]*Block),
 		txStore:         make(map[types.Hash]*Transaction),
+		receiptStore:    make(map[types.Hash]*Receipt),
 	}
 
 	bc.validator = NewBlockValidator(bc)
@@ -60,7 +98,43 @@ func (bc *Blockchain) SetValidator(v Validator) {
 	bc.validator = v
 }
 
+// SetForkSchedule must be called before any block other than the genesis is added.
+func (bc *Blockchain) SetForkSchedule(s ForkSchedule) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	bc.forkSchedule = s
+	return nil
+}
+
+// VersionAt returns the protocol version the block at the given height must carry.
+func (bc *Blockchain) VersionAt(height uint32) uint32 {
+	return bc.forkSchedule.VersionAt(height)
+}
+
+// RulesAt returns the consensus rules in effect at the given height.
+func (bc *Blockchain) RulesAt(height uint32) Rules {
+	// the schedule is validated, so the version is always known
+	rules, _ := RulesFor(bc.VersionAt(height))
+	return rules
+}
+
+// AddBlock adds a block extending the chain or one of its competing
+// branches, the chain follows the branch with the most work.
 func (bc *Blockchain) AddBlock(b *Block) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	if b.Header != nil && !bc.knowsBlock(b.PrevBlockHash) {
+		return fmt.Errorf("%w: block (%d) builds on (%s)", ErrUnknownParent, b.Height, b.PrevBlockHash)
+	}
+	if err := bc.checkCheckpoint(b); err != nil {
+		return err
+	}
+	if bc.isSideBlock(b) {
+		return bc.addSideBlock(b)
+	}
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
@@ -69,14 +143,15 @@ func (bc *Blockchain) AddBlock(b *Block) error {
 }
 
 func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
-	if height > bc.Height() {
-		return nil, fmt.Errorf("given height (%d) too high", height)
-	}
-
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 
-	return bc.headers[height], nil
+	i, err := bc.index(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.headers[i], nil
 }
 
 func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
@@ -92,14 +167,31 @@ func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
 }
 
 func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
-	if height > bc.Height() {
-		return nil, fmt.Errorf("given height (%d) too high", height)
-	}
-
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 
-	return bc.blocks[height], nil
+	i, err := bc.index(height)
+	if err != nil {
+		return nil, err
+	}
+	if bc.blocks[i] == nil {
+		return nil, fmt.Errorf("block (%d) is part of the snapshot the chain started from", height)
+	}
+
+	return bc.blocks[i], nil
+}
+
+// index returns the position of the given height in the headers, the
+// blocks and the diffs. The lock must be held.
+func (bc *Blockchain) index(height uint32) (int, error) {
+	if height < bc.base {
+		return 0, fmt.Errorf("given height (%d) is below the snapshot the chain started from", height)
+	}
+	if int(height-bc.base) >= len(bc.headers) {
+		return 0, fmt.Errorf("given height (%d) too high", height)
+	}
+
+	return int(height - bc.base), nil
 }
 
 func (bc *Blockchain) GetTxByHash(hash types.Hash) (*Transaction, error) {
@@ -114,7 +206,93 @@ func (bc *Blockchain) GetTxByHash(hash types.Hash) (*Transaction, error) {
 	return tx, nil
 }
 
-func (bc *Blockchain) handleTransaction(tx *Transaction) error {
+func (bc *Blockchain) HasTx(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	_, ok := bc.txStore[hash]
+	return ok || bc.snapshotTxs[hash]
+}
+
+func (bc *Blockchain) GetReceipt(txHash types.Hash) (*Receipt, error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	receipt, ok := bc.receiptStore[txHash]
+	if !ok {
+		return nil, fmt.Errorf("could not find receipt for tx with hash (%s)", txHash)
+	}
+
+	return receipt, nil
+}
+
+// handleTransaction charges the fee of the tx and applies it. When an error is
+// returned the state is left untouched and the tx must not be included.
+func (bc *Blockchain) handleTransaction(tx *Transaction, b *Block) (*Receipt, error) {
+	batch, isBatch := tx.TxInner.(BatchTx)
+	if isBatch && tx.Value > 0 {
+		return nil, fmt.Errorf("batch tx can't carry a value, use a transfer operation instead")
+	}
+
+	// the hash covers the nonce, a tx is only sent twice with another one
+	if bc.HasTx(tx.Hash(TxHasher{})) {
+		return nil, fmt.Errorf("tx (%s) is already part of the chain", tx.Hash(TxHasher{}))
+	}
+
+	// the allocations of the genesis block are free
+	if minFee := bc.params().MinFee; b.Height > 0 && tx.Fee < minFee {
+		return nil, fmt.Errorf("tx fee (%d) is below the minimum fee (%d)", tx.Fee, minFee)
+	}
+
+	if tx.Multisig != nil {
+		if err := bc.checkMultisigSigners(tx.Multisig); err != nil {
+			return nil, err
+		}
+	}
+
+	// make sure the sender covers the fee and the value before touching any balance
+	from := tx.Sender()
+	if tx.Fee > 0 || tx.Value > 0 {
+		if err := bc.accountState.CanSpend(from, []AssetAmount{
+			{Asset: NativeAsset, Amount: tx.Fee},
+			{Asset: tx.Asset, Amount: tx.Value},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshot := bc.snapshot()
+
+	if tx.Fee > 0 {
+		if err := bc.accountState.Transfer(from, b.Validator.Address(), NativeAsset, tx.Fee); err != nil {
+			return nil, err
+		}
+	}
+
+	receipt := &Receipt{TxHash: tx.Hash(TxHasher{})}
+
+	if !isBatch {
+		if err := bc.executeTransaction(tx, b); err != nil {
+			bc.revertToSnapshot(snapshot)
+			return nil, err
+		}
+
+		return receipt, nil
+	}
+
+	// A failing batch stays in the block and pays its fee, only the
+	// operations are undone.
+	afterFee := bc.snapshot()
+	if err := bc.handleBatch(tx, b, batch, receipt); err != nil {
+		bc.revertToSnapshot(afterFee)
+		receipt.Err = err.Error()
+	}
+
+	return receipt, nil
+}
+
+// executeTransaction applies everything of the tx except its fee as part of block b.
+func (bc *Blockchain) executeTransaction(tx *Transaction, b *Block) error {
 	// TODO we just ignore these actions temporarily
 	// If we have data inside execute that data on the VM.
 	//if len(tx.Data) > 0 {
@@ -133,38 +311,173 @@ func (bc *Blockchain) handleTransaction(tx *Transaction) error {
 	//		return err
 	//	}
 	//}
-	//
-	//// Handle the native transaction here
-	//if tx.Value > 0 {
-	//	if err := bc.handleNativeTransfer(tx); err != nil {
-	//		return err
-	//	}
-	//}
+
+	// Handle the native transaction here
+	if tx.Value > 0 {
+		if err := bc.handleNativeTransfer(tx); err != nil {
+			return err
+		}
+	}
+
+	switch t := tx.TxInner.(type) {
+	case BatchTx:
+		return ErrNestedBatch
+	case MultisigUpdateTx:
+		return bc.handleMultisigUpdate(tx, t)
+	case VestingTx:
+		return bc.handleVesting(tx, t)
+	case HTLCLockTx:
+		return bc.handleHTLCLock(tx, b, t)
+	case HTLCClaimTx:
+		return bc.handleHTLCClaim(b, t)
+	case HTLCRefundTx:
+		return bc.handleHTLCRefund(b, t)
+	case ChannelOpenTx:
+		return bc.handleChannelOpen(tx, t)
+	case ChannelCloseTx:
+		return bc.handleChannelClose(tx, b, t)
+	case ChannelChallengeTx:
+		return bc.handleChannelChallenge(b, t)
+	case ChannelSettleTx:
+		return bc.handleChannelSettle(b, t)
+	case ParamsTx:
+		return bc.handleParams(b, t)
+	case ProposalTx:
+		return bc.handleProposal(tx, b, t)
+	case ValidatorProposalTx:
+		return bc.handleValidatorProposal(tx, b, t)
+	case StakeTx:
+		return bc.handleStake(tx, t)
+	case DelegateTx:
+		return bc.handleDelegate(tx, t)
+	case UndelegateTx:
+		return bc.handleUndelegate(tx, b, t)
+	case UnbondTx:
+		return bc.handleUnbond(tx, b)
+	case VoteTx:
+		return bc.handleVote(tx, t)
+	}
 
 	return nil
 }
 
+// stateSnapshot identifies a point all chain states can be reverted to.
+type stateSnapshot struct {
+	accounts int
+	contract int
+}
+
+func (bc *Blockchain) snapshot() stateSnapshot {
+	return stateSnapshot{
+		accounts: bc.accountState.Snapshot(),
+		contract: bc.contractState.Snapshot(),
+	}
+}
+
+func (bc *Blockchain) revertToSnapshot(s stateSnapshot) {
+	bc.accountState.RevertToSnapshot(s.accounts)
+	bc.contractState.RevertToSnapshot(s.contract)
+}
+
+func (bc *Blockchain) handleNativeTransfer(tx *Transaction) error {
+	bc.logger.Log(
+		"msg", "handle native transfer",
+		"from", tx.Sender(),
+		"to", tx.To.Address(),
+		"asset", tx.Asset,
+		"value", tx.Value,
+	)
+
+	return bc.accountState.Transfer(tx.Sender(), tx.To.Address(), tx.Asset, tx.Value)
+}
+
+// TotalSupply returns the amount of the given asset in circulation.
+func (bc *Blockchain) TotalSupply(asset AssetID) uint64 {
+	return bc.accountState.TotalSupply(asset)
+}
+
+// GetBalances returns every asset balance held by the given address.
+func (bc *Blockchain) GetBalances(address types.Address) (map[AssetID]uint64, error) {
+	return bc.accountState.GetBalances(address)
+}
+
 func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
 	bc.stateLock.Lock()
-	for i := 0; i < len(b.Transactions); i++ {
-		if err := bc.handleTransaction(b.Transactions[i]); err != nil {
+	engine := bc.engine()
+	work := engine.Work(b.Header)
+	interval := bc.params().CheckpointInterval
+	bc.accountState.SetBlock(b.Height, b.Timestamp)
+
+	// the transactions of the block stay as they are, its data hash covers
+	// them, the failing ones are recorded as dropped by the state diff
+	var (
+		dropped  = []types.Hash{}
+		receipts = make([]*Receipt, 0, len(b.Transactions))
+	)
+	for _, tx := range b.Transactions {
+		receipt, err := bc.handleTransaction(tx, b)
+		if err != nil {
 			bc.logger.Log("handle transaction error", err.Error())
-
-			b.Transactions[i] = b.Transactions[len(b.Transactions)-1]
-			b.Transactions = b.Transactions[:len(b.Transactions)-1]
-
+			dropped = append(dropped, tx.Hash(TxHasher{}))
 			continue
 		}
+
+		receipt.Height = b.Height
+		receipts = append(receipts, receipt)
+	}
+
+	if err := bc.applyEvidence(b); err != nil {
+		bc.logger.Log("apply evidence error", err.Error())
+	}
+
+	if err := engine.Finalize(bc, b); err != nil {
+		bc.logger.Log("finalize block error", err.Error())
+	}
+
+	if err := bc.activateProposals(b); err != nil {
+		bc.logger.Log("activate proposals error", err.Error())
 	}
+	if err := bc.updateValidatorSet(b); err != nil {
+		bc.logger.Log("update validator set error", err.Error())
+	}
+
+	diff := bc.stateDiff(b, dropped, bc.accountState.Commit(), bc.contractState.Commit())
 	bc.stateLock.Unlock()
 
+	if err := bc.appendBlock(b, diff, receipts, work, interval); err != nil {
+		return err
+	}
+
+	return bc.takeSnapshot(b)
+}
+
+// appendBlock makes the block whose state changes are applied the head of
+// the chain.
+func (bc *Blockchain) appendBlock(b *Block, diff *StateDiff, receipts []*Receipt, work *big.Int, interval uint32) error {
+	hash := b.Hash(BlockHasher{})
+
 	bc.lock.Lock()
 	bc.headers = append(bc.headers, b.Header)
 	bc.blocks = append(bc.blocks, b)
-	bc.blockStore[b.Hash(BlockHasher{})] = b
+	bc.diffs = append(bc.diffs, diff)
+	bc.totalWork.Add(bc.totalWork, work)
+	bc.work[hash] = new(big.Int).Set(bc.totalWork)
+	bc.blockStore[hash] = b
+	if bc.finalizes(b) {
+		bc.finalized = b.Height
+	}
+	bc.pruneSideBlocks()
+	if b.Commit != nil && interval > 0 && b.Height%interval == 0 {
+		bc.checkpoints = append(bc.checkpoints, Checkpoint{Height: b.Height, Hash: hash})
+	}
 
 	for _, tx := range b.Transactions {
-		bc.txStore[tx.Hash(TxHasher{})] = tx
+		if diff.Applied(tx) {
+			bc.txStore[tx.Hash(TxHasher{})] = tx
+		}
+	}
+	for _, receipt := range receipts {
+		bc.receiptStore[receipt.TxHash] = receipt
 	}
 	bc.lock.Unlock()
 
@@ -175,7 +488,56 @@ func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
 		"transactions", len(b.Transactions),
 	)
 
-	return bc.store.Put(b)
+	if err := bc.store.Put(b); err != nil {
+		return err
+	}
+
+	return bc.store.PutStateDiff(diff)
+}
+
+// TotalWork returns the work of all blocks in the chain.
+func (bc *Blockchain) TotalWork() *big.Int {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return new(big.Int).Set(bc.totalWork)
+}
+
+// FinalizedHeight returns the height of the last final block.
+func (bc *Blockchain) FinalizedHeight() uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.finalized
+}
+
+// ValidateProposal checks a block proposed for the next height without
+// requiring the consensus to have sealed it, e.g. before voting on it.
+func (bc *Blockchain) ValidateProposal(b *Block) error {
+	return NewBlockValidator(bc).validateUnsealed(b)
+}
+
+// NextDifficulty returns the difficulty the next block has to be mined at,
+// zero when blocks are not mined.
+func (bc *Blockchain) NextDifficulty() uint64 {
+	params := bc.Params()
+	if params.PoW == nil {
+		return 0
+	}
+
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return nextDifficulty(bc.headers, *params.PoW, params)
+}
+
+// MedianTimePast returns the median timestamp of the last blocks, a mined
+// block has to be younger, whatever the protocol version.
+func (bc *Blockchain) MedianTimePast() int64 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return medianTimePast(bc.headers)
 }
 
 func (bc *Blockchain) HasBlock(height uint32) bool {
@@ -184,12 +546,12 @@ func (bc *Blockchain) HasBlock(height uint32) bool {
 
 // [0, 1, 2] : height=2
 func (bc *Blockchain) Height() uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
 	if len(bc.headers) == 0 {
 		return 0
 	}
 
-	bc.lock.RLock()
-	defer bc.lock.RUnlock()
-
-	return uint32(len(bc.headers) - 1) // maybe -1
+	return bc.base + uint32(len(bc.headers)-1)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
 	"sharkchain/types"
 	"testing"
 )
@@ -47,5 +48,97 @@ func TestAddBlock(t *testing.T) {
 func TestAddBlockTooHigh(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
-	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})))
+	assert.ErrorIs(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})), ErrUnknownParent)
+}
+
+func nextBlock(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey, txx []*Transaction) *Block {
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(prevHeader, txx)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+// appliedTxs returns the number of txs of the block the chain applied, the
+// others were dropped.
+func appliedTxs(t *testing.T, bc *Blockchain, b *Block) int {
+	d, err := bc.GetStateDiff(b.Height)
+	assert.Nil(t, err)
+
+	return len(b.Transactions) - len(d.Dropped)
+}
+
+// setParams changes the chain parameters without going through governance.
+func setParams(t *testing.T, bc *Blockchain, change func(*Params)) {
+	params := bc.Params()
+	change(&params)
+	assert.Nil(t, bc.contractState.putGob(paramsKey, params))
+}
+
+func TestBlockRewardAndFees(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	setParams(t, bc, func(p *Params) { p.Reward = RewardSchedule{InitialReward: 10} })
+
+	sender := crypto.GeneratePrivateKey()
+	validator := crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	tx := &Transaction{
+		To:    crypto.GeneratePrivateKey().PublicKey(),
+		Value: 20,
+		Fee:   5,
+	}
+	assert.Nil(t, tx.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+
+	balance, err := bc.accountState.GetBalance(validator.PublicKey().Address(), NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(15), balance)
+
+	balance, err = bc.accountState.GetBalance(sender.PublicKey().Address(), NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(75), balance)
+
+	assert.Equal(t, uint64(110), bc.TotalSupply(NativeAsset))
+}
+
+func TestAddBlockWithExpiredTx(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+
+	tx := NewTransaction([]byte("foo"))
+	tx.ValidUntilHeight = 1
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+
+	tx = NewTransaction([]byte("bar"))
+	tx.ValidUntilHeight = 1
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.ErrorIs(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})), ErrTxExpired)
+}
+
+func TestReplayedTx(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey().PublicKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	tx := &Transaction{To: recipient, Value: 20}
+	assert.Nil(t, tx.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+
+	b := nextBlock(t, bc, validator, []*Transaction{tx})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+	assertBalance(t, bc, sender.PublicKey().Address(), 80)
+
+	// the same transfer again needs another nonce
+	again := &Transaction{To: recipient, Value: 20, Nonce: 1}
+	assert.Nil(t, again.Sign(sender))
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{again, again})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{again})))
+	assertBalance(t, bc, sender.PublicKey().Address(), 60)
 }
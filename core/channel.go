@@ -0,0 +1,266 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sharkchain/crypto"
+	"sharkchain/types"
+)
+
+var (
+	ErrChannelNotFound = errors.New("payment channel not found")
+	ErrChannelClosed   = errors.New("payment channel already closed")
+)
+
+// channelEscrow holds the deposits of all payment channels.
+var channelEscrow = ModuleAddress("channel")
+
+type ChannelStatus byte
+
+const (
+	ChannelOpen ChannelStatus = iota
+	// ChannelClosing is waiting for the challenge period to end.
+	ChannelClosing
+	ChannelClosed
+)
+
+func (s ChannelStatus) String() string {
+	switch s {
+	case ChannelOpen:
+		return "open"
+	case ChannelClosing:
+		return "closing"
+	case ChannelClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Channel is a unidirectional payment channel from Sender to Recipient as
+// tracked in the chain state. Its ID is the hash of the tx that opened it.
+type Channel struct {
+	ID              types.Hash
+	Sender          crypto.PublicKey
+	Recipient       crypto.PublicKey
+	Asset           AssetID
+	Deposit         uint64
+	ChallengePeriod uint32
+	Status          ChannelStatus
+	// ClosingAmount is the amount owed to the recipient once closed.
+	ClosingAmount uint64
+	// ClosesAt is the first height the closing channel can be settled at.
+	ClosesAt uint32
+}
+
+// Voucher states the total amount the sender of a channel owes the recipient.
+// Vouchers are signed off-chain, each new one replaces the previous.
+type Voucher struct {
+	Channel types.Hash
+	Amount  uint64
+}
+
+func (v Voucher) Hash() types.Hash {
+	buf := &bytes.Buffer{}
+	buf.WriteString("voucher")
+	buf.Write(v.Channel.ToSlice())
+	binary.Write(buf, binary.LittleEndian, v.Amount)
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+func (v Voucher) Sign(privKey crypto.PrivateKey) (*crypto.Signature, error) {
+	hash := v.Hash()
+
+	return privKey.Sign(hash.ToSlice())
+}
+
+func (v Voucher) Verify(pubKey crypto.PublicKey, sig *crypto.Signature) bool {
+	if sig == nil {
+		return false
+	}
+
+	hash := v.Hash()
+	return sig.Verify(pubKey, hash.ToSlice())
+}
+
+// ChannelOpenTx opens a channel from the sender of the tx to Recipient by
+// depositing Deposit of Asset.
+type ChannelOpenTx struct {
+	Recipient       crypto.PublicKey
+	Asset           AssetID
+	Deposit         uint64
+	ChallengePeriod uint32
+}
+
+// ChannelCloseTx closes a channel. When the voucher is signed by both parties
+// the channel is settled right away. Otherwise one of the parties closes it
+// unilaterally with the latest voucher signed by the sender, or with an
+// amount of zero if there is none, and the challenge period starts.
+type ChannelCloseTx struct {
+	Voucher      Voucher
+	SenderSig    *crypto.Signature
+	RecipientSig *crypto.Signature
+}
+
+// ChannelChallengeTx replaces the amount of a closing channel by a higher
+// one, backed by a voucher signed by the sender.
+type ChannelChallengeTx struct {
+	Voucher   Voucher
+	SenderSig *crypto.Signature
+}
+
+// ChannelSettleTx pays out a closing channel once the challenge period is over.
+type ChannelSettleTx struct {
+	Channel types.Hash
+}
+
+func channelKey(id types.Hash) []byte {
+	return append([]byte("channel/"), id.ToSlice()...)
+}
+
+func (bc *Blockchain) getChannel(id types.Hash) (*Channel, error) {
+	channel := &Channel{}
+	found, err := bc.contractState.getGob(channelKey(id), channel)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrChannelNotFound
+	}
+
+	return channel, nil
+}
+
+func (bc *Blockchain) handleChannelOpen(tx *Transaction, open ChannelOpenTx) error {
+	if tx.Multisig != nil {
+		return fmt.Errorf("payment channels need a single sender key")
+	}
+	if open.Deposit == 0 {
+		return fmt.Errorf("channel deposit can't be zero")
+	}
+	// the recipient signs the vouchers closing the channel
+	if err := open.Recipient.Validate(); err != nil {
+		return fmt.Errorf("invalid channel recipient: %w", err)
+	}
+	if minPeriod := bc.params().MinChallengePeriod; open.ChallengePeriod < minPeriod {
+		return fmt.Errorf("channel challenge period (%d) is shorter than the minimum (%d)", open.ChallengePeriod, minPeriod)
+	}
+
+	id := tx.Hash(TxHasher{})
+	if _, err := bc.getChannel(id); err != ErrChannelNotFound {
+		return fmt.Errorf("channel (%s) already exists", id)
+	}
+
+	if err := bc.accountState.Transfer(tx.Sender(), channelEscrow, open.Asset, open.Deposit); err != nil {
+		return err
+	}
+
+	return bc.contractState.putGob(channelKey(id), &Channel{
+		ID:              id,
+		Sender:          tx.From,
+		Recipient:       open.Recipient,
+		Asset:           open.Asset,
+		Deposit:         open.Deposit,
+		ChallengePeriod: open.ChallengePeriod,
+		Status:          ChannelOpen,
+	})
+}
+
+func (bc *Blockchain) handleChannelClose(tx *Transaction, b *Block, closeTx ChannelCloseTx) error {
+	channel, err := bc.getChannel(closeTx.Voucher.Channel)
+	if err != nil {
+		return err
+	}
+	if channel.Status != ChannelOpen {
+		return ErrChannelClosed
+	}
+	if closeTx.Voucher.Amount > channel.Deposit {
+		return fmt.Errorf("voucher amount (%d) exceeds the channel deposit (%d)", closeTx.Voucher.Amount, channel.Deposit)
+	}
+
+	signedBySender := closeTx.Voucher.Verify(channel.Sender, closeTx.SenderSig)
+	if signedBySender && closeTx.Voucher.Verify(channel.Recipient, closeTx.RecipientSig) {
+		channel.ClosingAmount = closeTx.Voucher.Amount
+		return bc.settleChannel(channel)
+	}
+
+	sender := tx.Sender()
+	if tx.Multisig != nil || (sender != channel.Sender.Address() && sender != channel.Recipient.Address()) {
+		return fmt.Errorf("only the parties of channel (%s) can close it", channel.ID)
+	}
+	if closeTx.Voucher.Amount > 0 && !signedBySender {
+		return fmt.Errorf("voucher for channel (%s) is not signed by its sender", channel.ID)
+	}
+
+	channel.Status = ChannelClosing
+	channel.ClosingAmount = closeTx.Voucher.Amount
+	channel.ClosesAt = b.Height + channel.ChallengePeriod + 1
+
+	return bc.contractState.putGob(channelKey(channel.ID), channel)
+}
+
+func (bc *Blockchain) handleChannelChallenge(b *Block, challenge ChannelChallengeTx) error {
+	channel, err := bc.getChannel(challenge.Voucher.Channel)
+	if err != nil {
+		return err
+	}
+	if channel.Status != ChannelClosing || b.Height >= channel.ClosesAt {
+		return fmt.Errorf("channel (%s) is not in its challenge period", channel.ID)
+	}
+	if !challenge.Voucher.Verify(channel.Sender, challenge.SenderSig) {
+		return fmt.Errorf("voucher for channel (%s) is not signed by its sender", channel.ID)
+	}
+	if challenge.Voucher.Amount > channel.Deposit {
+		return fmt.Errorf("voucher amount (%d) exceeds the channel deposit (%d)", challenge.Voucher.Amount, channel.Deposit)
+	}
+	if challenge.Voucher.Amount <= channel.ClosingAmount {
+		return fmt.Errorf("voucher amount (%d) doesn't exceed the closing amount (%d)", challenge.Voucher.Amount, channel.ClosingAmount)
+	}
+
+	channel.ClosingAmount = challenge.Voucher.Amount
+
+	return bc.contractState.putGob(channelKey(channel.ID), channel)
+}
+
+func (bc *Blockchain) handleChannelSettle(b *Block, settle ChannelSettleTx) error {
+	channel, err := bc.getChannel(settle.Channel)
+	if err != nil {
+		return err
+	}
+	if channel.Status != ChannelClosing || b.Height < channel.ClosesAt {
+		return fmt.Errorf("channel (%s) can't be settled before its challenge period is over", channel.ID)
+	}
+
+	return bc.settleChannel(channel)
+}
+
+// settleChannel pays the closing amount to the recipient and the rest of the
+// deposit back to the sender.
+func (bc *Blockchain) settleChannel(channel *Channel) error {
+	if channel.ClosingAmount > 0 {
+		if err := bc.accountState.Transfer(channelEscrow, channel.Recipient.Address(), channel.Asset, channel.ClosingAmount); err != nil {
+			return err
+		}
+	}
+	if rest := channel.Deposit - channel.ClosingAmount; rest > 0 {
+		if err := bc.accountState.Transfer(channelEscrow, channel.Sender.Address(), channel.Asset, rest); err != nil {
+			return err
+		}
+	}
+
+	channel.Status = ChannelClosed
+
+	return bc.contractState.putGob(channelKey(channel.ID), channel)
+}
+
+// GetChannel returns the payment channel with the given ID.
+func (bc *Blockchain) GetChannel(id types.Hash) (*Channel, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.getChannel(id)
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"testing"
+)
+
+func openChannel(t *testing.T, bc *Blockchain, validator, sender, recipient crypto.PrivateKey) types.Hash {
+	setParams(t, bc, func(p *Params) { p.MinChallengePeriod = 2 })
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	open := &Transaction{TxInner: ChannelOpenTx{
+		Recipient:       recipient.PublicKey(),
+		Deposit:         100,
+		ChallengePeriod: 2,
+	}}
+	assert.Nil(t, open.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{open})))
+
+	return open.Hash(TxHasher{})
+}
+
+func assertBalance(t *testing.T, bc *Blockchain, address types.Address, expected uint64) {
+	balance, err := bc.accountState.GetBalance(address, NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, balance)
+}
+
+func TestVoucherSignVerify(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	v := Voucher{Channel: types.RandomHash(), Amount: 10}
+
+	sig, err := v.Sign(privKey)
+	assert.Nil(t, err)
+	assert.True(t, v.Verify(privKey.PublicKey(), sig))
+
+	v.Amount = 11
+	assert.False(t, v.Verify(privKey.PublicKey(), sig))
+}
+
+func TestChannelCooperativeClose(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+	sender := crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey()
+	id := openChannel(t, bc, validator, sender, recipient)
+
+	voucher := Voucher{Channel: id, Amount: 30}
+	senderSig, err := voucher.Sign(sender)
+	assert.Nil(t, err)
+	recipientSig, err := voucher.Sign(recipient)
+	assert.Nil(t, err)
+
+	closeTx := &Transaction{TxInner: ChannelCloseTx{
+		Voucher:      voucher,
+		SenderSig:    senderSig,
+		RecipientSig: recipientSig,
+	}}
+	assert.Nil(t, closeTx.Sign(recipient))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{closeTx})))
+
+	channel, err := bc.GetChannel(id)
+	assert.Nil(t, err)
+	assert.Equal(t, ChannelClosed, channel.Status)
+	assertBalance(t, bc, recipient.PublicKey().Address(), 30)
+	assertBalance(t, bc, sender.PublicKey().Address(), 70)
+}
+
+func TestChannelUnilateralCloseWithChallenge(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+	sender := crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey()
+	id := openChannel(t, bc, validator, sender, recipient)
+
+	// the sender tries to leave without paying
+	closeTx := &Transaction{TxInner: ChannelCloseTx{Voucher: Voucher{Channel: id}}}
+	assert.Nil(t, closeTx.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{closeTx})))
+
+	voucher := Voucher{Channel: id, Amount: 40}
+	senderSig, err := voucher.Sign(sender)
+	assert.Nil(t, err)
+
+	challenge := &Transaction{TxInner: ChannelChallengeTx{Voucher: voucher, SenderSig: senderSig}}
+	assert.Nil(t, challenge.Sign(recipient))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{challenge})))
+
+	// still within the challenge period
+	settle := &Transaction{TxInner: ChannelSettleTx{Channel: id}}
+	assert.Nil(t, settle.Sign(sender))
+	b := nextBlock(t, bc, validator, []*Transaction{settle})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	settle = &Transaction{TxInner: ChannelSettleTx{Channel: id}, Nonce: 1}
+	assert.Nil(t, settle.Sign(sender))
+	b = nextBlock(t, bc, validator, []*Transaction{settle})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 1, appliedTxs(t, bc, b))
+
+	assertBalance(t, bc, recipient.PublicKey().Address(), 40)
+	assertBalance(t, bc, sender.PublicKey().Address(), 60)
+}
+
+func TestChannelOpenBelowMinChallengePeriod(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender, recipient := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	open := &Transaction{TxInner: ChannelOpenTx{
+		Recipient:       recipient.PublicKey(),
+		Deposit:         100,
+		ChallengePeriod: bc.Params().MinChallengePeriod - 1,
+	}}
+	assert.Nil(t, open.Sign(sender))
+	b := nextBlock(t, bc, crypto.GeneratePrivateKey(), []*Transaction{open})
+	assert.Nil(t, bc.AddBlock(b))
+
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+	_, err := bc.GetChannel(open.Hash(TxHasher{}))
+	assert.ErrorIs(t, err, ErrChannelNotFound)
+	assertBalance(t, bc, sender.PublicKey().Address(), 100)
+}
+
+func TestChannelOpenToInvalidRecipient(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender := crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	open := &Transaction{TxInner: ChannelOpenTx{
+		Recipient:       make(crypto.PublicKey, 33),
+		Deposit:         100,
+		ChallengePeriod: bc.Params().MinChallengePeriod,
+	}}
+	assert.Nil(t, open.Sign(sender))
+	b := nextBlock(t, bc, crypto.GeneratePrivateKey(), []*Transaction{open})
+	assert.Nil(t, bc.AddBlock(b))
+
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+	_, err := bc.GetChannel(open.Hash(TxHasher{}))
+	assert.ErrorIs(t, err, ErrChannelNotFound)
+	assertBalance(t, bc, sender.PublicKey().Address(), 100)
+}
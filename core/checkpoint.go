@@ -0,0 +1,113 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"sharkchain/types"
+	"slices"
+)
+
+var ErrCheckpointMismatch = errors.New("block conflicts with a checkpoint")
+
+// Checkpoint pins the hash of the block at a height. Trusted checkpoints are
+// configured by the operator, any chain conflicting with them is refused.
+type Checkpoint struct {
+	Height uint32
+	Hash   types.Hash
+	// StateRoot is the root of the snapshot after the block, zero when not
+	// known. A chain is only restored from a snapshot trusted with its root.
+	StateRoot types.Hash
+}
+
+// SetCheckpoints sets the trusted checkpoints. The blocks the chain already
+// holds at their heights have to match them.
+func (bc *Blockchain) SetCheckpoints(checkpoints []Checkpoint) error {
+	trusted := make(map[uint32]Checkpoint, len(checkpoints))
+	for _, c := range checkpoints {
+		if other, ok := trusted[c.Height]; ok && other != c {
+			return fmt.Errorf("conflicting checkpoints at height (%d)", c.Height)
+		}
+		trusted[c.Height] = c
+	}
+
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	for height, c := range trusted {
+		i, err := bc.index(height)
+		if err != nil {
+			continue
+		}
+		if ours := (BlockHasher{}).Hash(bc.headers[i]); ours != c.Hash {
+			return fmt.Errorf("%w: block (%d) is (%s) but the checkpoint is (%s)", ErrCheckpointMismatch, height, ours, c.Hash)
+		}
+		bc.finalized = max(bc.finalized, height)
+	}
+	bc.trusted = trusted
+	bc.pruneSideBlocks()
+
+	return nil
+}
+
+// Checkpoints returns the trusted checkpoints along with the finalized
+// blocks the chain recorded as checkpoints, by height.
+func (bc *Blockchain) Checkpoints() []Checkpoint {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	checkpoints := slices.Clone(bc.checkpoints)
+	for height, trusted := range bc.trusted {
+		if !slices.ContainsFunc(checkpoints, func(c Checkpoint) bool { return c.Height == height }) {
+			checkpoints = append(checkpoints, trusted)
+		}
+	}
+	slices.SortFunc(checkpoints, func(a, b Checkpoint) int { return cmp.Compare(a.Height, b.Height) })
+
+	return checkpoints
+}
+
+// checkCheckpoint refuses a block another one is trusted at its height.
+func (bc *Blockchain) checkCheckpoint(b *Block) error {
+	bc.lock.RLock()
+	c, ok := bc.trusted[b.Height]
+	bc.lock.RUnlock()
+
+	if ok && c.Hash != b.Hash(BlockHasher{}) {
+		return fmt.Errorf("%w: block (%d) is (%s) but the checkpoint is (%s)", ErrCheckpointMismatch, b.Height, b.Hash(BlockHasher{}), c.Hash)
+	}
+
+	return nil
+}
+
+// verifyBlock checks the signatures of the block. Up to the latest trusted
+// checkpoint only the one of the producer is: a branch leading to other
+// blocks is refused at the checkpoint, so the checkpoint vouches for the
+// transactions below it as long as they match the data hash of their block.
+func (bc *Blockchain) verifyBlock(b *Block) error {
+	bc.lock.RLock()
+	trusted := false
+	for height := range bc.trusted {
+		if b.Height <= height {
+			trusted = true
+			break
+		}
+	}
+	bc.lock.RUnlock()
+
+	if trusted {
+		return b.VerifySignature()
+	}
+
+	return b.Verify()
+}
+
+// finalizes reports whether the block becomes final once it is part of the
+// chain: it carries a commit certificate or it is trusted. The lock must be
+// held.
+func (bc *Blockchain) finalizes(b *Block) bool {
+	_, trusted := bc.trusted[b.Height]
+	return b.Commit != nil || trusted
+}
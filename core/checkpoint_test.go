@@ -0,0 +1,94 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestTrustedCheckpoint(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	b1 := nextBlock(t, bc, privKey, nil)
+	assert.Nil(t, bc.AddBlock(b1))
+	b2 := childBlock(t, b1, privKey, nil)
+
+	assert.ErrorIs(t, bc.SetCheckpoints([]Checkpoint{{Height: 1, Hash: b2.Hash(BlockHasher{})}}), ErrCheckpointMismatch)
+	assert.NotNil(t, bc.SetCheckpoints([]Checkpoint{
+		{Height: 2, Hash: b2.Hash(BlockHasher{})},
+		{Height: 2, Hash: b1.Hash(BlockHasher{})},
+	}))
+	assert.Nil(t, bc.SetCheckpoints([]Checkpoint{{Height: 2, Hash: b2.Hash(BlockHasher{})}}))
+
+	assert.ErrorIs(t, bc.AddBlock(childBlock(t, b1, crypto.GeneratePrivateKey(), nil)), ErrCheckpointMismatch)
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint32(2), bc.FinalizedHeight())
+
+	// nothing reverts the checkpoint
+	assert.NotNil(t, bc.AddBlock(childBlock(t, b1, privKey, nil)))
+	assert.Equal(t, []Checkpoint{{Height: 2, Hash: b2.Hash(BlockHasher{})}}, bc.Checkpoints())
+}
+
+func TestCheckpointSkipsTxSignatures(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	tx := NewTransaction([]byte("foo"))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	tx.Data = []byte("bar")
+	b1 := nextBlock(t, bc, privKey, []*Transaction{tx})
+	assert.NotNil(t, bc.AddBlock(b1))
+
+	b2 := childBlock(t, b1, privKey, nil)
+	assert.Nil(t, bc.SetCheckpoints([]Checkpoint{{Height: 2, Hash: b2.Hash(BlockHasher{})}}))
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Nil(t, bc.AddBlock(b2))
+
+	// above the checkpoint every signature counts
+	b3 := childBlock(t, b2, privKey, []*Transaction{tx})
+	assert.NotNil(t, bc.AddBlock(b3))
+
+	// but the one of the producer always does
+	b3 = childBlock(t, b2, privKey, nil)
+	b3.Validator = crypto.GeneratePrivateKey().PublicKey()
+	assert.NotNil(t, bc.AddBlock(b3))
+}
+
+func TestRecordFinalizedCheckpoints(t *testing.T) {
+	bc, validators := newBFTBlockchain(t, 4)
+	setParams(t, bc, func(p *Params) { p.CheckpointInterval = 2 })
+
+	for i := 0; i < 4; i++ {
+		b := nextBlock(t, bc, proposer(t, bc, validators), nil)
+		commitBlock(t, b, 0, validators[1:])
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	checkpoints := bc.Checkpoints()
+	assert.Len(t, checkpoints, 2)
+	for i, c := range checkpoints {
+		b, err := bc.GetBlock(uint32(2 * (i + 1)))
+		assert.Nil(t, err)
+		assert.Equal(t, Checkpoint{Height: b.Height, Hash: b.Hash(BlockHasher{})}, c)
+	}
+}
+
+func TestCheckpointRefusesTamperedTransactions(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator, victim := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	thief := crypto.GeneratePrivateKey().PublicKey()
+	bc.accountState.Mint(victim.PublicKey().Address(), NativeAsset, 100)
+
+	b1 := nextBlock(t, bc, validator, nil)
+	assert.Nil(t, bc.SetCheckpoints([]Checkpoint{{Height: 1, Hash: b1.Hash(BlockHasher{})}}))
+
+	// the header and its signature stay valid, the body is swapped
+	theft := &Transaction{To: thief, Value: 90, From: victim.PublicKey()}
+	tampered := &Block{Header: b1.Header, Transactions: []*Transaction{theft}, Validator: b1.Validator, Signature: b1.Signature}
+	assert.Nil(t, tampered.VerifySignature())
+	assert.NotNil(t, bc.AddBlock(tampered))
+
+	_, err := bc.GetBalances(thief.Address())
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+	assert.Nil(t, bc.AddBlock(b1))
+}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sharkchain/crypto"
+)
+
+var (
+	// ErrNotProposer is returned by Engine.Prepare when the key may not
+	// produce the block.
+	ErrNotProposer = errors.New("not allowed to produce this block")
+	// ErrSealAborted is returned by Engine.Seal when it was stopped.
+	ErrSealAborted = errors.New("sealing aborted")
+)
+
+// ConsensusType selects the engine deciding who produces blocks.
+type ConsensusType byte
+
+const (
+	// ConsensusSigner accepts blocks signed by any validator, or by any key
+	// when there are none.
+	ConsensusSigner ConsensusType = iota
+	// ConsensusPoA lets the validators sign the blocks in turns.
+	ConsensusPoA
+	// ConsensusPoW accepts blocks mined by anyone.
+	ConsensusPoW
+	// ConsensusBFT accepts blocks committed by more than 2/3 of the validators.
+	ConsensusBFT
+	// ConsensusVRF accepts blocks of validators elected by their VRF.
+	ConsensusVRF
+)
+
+func (c ConsensusType) String() string {
+	switch c {
+	case ConsensusSigner:
+		return "signer"
+	case ConsensusPoA:
+		return "poa"
+	case ConsensusPoW:
+		return "pow"
+	case ConsensusBFT:
+		return "bft"
+	case ConsensusVRF:
+		return "vrf"
+	default:
+		return "unknown"
+	}
+}
+
+// Engine is a consensus algorithm. Blocks are produced by Prepare and Seal
+// and accepted after VerifySeal.
+type Engine interface {
+	// Prepare fills in the consensus fields of the header of a block the
+	// given signer is about to produce. It returns ErrNotProposer when the
+	// signer may not produce the block.
+	Prepare(bc *Blockchain, h *Header, signer crypto.Signer) error
+	// Seal makes a prepared block acceptable, e.g. by mining and signing it.
+	// It returns ErrSealAborted as soon as stop returns true.
+	Seal(b *Block, signer crypto.Signer, stop func() bool) error
+	// VerifySeal checks the consensus fields of a block extending the chain.
+	// The signatures have been verified already.
+	VerifySeal(bc *Blockchain, b *Block) error
+	// Work returns the weight the header adds to its chain, the fork choice
+	// follows the chain with the most work.
+	Work(h *Header) *big.Int
+	// Finalize runs once the transactions of the block are applied, with
+	// the state locked.
+	Finalize(bc *Blockchain, b *Block) error
+}
+
+// EngineFor returns the engine selected by the parameters.
+func EngineFor(params Params) Engine {
+	switch params.Consensus {
+	case ConsensusPoA:
+		return &PoAEngine{SignerEngine{params: params}}
+	case ConsensusPoW:
+		return &PoWEngine{params: params}
+	case ConsensusBFT:
+		return &BFTEngine{SignerEngine{params: params}}
+	case ConsensusVRF:
+		return &VRFEngine{SignerEngine{params: params}}
+	default:
+		return &SignerEngine{params: params}
+	}
+}
+
+// SignerEngine accepts blocks signed by any validator.
+type SignerEngine struct {
+	params Params
+}
+
+func (e *SignerEngine) Prepare(bc *Blockchain, h *Header, signer crypto.Signer) error {
+	if address := signer.PublicKey().Address(); !e.params.IsValidator(address) {
+		return fmt.Errorf("%w: (%s) is not a validator", ErrNotProposer, address)
+	}
+
+	return nil
+}
+
+func (e *SignerEngine) Seal(b *Block, signer crypto.Signer, stop func() bool) error {
+	return b.Sign(signer)
+}
+
+func (e *SignerEngine) VerifySeal(bc *Blockchain, b *Block) error {
+	if b.Difficulty != 0 || b.Nonce != 0 {
+		return fmt.Errorf("block (%d) is mined but the chain does not use proof-of-work", b.Height)
+	}
+	if !e.params.IsValidator(b.Validator.Address()) {
+		return fmt.Errorf("block (%d) signed by (%s) which is not a validator", b.Height, b.Validator.Address())
+	}
+
+	return nil
+}
+
+func (e *SignerEngine) Work(h *Header) *big.Int {
+	return big.NewInt(1)
+}
+
+func (e *SignerEngine) Finalize(bc *Blockchain, b *Block) error {
+	return bc.payReward(b)
+}
+
+// PoAEngine lets the validators sign blocks in turns, see
+// Params.ProposerAfter.
+type PoAEngine struct {
+	SignerEngine
+}
+
+func (e *PoAEngine) Prepare(bc *Blockchain, h *Header, signer crypto.Signer) error {
+	parent, err := bc.GetHeader(h.Height - 1)
+	if err != nil {
+		return err
+	}
+	if proposer := e.params.ProposerAfter(parent, h); proposer.Address() != signer.PublicKey().Address() {
+		return fmt.Errorf("%w: block (%d) is for (%s)", ErrNotProposer, h.Height, proposer.Address())
+	}
+
+	return nil
+}
+
+func (e *PoAEngine) VerifySeal(bc *Blockchain, b *Block) error {
+	if b.Difficulty != 0 || b.Nonce != 0 {
+		return fmt.Errorf("block (%d) is mined but the chain does not use proof-of-work", b.Height)
+	}
+	parent, err := bc.GetHeader(b.Height - 1)
+	if err != nil {
+		return err
+	}
+	if proposer := e.params.ProposerAfter(parent, b.Header); proposer.Address() != b.Validator.Address() {
+		return fmt.Errorf("block (%d) signed by (%s) out of turn, the proposer is (%s)", b.Height, b.Validator.Address(), proposer.Address())
+	}
+
+	return nil
+}
+
+// PoWEngine accepts blocks mined by anyone, the miner signs the block to
+// receive its reward and fees.
+type PoWEngine struct {
+	params Params
+}
+
+func (e *PoWEngine) Prepare(bc *Blockchain, h *Header, signer crypto.Signer) error {
+	h.Difficulty = bc.NextDifficulty()
+	h.Nonce = 0
+	h.Timestamp = max(h.Timestamp, bc.MedianTimePast()+1)
+
+	return nil
+}
+
+func (e *PoWEngine) Seal(b *Block, signer crypto.Signer, stop func() bool) error {
+	if !Mine(b.Header, stop) {
+		return ErrSealAborted
+	}
+
+	return b.Sign(signer)
+}
+
+func (e *PoWEngine) VerifySeal(bc *Blockchain, b *Block) error {
+	if difficulty := bc.NextDifficulty(); b.Difficulty != difficulty {
+		return fmt.Errorf("block (%d) has difficulty (%d) but (%d) is required", b.Height, b.Difficulty, difficulty)
+	}
+	if !MeetsDifficulty(b.Header) {
+		return fmt.Errorf("block (%d) hash does not meet its difficulty", b.Height)
+	}
+	if median := bc.MedianTimePast(); b.Timestamp <= median {
+		return fmt.Errorf("block (%d) timestamp (%d) is not after the median of the last blocks (%d)", b.Height, b.Timestamp, median)
+	}
+
+	return nil
+}
+
+func (e *PoWEngine) Work(h *Header) *big.Int {
+	return new(big.Int).SetUint64(h.Difficulty)
+}
+
+func (e *PoWEngine) Finalize(bc *Blockchain, b *Block) error {
+	return bc.payReward(b)
+}
+
+// payReward mints the block reward to the producer of the block, shared
+// with its delegators when it is a staking candidate.
+func (bc *Blockchain) payReward(b *Block) error {
+	params := bc.params()
+	reward := params.Reward.RewardAt(b.Height)
+	if reward == 0 {
+		return nil
+	}
+
+	if params.Staking != nil {
+		info, err := bc.getValidatorInfo(b.Validator.Address())
+		if err == nil {
+			bc.distributeReward(info, params.Staking.Commission, reward)
+			return nil
+		}
+		if err != ErrValidatorNotFound {
+			return err
+		}
+	}
+
+	bc.accountState.Mint(b.Validator.Address(), NativeAsset, reward)
+	return nil
+}
+
+func (bc *Blockchain) engine() Engine {
+	return EngineFor(bc.params())
+}
+
+// Engine returns the consensus engine for the next block.
+func (bc *Blockchain) Engine() Engine {
+	return EngineFor(bc.Params())
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestParamsValidateConsensus(t *testing.T) {
+	params := DefaultParams
+	assert.Nil(t, params.Validate())
+
+	params.Consensus = ConsensusPoA
+	assert.NotNil(t, params.Validate())
+	params.Validators = []crypto.PublicKey{crypto.GeneratePrivateKey().PublicKey()}
+	assert.Nil(t, params.Validate())
+
+	params.Consensus = ConsensusPoW
+	assert.NotNil(t, params.Validate())
+	params.PoW = &PoWParams{InitialDifficulty: 1, AdjustmentInterval: 10}
+	assert.Nil(t, params.Validate())
+
+	params.Consensus = 42
+	assert.NotNil(t, params.Validate())
+}
+
+func TestSignerEngineAnyValidator(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+	setParams(t, bc, func(p *Params) { p.Consensus = ConsensusSigner })
+
+	// no turns, the same validator may sign every block
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validators[0], nil)))
+	}
+
+	engine := bc.Engine()
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	b, err := NewBlockFromPrevHeader(prevHeader, nil)
+	assert.Nil(t, err)
+
+	err = engine.Prepare(bc, b.Header, crypto.GeneratePrivateKey())
+	assert.True(t, errors.Is(err, ErrNotProposer))
+	assert.Nil(t, engine.Prepare(bc, b.Header, validators[2]))
+	assert.Nil(t, engine.Seal(b, validators[2], func() bool { return false }))
+	assert.Nil(t, bc.AddBlock(b))
+}
+
+func TestPoAEnginePrepare(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 2)
+	engine := bc.Engine()
+
+	h := &Header{Height: 1}
+	assert.True(t, errors.Is(engine.Prepare(bc, h, validators[0]), ErrNotProposer))
+	assert.Nil(t, engine.Prepare(bc, h, validators[1]))
+}
+
+func TestPoWEngineSeal(t *testing.T) {
+	bc := newMinedBlockchain(t)
+	engine := bc.Engine()
+	miner := crypto.GeneratePrivateKey()
+
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	b, err := NewBlockFromPrevHeader(prevHeader, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, engine.Prepare(bc, b.Header, miner))
+	assert.Equal(t, uint64(16), b.Difficulty)
+	assert.Equal(t, ErrSealAborted, engine.Seal(b, miner, func() bool { return true }))
+	assert.Nil(t, engine.Seal(b, miner, func() bool { return false }))
+	assert.Nil(t, bc.AddBlock(b))
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sharkchain/crypto"
+	"sharkchain/types"
+)
+
+var ErrEvidenceKnown = errors.New("double sign already punished")
+
+// SignedHeader is a block header together with the signature of its producer.
+type SignedHeader struct {
+	Header    *Header
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+func (h SignedHeader) Verify() error {
+	if h.Header == nil || h.Signature == nil {
+		return errors.New("signed header is incomplete")
+	}
+	// the key comes with the evidence, whoever gossiped it chose it
+	if err := h.Validator.Validate(); err != nil {
+		return err
+	}
+	hash := BlockHasher{}.Hash(h.Header)
+	if !h.Signature.Verify(h.Validator, hash.ToSlice()) {
+		return fmt.Errorf("invalid signature of header (%d)", h.Header.Height)
+	}
+
+	return nil
+}
+
+// DoubleSignEvidence proves that a validator signed two different blocks at
+// the same height. A is the header with the lower hash, so the evidence of a
+// pair of blocks is the same whoever detects it.
+type DoubleSignEvidence struct {
+	A SignedHeader
+	B SignedHeader
+}
+
+// NewDoubleSignEvidence returns the evidence of two conflicting blocks.
+func NewDoubleSignEvidence(a, b *Block) (*DoubleSignEvidence, error) {
+	e := &DoubleSignEvidence{
+		A: SignedHeader{Header: a.Header, Validator: a.Validator, Signature: a.Signature},
+		B: SignedHeader{Header: b.Header, Validator: b.Validator, Signature: b.Signature},
+	}
+	hashA, hashB := BlockHasher{}.Hash(a.Header), BlockHasher{}.Hash(b.Header)
+	if bytes.Compare(hashA.ToSlice(), hashB.ToSlice()) > 0 {
+		e.A, e.B = e.B, e.A
+	}
+
+	return e, e.Verify()
+}
+
+// Offender returns the key of the validator that signed both headers.
+func (e *DoubleSignEvidence) Offender() crypto.PublicKey {
+	return e.A.Validator
+}
+
+func (e *DoubleSignEvidence) Height() uint32 {
+	return e.A.Header.Height
+}
+
+func (e *DoubleSignEvidence) Hash() types.Hash {
+	buf := &bytes.Buffer{}
+	buf.WriteString("evidence")
+	hashA, hashB := BlockHasher{}.Hash(e.A.Header), BlockHasher{}.Hash(e.B.Header)
+	buf.Write(hashA.ToSlice())
+	buf.Write(hashB.ToSlice())
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+// Verify checks that the evidence is self-consistent, it does not check
+// whether the offender is a validator.
+func (e *DoubleSignEvidence) Verify() error {
+	if err := e.A.Verify(); err != nil {
+		return err
+	}
+	if err := e.B.Verify(); err != nil {
+		return err
+	}
+
+	if e.A.Header.Height != e.B.Header.Height {
+		return fmt.Errorf("evidence headers are at heights (%d) and (%d)", e.A.Header.Height, e.B.Header.Height)
+	}
+	if e.A.Validator.Address() != e.B.Validator.Address() {
+		return fmt.Errorf("evidence headers of height (%d) are signed by different validators", e.Height())
+	}
+
+	hashA, hashB := BlockHasher{}.Hash(e.A.Header), BlockHasher{}.Hash(e.B.Header)
+	if cmp := bytes.Compare(hashA.ToSlice(), hashB.ToSlice()); cmp == 0 {
+		return fmt.Errorf("evidence headers of height (%d) are the same", e.Height())
+	} else if cmp > 0 {
+		return fmt.Errorf("evidence headers of height (%d) are out of order", e.Height())
+	}
+
+	return nil
+}
+
+// CalculateEvidenceHash commits a block header to the evidence it carries.
+func CalculateEvidenceHash(evidence []*DoubleSignEvidence) types.Hash {
+	if len(evidence) == 0 {
+		return types.Hash{}
+	}
+
+	buf := &bytes.Buffer{}
+	for _, e := range evidence {
+		hash := e.Hash()
+		buf.Write(hash.ToSlice())
+	}
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+func punishedKey(offender types.Address, height uint32) []byte {
+	key := append([]byte("slashing/punished/"), offender.ToSlice()...)
+	return binary.LittleEndian.AppendUint32(key, height)
+}
+
+// DetectDoubleSign compares a block with the one the chain holds at its
+// height, returning the evidence when both were signed by the same validator.
+func (bc *Blockchain) DetectDoubleSign(b *Block) (*DoubleSignEvidence, bool) {
+	if b.Header == nil || b.Height > bc.Height() {
+		return nil, false
+	}
+
+	ours, err := bc.GetBlock(b.Height)
+	if err != nil || ours.Validator.Address() != b.Validator.Address() {
+		return nil, false
+	}
+	if ours.Hash(BlockHasher{}) == (BlockHasher{}).Hash(b.Header) {
+		return nil, false
+	}
+
+	e, err := NewDoubleSignEvidence(ours, b)
+	if err != nil {
+		return nil, false
+	}
+
+	return e, true
+}
+
+// CheckEvidence reports whether the evidence can be included in the next
+// block: it has to be valid, against a validator and not punished yet.
+func (bc *Blockchain) CheckEvidence(e *DoubleSignEvidence) error {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.checkEvidence(e)
+}
+
+func (bc *Blockchain) checkEvidence(e *DoubleSignEvidence) error {
+	if err := e.Verify(); err != nil {
+		return err
+	}
+	if e.Height() > bc.Height() {
+		return fmt.Errorf("evidence of height (%d) is ahead of the chain", e.Height())
+	}
+
+	offender := e.Offender().Address()
+	found, err := bc.contractState.getGob(punishedKey(offender, e.Height()), new(bool))
+	if err != nil {
+		return err
+	}
+	if found {
+		return ErrEvidenceKnown
+	}
+
+	params := bc.params()
+	if params.validatorIndex(offender) < 0 {
+		if _, err := bc.getValidatorInfo(offender); err != nil {
+			return fmt.Errorf("evidence against (%s) which is not a validator", offender)
+		}
+	}
+
+	return nil
+}
+
+// applyEvidence punishes the offenders of the evidence in block b.
+func (bc *Blockchain) applyEvidence(b *Block) error {
+	for _, e := range b.Evidence {
+		if err := bc.checkEvidence(e); err != nil {
+			return err
+		}
+		if err := bc.slash(e.Offender(), e.Height(), b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// slashUnbonding cuts the share of the stake undelegated from the validator
+// at the offence height or later and returns the amount to burn.
+func (bc *Blockchain) slashUnbonding(info *ValidatorInfo, height uint32, percent uint8) (uint64, error) {
+	slashed := uint64(0)
+	for _, delegator := range info.Unbonding {
+		entries, err := bc.getUnbonding(delegator)
+		if err != nil {
+			return 0, err
+		}
+		for i, e := range entries {
+			if e.Validator != info.Address || e.Height < height {
+				continue
+			}
+			hi, lo := bits.Mul64(e.Amount, uint64(percent))
+			amount, _ := bits.Div64(hi, lo, 100)
+			entries[i].Amount -= amount
+			slashed += amount
+		}
+		if err := bc.contractState.putGob(unbondingKey(delegator), entries); err != nil {
+			return 0, err
+		}
+	}
+
+	return slashed, nil
+}
+
+// slash burns a share of the stake bonded to the offender and jails it:
+// it leaves the validator set at once and, under staking, can't rejoin it
+// before the jail period is over. The last validator is never removed.
+func (bc *Blockchain) slash(offender crypto.PublicKey, height uint32, b *Block) error {
+	address := offender.Address()
+	params := bc.params()
+
+	if params.Staking != nil {
+		info, err := bc.getValidatorInfo(address)
+		if err != nil && err != ErrValidatorNotFound {
+			return err
+		}
+		if info != nil {
+			slashed := uint64(0)
+			for i, d := range info.Delegations {
+				hi, lo := bits.Mul64(d.Amount, uint64(params.Staking.SlashPercent))
+				amount, _ := bits.Div64(hi, lo, 100)
+				info.Delegations[i].Amount -= amount
+				slashed += amount
+			}
+			info.Stake -= slashed
+			info.JailedUntil = b.Height + params.Staking.JailPeriod
+
+			// stake undelegated since the offence backed it all the same
+			unbonding, err := bc.slashUnbonding(info, height, params.Staking.SlashPercent)
+			if err != nil {
+				return err
+			}
+			slashed += unbonding
+
+			if err := bc.accountState.Burn(stakingEscrow, NativeAsset, slashed); err != nil {
+				return err
+			}
+			if err := bc.contractState.putGob(validatorInfoKey(address), info); err != nil {
+				return err
+			}
+		}
+	}
+
+	if i := params.validatorIndex(address); i >= 0 && len(params.Validators) > 1 {
+		params.Validators = append(params.Validators[:i:i], params.Validators[i+1:]...)
+		if len(params.Powers) > 0 {
+			params.Powers = append(params.Powers[:i:i], params.Powers[i+1:]...)
+		}
+		if err := bc.contractState.putGob(paramsKey, params); err != nil {
+			return err
+		}
+	}
+
+	bc.logger.Log("msg", "validator slashed", "validator", address, "height", height)
+
+	return bc.contractState.putGob(punishedKey(address, height), true)
+}
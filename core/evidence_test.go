@@ -0,0 +1,196 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+// conflictingBlock signs another block at the height of the last one.
+func conflictingBlock(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey) *Block {
+	prevHeader, err := bc.GetHeader(bc.Height() - 1)
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(prevHeader, nil)
+	assert.Nil(t, err)
+	b.Timestamp++
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+func nextBlockWithEvidence(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey, evidence ...*DoubleSignEvidence) *Block {
+	b := nextBlock(t, bc, privKey, nil)
+	for _, e := range evidence {
+		b.AddEvidence(e)
+	}
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+func TestDoubleSignEvidence(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+	offender := proposer(t, bc, validators)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, offender, nil)))
+
+	b := conflictingBlock(t, bc, offender)
+	assert.Nil(t, bc.AddBlock(b))
+	assert.ErrorIs(t, bc.AddBlock(b), ErrBlockKnown)
+
+	e, ok := bc.DetectDoubleSign(b)
+	assert.True(t, ok)
+	assert.Nil(t, e.Verify())
+	assert.Equal(t, offender.PublicKey(), e.Offender())
+	assert.Equal(t, uint32(1), e.Height())
+
+	// the same evidence whoever detects it
+	ours, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	other, err := NewDoubleSignEvidence(b, ours)
+	assert.Nil(t, err)
+	assert.Equal(t, e.Hash(), other.Hash())
+
+	// not from another signer or for the same block
+	_, ok = bc.DetectDoubleSign(conflictingBlock(t, bc, validators[0]))
+	assert.False(t, ok)
+	_, err = NewDoubleSignEvidence(ours, ours)
+	assert.NotNil(t, err)
+
+	e.B.Header.Nonce++
+	assert.NotNil(t, e.Verify())
+}
+
+func TestEvidenceWithInvalidKey(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+	offender := proposer(t, bc, validators)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, offender, nil)))
+	b := conflictingBlock(t, bc, offender)
+	e, ok := bc.DetectDoubleSign(b)
+	assert.True(t, ok)
+
+	for _, key := range []crypto.PublicKey{nil, make(crypto.PublicKey, 33)} {
+		e.A.Validator, e.B.Validator = key, key
+		assert.NotNil(t, e.Verify())
+		assert.NotNil(t, bc.CheckEvidence(e))
+
+		b.Validator = key
+		assert.NotNil(t, b.VerifySignature())
+	}
+}
+
+func TestSlashDoubleSign(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+	offender := proposer(t, bc, validators)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, offender, nil)))
+
+	e, ok := bc.DetectDoubleSign(conflictingBlock(t, bc, offender))
+	assert.True(t, ok)
+	assert.Nil(t, bc.CheckEvidence(e))
+
+	// the evidence is part of the signed header
+	b := nextBlock(t, bc, proposer(t, bc, validators), nil)
+	b.Evidence = append(b.Evidence, e)
+	assert.NotNil(t, bc.AddBlock(b))
+
+	assert.NotNil(t, bc.AddBlock(nextBlockWithEvidence(t, bc, proposer(t, bc, validators), e, e)))
+	assert.Nil(t, bc.AddBlock(nextBlockWithEvidence(t, bc, proposer(t, bc, validators), e)))
+
+	params := bc.Params()
+	assert.Len(t, params.Validators, 2)
+	assert.False(t, params.IsValidator(offender.PublicKey().Address()))
+	assert.ErrorIs(t, bc.CheckEvidence(e), ErrEvidenceKnown)
+}
+
+func TestSlashStake(t *testing.T) {
+	bc := newStakingBlockchain(t)
+	setParams(t, bc, func(p *Params) {
+		p.Staking.SlashPercent = 10
+		p.Staking.JailPeriod = 6
+	})
+	a, b, d := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	for _, k := range []crypto.PrivateKey{a, b, d} {
+		bc.accountState.Mint(k.PublicKey().Address(), NativeAsset, 100)
+	}
+	producer := crypto.GeneratePrivateKey()
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, producer, []*Transaction{
+		stakingTx(t, a, StakeTx{Amount: 50}),
+		stakingTx(t, b, StakeTx{Amount: 30}),
+		stakingTx(t, d, DelegateTx{Validator: a.PublicKey().Address(), Amount: 50}),
+	})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, producer, nil)))
+	assert.Len(t, bc.Params().Validators, 2)
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, a, nil)))
+	e, ok := bc.DetectDoubleSign(conflictingBlock(t, bc, a))
+	assert.True(t, ok)
+
+	supply := bc.TotalSupply(NativeAsset)
+	assert.Nil(t, bc.AddBlock(nextBlockWithEvidence(t, bc, b, e)))
+	assert.Equal(t, []crypto.PublicKey{b.PublicKey()}, bc.Params().Validators)
+
+	info, err := bc.GetValidatorInfo(a.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(90), info.Stake)
+	assert.Equal(t, []Delegation{
+		{Delegator: a.PublicKey().Address(), Amount: 45},
+		{Delegator: d.PublicKey().Address(), Amount: 45},
+	}, info.Delegations)
+	assert.Equal(t, uint32(10), info.JailedUntil)
+	// the reward of block 4 minus the burned stake
+	assert.Equal(t, supply+100-10, bc.TotalSupply(NativeAsset))
+
+	// still jailed at the next epoch
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, b, nil)))
+	assert.Equal(t, []crypto.PublicKey{b.PublicKey()}, bc.Params().Validators)
+}
+
+func TestSlashUnbondingStake(t *testing.T) {
+	bc := newStakingBlockchain(t)
+	setParams(t, bc, func(p *Params) {
+		p.Staking.SlashPercent = 10
+		p.Staking.UnbondingPeriod = 10
+	})
+	a, b, d, early := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	for _, k := range []crypto.PrivateKey{a, b, d, early} {
+		bc.accountState.Mint(k.PublicKey().Address(), NativeAsset, 100)
+	}
+	producer := crypto.GeneratePrivateKey()
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, producer, []*Transaction{
+		stakingTx(t, a, StakeTx{Amount: 50}),
+		stakingTx(t, b, StakeTx{Amount: 30}),
+		stakingTx(t, d, DelegateTx{Validator: a.PublicKey().Address(), Amount: 50}),
+		stakingTx(t, early, DelegateTx{Validator: a.PublicKey().Address(), Amount: 50}),
+	})))
+	// undelegated before the offence, out of reach
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, producer, []*Transaction{
+		stakingTx(t, early, UndelegateTx{Validator: a.PublicKey().Address(), Amount: 50}),
+	})))
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, a, nil)))
+	e, ok := bc.DetectDoubleSign(conflictingBlock(t, bc, a))
+	assert.True(t, ok)
+
+	// undelegating before the evidence lands doesn't escape the slash
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, b, []*Transaction{
+		stakingTx(t, d, UndelegateTx{Validator: a.PublicKey().Address(), Amount: 50}),
+	})))
+
+	supply := bc.TotalSupply(NativeAsset)
+	assert.Nil(t, bc.AddBlock(nextBlockWithEvidence(t, bc, b, e)))
+
+	entries, err := bc.GetUnbonding(d.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, uint64(45), entries[0].Amount)
+
+	entries, err = bc.GetUnbonding(early.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, uint64(50), entries[0].Amount)
+
+	// the own stake of a and the unbonding delegation of d
+	assert.Equal(t, supply+100-5-5, bc.TotalSupply(NativeAsset))
+}
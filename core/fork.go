@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+)
+
+// Rules are the consensus rules that differ between protocol versions.
+type Rules struct {
+	Version uint32
+	// MonotonicTimestamps rejects blocks that are not younger than their parent.
+	MonotonicTimestamps bool
+}
+
+// protocolRules holds the rules of every protocol version this node knows.
+var protocolRules = map[uint32]Rules{
+	1: {Version: 1},
+	2: {Version: 2, MonotonicTimestamps: true},
+}
+
+// RulesFor returns the rules of the given protocol version.
+func RulesFor(version uint32) (Rules, error) {
+	rules, ok := protocolRules[version]
+	if !ok {
+		return Rules{}, fmt.Errorf("unknown protocol version %d", version)
+	}
+
+	return rules, nil
+}
+
+// Fork activates protocol Version starting with the block at Height.
+type Fork struct {
+	Height  uint32
+	Version uint32
+}
+
+// ForkSchedule lists the forks in order of activation. Upgrades are shipped
+// by releasing a schedule with a fork at a future height.
+type ForkSchedule []Fork
+
+var DefaultForkSchedule = ForkSchedule{
+	{Height: 0, Version: 1},
+}
+
+func (s ForkSchedule) Validate() error {
+	if len(s) == 0 || s[0].Height != 0 {
+		return fmt.Errorf("fork schedule must start at height 0")
+	}
+
+	for i, fork := range s {
+		if _, err := RulesFor(fork.Version); err != nil {
+			return err
+		}
+		if i > 0 && (fork.Height <= s[i-1].Height || fork.Version <= s[i-1].Version) {
+			return fmt.Errorf("fork %d must come after fork %d with a higher version", i, i-1)
+		}
+	}
+
+	return nil
+}
+
+// VersionAt returns the protocol version blocks at the given height must carry.
+func (s ForkSchedule) VersionAt(height uint32) uint32 {
+	version := s[0].Version
+	for _, fork := range s {
+		if fork.Height > height {
+			break
+		}
+		version = fork.Version
+	}
+
+	return version
+}
@@ -0,0 +1,326 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"sharkchain/types"
+	"slices"
+)
+
+const (
+	// maxForkDepth is how far below the head, and how far above its fork, a
+	// side block is kept. It bounds the branches on chains that never
+	// finalize their blocks.
+	maxForkDepth = 64
+	// maxSideBlocksPerHeight is how many side blocks are kept at a height.
+	maxSideBlocksPerHeight = 4
+)
+
+// SetReorgHandler sets the function called with the blocks a reorg removed
+// from the chain and the ones it added, both in ascending height. It runs
+// while blocks can't be added, so it must not add any itself.
+func (bc *Blockchain) SetReorgHandler(fn func(removed, added []*Block)) {
+	bc.reorgHandler = fn
+}
+
+// knowsBlock reports whether the block is part of the chain or of a
+// competing branch.
+func (bc *Blockchain) knowsBlock(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	_, canonical := bc.blockStore[hash]
+	_, side := bc.sideBlocks[hash]
+
+	return canonical || side
+}
+
+// isSideBlock reports whether the block builds on a known block other than
+// the head of the chain.
+func (bc *Blockchain) isSideBlock(b *Block) bool {
+	if b.Header == nil {
+		return false
+	}
+
+	bc.lock.RLock()
+	head := bc.blocks[len(bc.blocks)-1]
+	bc.lock.RUnlock()
+
+	return b.PrevBlockHash != head.Hash(BlockHasher{}) && bc.knowsBlock(b.PrevBlockHash)
+}
+
+// addSideBlock keeps a block of a competing branch and reorgs to the branch
+// once it has more work than the chain. Only the checks that don't depend
+// on the state run here, the others run when the branch gets applied.
+func (bc *Blockchain) addSideBlock(b *Block) error {
+	hash := b.Hash(BlockHasher{})
+
+	bc.lock.RLock()
+	_, canonical := bc.blockStore[hash]
+	_, known := bc.sideBlocks[hash]
+	parent, ok := bc.blockStore[b.PrevBlockHash]
+	if !ok {
+		parent = bc.sideBlocks[b.PrevBlockHash]
+	}
+	fork := bc.forkHeight(parent)
+	parentWork := bc.work[b.PrevBlockHash]
+	lowest := bc.lowestFork()
+	siblings := 0
+	for _, side := range bc.sideBlocks {
+		if side.Height == b.Height {
+			siblings++
+		}
+	}
+	bc.lock.RUnlock()
+
+	if canonical || known {
+		return ErrBlockKnown
+	}
+	if b.Height != parent.Height+1 {
+		return fmt.Errorf("block (%d) does not follow its parent of height (%d)", b.Height, parent.Height)
+	}
+	if fork < lowest {
+		return fmt.Errorf("block (%d) is on a branch forking at (%d) below (%d)", b.Height, fork, lowest)
+	}
+	if b.Height-fork > maxForkDepth {
+		return fmt.Errorf("block (%d) is more than (%d) blocks above its fork at (%d)", b.Height, maxForkDepth, fork)
+	}
+	if siblings >= maxSideBlocksPerHeight {
+		return fmt.Errorf("already (%d) side blocks at height (%d)", siblings, b.Height)
+	}
+	if version := bc.VersionAt(b.Height); b.Version != version {
+		return fmt.Errorf("block (%d) has version (%d) but the fork schedule requires (%d)", b.Height, b.Version, version)
+	}
+	if err := checkFutureDrift(b.Header); err != nil {
+		return err
+	}
+	if err := bc.verifyBlock(b); err != nil {
+		return err
+	}
+	params, err := bc.paramsAt(fork)
+	if err != nil {
+		return err
+	}
+	if err := verifySideSeal(params, parent, b); err != nil {
+		return err
+	}
+
+	work := new(big.Int).Add(parentWork, bc.Engine().Work(b.Header))
+
+	bc.lock.Lock()
+	bc.sideBlocks[hash] = b
+	bc.work[hash] = work
+	heavier := work.Cmp(bc.totalWork) > 0
+	bc.lock.Unlock()
+
+	bc.logger.Log("msg", "new side block", "hash", hash, "height", b.Height, "fork", fork)
+
+	if !heavier {
+		return nil
+	}
+
+	return bc.reorg(b)
+}
+
+// verifySideSeal runs the consensus checks of a side block which need no
+// state but the parameters in effect at its fork: the producer may sign the
+// block and a mined block meets its difficulty.
+func verifySideSeal(params Params, parent, b *Block) error {
+	if params.Consensus == ConsensusPoW {
+		if !MeetsDifficulty(b.Header) {
+			return fmt.Errorf("block (%d) hash does not meet its difficulty", b.Height)
+		}
+		return nil
+	}
+
+	if b.Difficulty != 0 || b.Nonce != 0 {
+		return fmt.Errorf("block (%d) is mined but the chain does not use proof-of-work", b.Height)
+	}
+	if params.Consensus == ConsensusPoA {
+		if proposer := params.ProposerAfter(parent.Header, b.Header); proposer.Address() != b.Validator.Address() {
+			return fmt.Errorf("block (%d) signed by (%s) out of turn, the proposer is (%s)", b.Height, b.Validator.Address(), proposer.Address())
+		}
+		return nil
+	}
+	if !params.IsValidator(b.Validator.Address()) {
+		return fmt.Errorf("block (%d) signed by (%s) which is not a validator", b.Height, b.Validator.Address())
+	}
+
+	return nil
+}
+
+// lowestFork returns the lowest height a branch may leave the chain at: not
+// below the finalized height and not deeper than maxForkDepth below the
+// head. The lock must be held.
+func (bc *Blockchain) lowestFork() uint32 {
+	height := bc.headers[len(bc.headers)-1].Height
+	if height < maxForkDepth {
+		return bc.finalized
+	}
+
+	return max(bc.finalized, height-maxForkDepth)
+}
+
+// forkHeight returns the height at which the branch of the block leaves the
+// chain, the block's own height when it is part of the chain. The lock must
+// be held.
+func (bc *Blockchain) forkHeight(b *Block) uint32 {
+	for {
+		if _, ok := bc.blockStore[b.Hash(BlockHasher{})]; ok {
+			return b.Height
+		}
+		parent, ok := bc.sideBlocks[b.PrevBlockHash]
+		if !ok {
+			return b.Height - 1
+		}
+		b = parent
+	}
+}
+
+// reorg makes head the head of the chain: it reverts the blocks down to the
+// fork and applies the branch of head. When a block of the branch turns out
+// to be invalid the branch is dropped from there on and the chain restored.
+func (bc *Blockchain) reorg(head *Block) error {
+	bc.lock.RLock()
+	branch := []*Block{}
+	hash := head.Hash(BlockHasher{})
+	for {
+		b, ok := bc.sideBlocks[hash]
+		if !ok {
+			break
+		}
+		branch = append(branch, b)
+		hash = b.PrevBlockHash
+	}
+	fork := bc.blockStore[hash].Height
+	bc.lock.RUnlock()
+	slices.Reverse(branch)
+
+	removed := bc.revertTo(fork)
+	for i, b := range branch {
+		err := bc.validator.ValidateBlock(b)
+		if err == nil {
+			bc.takeSideBlock(b)
+			err = bc.addBlockWithoutValidation(b)
+		}
+		if err != nil {
+			bc.dropSideBlocks(branch[i:])
+			bc.revertTo(fork)
+			for _, b := range removed {
+				bc.takeSideBlock(b)
+				if err := bc.addBlockWithoutValidation(b); err != nil {
+					return err
+				}
+			}
+
+			return fmt.Errorf("branch of block (%d) is invalid at height (%d): %w", head.Height, b.Height, err)
+		}
+	}
+
+	bc.logger.Log(
+		"msg", "chain reorganized",
+		"fork", fork,
+		"removed", len(removed),
+		"added", len(branch),
+		"head", head.Hash(BlockHasher{}),
+	)
+
+	if bc.reorgHandler != nil {
+		bc.reorgHandler(removed, branch)
+	}
+
+	return nil
+}
+
+// revertTo removes the blocks above the given height from the chain and
+// undoes their state changes, it returns them in ascending height. They are
+// kept as side blocks.
+func (bc *Blockchain) revertTo(height uint32) []*Block {
+	bc.lock.Lock()
+	// never below the finalized height, so at or above a snapshot
+	i := int(height - bc.base)
+	removed := slices.Clone(bc.blocks[i+1:])
+	diffs := slices.Clone(bc.diffs[i+1:])
+	bc.headers = bc.headers[:i+1]
+	bc.blocks = bc.blocks[:i+1]
+	bc.diffs = bc.diffs[:i+1]
+	bc.snapshots = slices.DeleteFunc(bc.snapshots, func(s *Snapshot) bool { return s.Height > height })
+
+	for j, b := range removed {
+		hash := b.Hash(BlockHasher{})
+		delete(bc.blockStore, hash)
+		bc.sideBlocks[hash] = b
+		for _, tx := range b.Transactions {
+			// a dropped tx may be part of an earlier block
+			if !diffs[j].Applied(tx) {
+				continue
+			}
+			delete(bc.txStore, tx.Hash(TxHasher{}))
+			delete(bc.receiptStore, tx.Hash(TxHasher{}))
+		}
+	}
+	parent := bc.blocks[i]
+	bc.totalWork.Set(bc.work[parent.Hash(BlockHasher{})])
+	bc.lock.Unlock()
+
+	bc.stateLock.Lock()
+	for i := len(diffs) - 1; i >= 0; i-- {
+		bc.contractState.RevertDiff(diffs[i].Storage)
+		bc.accountState.RevertDiff(diffs[i])
+	}
+	bc.accountState.SetBlock(parent.Height, parent.Timestamp)
+	bc.stateLock.Unlock()
+
+	return removed
+}
+
+// takeSideBlock removes a block from the side blocks before it joins the chain.
+func (bc *Blockchain) takeSideBlock(b *Block) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	delete(bc.sideBlocks, b.Hash(BlockHasher{}))
+}
+
+// dropSideBlocks removes invalid blocks together with every side block
+// building on them.
+func (bc *Blockchain) dropSideBlocks(blocks []*Block) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	for _, b := range blocks {
+		hash := b.Hash(BlockHasher{})
+		delete(bc.sideBlocks, hash)
+		delete(bc.work, hash)
+	}
+
+	for dropped := true; dropped; {
+		dropped = false
+		for hash, b := range bc.sideBlocks {
+			_, canonical := bc.blockStore[b.PrevBlockHash]
+			_, side := bc.sideBlocks[b.PrevBlockHash]
+			if !canonical && !side {
+				delete(bc.sideBlocks, hash)
+				delete(bc.work, hash)
+				dropped = true
+			}
+		}
+	}
+}
+
+// pruneSideBlocks drops the side blocks that can't become part of the chain
+// anymore as their branch forks below the finalized height, or too deep below
+// the head. The lock must be held.
+func (bc *Blockchain) pruneSideBlocks() {
+	lowest := bc.lowestFork()
+	stale := []types.Hash{}
+	for hash, b := range bc.sideBlocks {
+		if bc.forkHeight(b) < lowest {
+			stale = append(stale, hash)
+		}
+	}
+	for _, hash := range stale {
+		delete(bc.sideBlocks, hash)
+		delete(bc.work, hash)
+	}
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+	"time"
+)
+
+// childBlock signs a block on top of the given parent, which may be off the chain.
+func childBlock(t *testing.T, parent *Block, privKey crypto.PrivateKey, txx []*Transaction) *Block {
+	b, err := NewBlockFromPrevHeader(parent.Header, txx)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+// forkedBlockchain returns a chain whose blocks 2 and 3 are produced by a
+// and include a transfer of the sender, it forks after block 1.
+func forkedBlockchain(t *testing.T) (bc *Blockchain, fork *Block, a, sender crypto.PrivateKey, tx *Transaction) {
+	bc = newBlockchainWithGenesis(t)
+	setParams(t, bc, func(p *Params) { p.Reward = RewardSchedule{InitialReward: 10} })
+	sender, a = crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	fork = nextBlock(t, bc, crypto.GeneratePrivateKey(), nil)
+	assert.Nil(t, bc.AddBlock(fork))
+
+	tx = &Transaction{
+		To:    crypto.GeneratePrivateKey().PublicKey(),
+		Value: 20,
+		Fee:   5,
+	}
+	assert.Nil(t, tx.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, a, []*Transaction{tx})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, a, nil)))
+
+	return bc, fork, a, sender, tx
+}
+
+func TestReorgToHeavierBranch(t *testing.T) {
+	bc, fork, a, sender, tx := forkedBlockchain(t)
+	head, err := bc.GetBlock(3)
+	assert.Nil(t, err)
+
+	var removed, added []*Block
+	bc.SetReorgHandler(func(r, a []*Block) { removed, added = r, a })
+
+	b := crypto.GeneratePrivateKey()
+	branch := []*Block{childBlock(t, fork, b, nil)}
+	branch = append(branch, childBlock(t, branch[0], b, nil))
+
+	// the same work keeps the chain
+	for _, blk := range branch {
+		assert.Nil(t, bc.AddBlock(blk))
+	}
+	assert.ErrorIs(t, bc.AddBlock(branch[1]), ErrBlockKnown)
+	assert.Equal(t, uint32(3), bc.Height())
+	assert.Nil(t, removed)
+
+	branch = append(branch, childBlock(t, branch[1], b, nil))
+	assert.Nil(t, bc.AddBlock(branch[2]))
+
+	assert.Equal(t, uint32(4), bc.Height())
+	tip, err := bc.GetBlock(4)
+	assert.Nil(t, err)
+	assert.Equal(t, branch[2], tip)
+	assert.Equal(t, int64(5), bc.TotalWork().Int64())
+	assert.Len(t, removed, 2)
+	assert.Equal(t, head, removed[1])
+	assert.Equal(t, branch, added)
+
+	// the state of the abandoned blocks is undone
+	assert.False(t, bc.HasTx(tx.Hash(TxHasher{})))
+	assertBalance(t, bc, sender.PublicKey().Address(), 100)
+	_, err = bc.accountState.GetAccount(tx.To.Address())
+	assert.NotNil(t, err)
+	_, err = bc.accountState.GetAccount(a.PublicKey().Address())
+	assert.NotNil(t, err)
+	assertBalance(t, bc, b.PublicKey().Address(), 30)
+
+	// the tx can be included again
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, b, []*Transaction{tx})))
+	assert.True(t, bc.HasTx(tx.Hash(TxHasher{})))
+	assertBalance(t, bc, sender.PublicKey().Address(), 75)
+
+	// the abandoned blocks can still become the chain again
+	next := childBlock(t, head, a, nil)
+	assert.Nil(t, bc.AddBlock(next))
+	assert.Nil(t, bc.AddBlock(childBlock(t, next, a, nil)))
+	assert.Nil(t, bc.AddBlock(childBlock(t, bc.blocks[bc.Height()], a, nil)))
+	assert.Equal(t, uint32(6), bc.Height())
+	assertBalance(t, bc, tx.To.Address(), 20)
+}
+
+func TestReorgToInvalidBranch(t *testing.T) {
+	bc, fork, _, sender, _ := forkedBlockchain(t)
+	head, err := bc.GetBlock(3)
+	assert.Nil(t, err)
+
+	b := crypto.GeneratePrivateKey()
+	valid := childBlock(t, fork, b, nil)
+	invalid, err := NewBlockFromPrevHeader(valid.Header, nil)
+	assert.Nil(t, err)
+	invalid.EvidenceHash = invalid.DataHash
+	assert.Nil(t, invalid.Sign(b))
+
+	assert.Nil(t, bc.AddBlock(valid))
+	assert.Nil(t, bc.AddBlock(invalid))
+	assert.NotNil(t, bc.AddBlock(childBlock(t, invalid, b, nil)))
+
+	tip, err := bc.GetBlock(3)
+	assert.Nil(t, err)
+	assert.Equal(t, head, tip)
+	assert.Equal(t, int64(4), bc.TotalWork().Int64())
+	assertBalance(t, bc, sender.PublicKey().Address(), 75)
+	_, err = bc.accountState.GetAccount(b.PublicKey().Address())
+	assert.NotNil(t, err)
+
+	// the invalid block and its descendants are gone
+	assert.Len(t, bc.sideBlocks, 1)
+	assert.NotNil(t, bc.AddBlock(childBlock(t, invalid, b, nil)))
+}
+
+func TestReorgBelowFinalized(t *testing.T) {
+	bc, validators := newBFTBlockchain(t, 4)
+	genesis, err := bc.GetBlock(0)
+	assert.Nil(t, err)
+
+	b := nextBlock(t, bc, validators[0], nil)
+	commitBlock(t, b, 0, validators[1:])
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.FinalizedHeight())
+
+	assert.NotNil(t, bc.AddBlock(childBlock(t, genesis, validators[1], nil)))
+	assert.Empty(t, bc.sideBlocks)
+}
+
+func TestSideBlockSeal(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+	genesis, err := bc.GetBlock(0)
+	assert.Nil(t, err)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validators[1], nil)))
+
+	// a side block follows the turns of the chain too
+	assert.NotNil(t, bc.AddBlock(childBlock(t, genesis, crypto.GeneratePrivateKey(), nil)))
+	assert.NotNil(t, bc.AddBlock(childBlock(t, genesis, validators[2], nil)))
+	assert.Empty(t, bc.sideBlocks)
+	assert.Nil(t, bc.AddBlock(childBlock(t, genesis, validators[1], []*Transaction{randomTxWithSignature(t)})))
+	assert.Len(t, bc.sideBlocks, 1)
+
+	mined := newMinedBlockchain(t)
+	genesis, err = mined.GetBlock(0)
+	assert.Nil(t, err)
+	miner := crypto.GeneratePrivateKey()
+	assert.Nil(t, mined.AddBlock(nextMinedBlock(t, mined, miner, time.Minute)))
+
+	// a side block has to be mined
+	b, err := NewBlockFromPrevHeader(genesis.Header, nil)
+	assert.Nil(t, err)
+	b.Difficulty = 1 << 40
+	assert.Nil(t, b.Sign(miner))
+	assert.NotNil(t, mined.AddBlock(b))
+	assert.Empty(t, mined.sideBlocks)
+}
+
+func TestSideBlockLimits(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesis, err := bc.GetBlock(0)
+	assert.Nil(t, err)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, crypto.GeneratePrivateKey(), nil)))
+
+	for i := 0; i < maxSideBlocksPerHeight; i++ {
+		assert.Nil(t, bc.AddBlock(childBlock(t, genesis, crypto.GeneratePrivateKey(), nil)))
+	}
+	assert.NotNil(t, bc.AddBlock(childBlock(t, genesis, crypto.GeneratePrivateKey(), nil)))
+	assert.Len(t, bc.sideBlocks, maxSideBlocksPerHeight)
+
+	// the branches are dropped once too deep below the head
+	for i := 0; i < maxForkDepth; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, crypto.GeneratePrivateKey(), nil)))
+	}
+	assert.Empty(t, bc.sideBlocks)
+	assert.NotNil(t, bc.AddBlock(childBlock(t, genesis, crypto.GeneratePrivateKey(), nil)))
+	assert.Empty(t, bc.sideBlocks)
+}
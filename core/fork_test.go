@@ -0,0 +1,40 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestForkScheduleValidate(t *testing.T) {
+	assert.Nil(t, DefaultForkSchedule.Validate())
+	assert.NotNil(t, ForkSchedule{}.Validate())
+	assert.NotNil(t, ForkSchedule{{Height: 1, Version: 1}}.Validate())
+	assert.NotNil(t, ForkSchedule{{Height: 0, Version: 1}, {Height: 5, Version: 99}}.Validate())
+	assert.NotNil(t, ForkSchedule{{Height: 0, Version: 2}, {Height: 5, Version: 1}}.Validate())
+}
+
+func TestForkScheduleVersionAt(t *testing.T) {
+	s := ForkSchedule{{Height: 0, Version: 1}, {Height: 10, Version: 2}}
+
+	assert.Equal(t, uint32(1), s.VersionAt(0))
+	assert.Equal(t, uint32(1), s.VersionAt(9))
+	assert.Equal(t, uint32(2), s.VersionAt(10))
+	assert.Equal(t, uint32(2), s.VersionAt(1000))
+}
+
+func TestAddBlockWithWrongVersion(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	assert.Nil(t, bc.SetForkSchedule(ForkSchedule{{Height: 0, Version: 1}, {Height: 2, Version: 2}}))
+	validator := crypto.GeneratePrivateKey()
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, nil)))
+
+	// still version 1 at the fork height
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, validator, nil)))
+
+	b := nextBlock(t, bc, validator, nil)
+	b.Version = 2
+	assert.Nil(t, b.Sign(validator))
+	assert.Nil(t, bc.AddBlock(b))
+}
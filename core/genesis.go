@@ -0,0 +1,63 @@
+package core
+
+import (
+	"sharkchain/crypto"
+)
+
+// GenesisConfig describes the allocations made by the genesis block. All of
+// them are paid out of the coinbase account.
+type GenesisConfig struct {
+	Timestamp int64
+	// Params default to DefaultParams when nil.
+	Params *Params
+	// Supply is the amount of the native asset minted to the coinbase account.
+	Supply uint64
+	// Vesting grants locked funds, e.g. for the team.
+	Vesting []VestingTx
+}
+
+var DefaultGenesis = GenesisConfig{
+	Supply: 10_000_000,
+}
+
+// Block builds the genesis block, it still has to be signed.
+func (g GenesisConfig) Block() (*Block, error) {
+	coinbase := crypto.PublicKey{}
+
+	txx := []*Transaction{}
+	if g.Params != nil {
+		tx := NewTransaction(nil)
+		tx.From = coinbase
+		tx.TxInner = ParamsTx{Params: *g.Params}
+		txx = append(txx, tx)
+	}
+
+	if g.Supply > 0 {
+		tx := NewTransaction(nil)
+		tx.From = coinbase
+		tx.To = coinbase
+		tx.Value = g.Supply
+		txx = append(txx, tx)
+	}
+
+	for _, vesting := range g.Vesting {
+		tx := NewTransaction(nil)
+		tx.From = coinbase
+		tx.TxInner = vesting
+		txx = append(txx, tx)
+	}
+
+	dataHash, err := CalculateDataHash(txx)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &Header{
+		Version:   1,
+		DataHash:  dataHash,
+		Height:    0,
+		Timestamp: g.Timestamp,
+	}
+
+	return NewBlock(header, txx)
+}
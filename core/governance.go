@@ -0,0 +1,336 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sharkchain/crypto"
+	"sharkchain/types"
+)
+
+var (
+	ErrProposalNotFound = errors.New("proposal not found")
+	// ErrNoVotingPower is returned for proposals on chains without
+	// validators, e.g. open signer chains, as nobody could vote on them.
+	ErrNoVotingPower = errors.New("no validator can vote on proposals")
+)
+
+type ProposalStatus byte
+
+const (
+	ProposalVoting ProposalStatus = iota
+	// ProposalPassed is waiting for its activation height.
+	ProposalPassed
+	ProposalExecuted
+	// ProposalRejected did not reach a quorum before its activation height.
+	ProposalRejected
+)
+
+func (s ProposalStatus) String() string {
+	switch s {
+	case ProposalVoting:
+		return "voting"
+	case ProposalPassed:
+		return "passed"
+	case ProposalExecuted:
+		return "executed"
+	case ProposalRejected:
+		return "rejected"
+	default:
+		return "unknown"
+	}
+}
+
+type ProposalKind byte
+
+const (
+	// ProposalParams replaces the chain parameters as a whole.
+	ProposalParams ProposalKind = iota
+	// ProposalValidators adds and removes validators, leaving the other
+	// parameters as they are at activation.
+	ProposalValidators
+)
+
+func (k ProposalKind) String() string {
+	switch k {
+	case ProposalParams:
+		return "params"
+	case ProposalValidators:
+		return "validators"
+	default:
+		return "unknown"
+	}
+}
+
+// Proposal is a parameter change as tracked in the chain state. Its ID is the
+// hash of the tx that proposed it.
+type Proposal struct {
+	ID       types.Hash
+	Proposer types.Address
+	Kind     ProposalKind
+	// Params replace the current parameters as a whole, only set for
+	// ProposalParams.
+	Params           Params
+	AddValidators    []crypto.PublicKey
+	RemoveValidators []crypto.PublicKey
+	ActivationHeight uint32
+	Status           ProposalStatus
+	// Electorate and Powers are the validators and their voting power when
+	// the proposal was made, changes to the set don't affect the vote.
+	Electorate []types.Address
+	Powers     []uint64
+	TotalPower uint64
+	Voters     []types.Address
+	Approvals  uint64
+}
+
+// ProposalTx proposes to replace the chain parameters by Params starting
+// with the block at ActivationHeight. Voting ends at that height.
+type ProposalTx struct {
+	Params           Params
+	ActivationHeight uint32
+}
+
+// ValidatorProposalTx proposes to add and remove validators starting with the
+// block at ActivationHeight. Voting ends at that height.
+type ValidatorProposalTx struct {
+	Add              []crypto.PublicKey
+	Remove           []crypto.PublicKey
+	ActivationHeight uint32
+}
+
+// VoteTx casts the voting power of the sender on a proposal.
+type VoteTx struct {
+	Proposal types.Hash
+	Approve  bool
+}
+
+func proposalKey(id types.Hash) []byte {
+	return append([]byte("gov/proposal/"), id.ToSlice()...)
+}
+
+// pendingProposalsKey holds the IDs of proposals waiting for their activation height.
+var pendingProposalsKey = []byte("gov/pending")
+
+const (
+	// maxPendingProposals bounds the proposals walked after every block.
+	maxPendingProposals = 64
+	// maxProposalsPerProposer keeps a single validator from taking all
+	// pending slots.
+	maxProposalsPerProposer = 4
+)
+
+// votingPower returns the weight of the vote of the given address on the
+// proposal, the same as in consensus when it was made.
+func (p *Proposal) votingPower(address types.Address) uint64 {
+	for i, v := range p.Electorate {
+		if v == address {
+			return p.Powers[i]
+		}
+	}
+
+	return 0
+}
+
+func (bc *Blockchain) getProposal(id types.Hash) (*Proposal, error) {
+	proposal := &Proposal{}
+	found, err := bc.contractState.getGob(proposalKey(id), proposal)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrProposalNotFound
+	}
+
+	return proposal, nil
+}
+
+func (bc *Blockchain) pendingProposals() ([]types.Hash, error) {
+	pending := []types.Hash{}
+	if _, err := bc.contractState.getGob(pendingProposalsKey, &pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func (bc *Blockchain) handleProposal(tx *Transaction, b *Block, p ProposalTx) error {
+	if err := p.Params.Validate(); err != nil {
+		return err
+	}
+
+	return bc.addProposal(tx, b, &Proposal{
+		Kind:             ProposalParams,
+		Params:           p.Params,
+		ActivationHeight: p.ActivationHeight,
+	})
+}
+
+func (bc *Blockchain) handleValidatorProposal(tx *Transaction, b *Block, p ValidatorProposalTx) error {
+	// checked again at activation as the set may have changed by then
+	if _, err := bc.params().changeValidators(p.Add, p.Remove); err != nil {
+		return err
+	}
+
+	return bc.addProposal(tx, b, &Proposal{
+		Kind:             ProposalValidators,
+		AddValidators:    p.Add,
+		RemoveValidators: p.Remove,
+		ActivationHeight: p.ActivationHeight,
+	})
+}
+
+// addProposal opens the vote on the given proposal, identified by the tx
+// proposing it.
+func (bc *Blockchain) addProposal(tx *Transaction, b *Block, proposal *Proposal) error {
+	params := bc.params()
+	if params.TotalPower() == 0 {
+		return ErrNoVotingPower
+	}
+	proposer := tx.Sender()
+	if params.Power(proposer) == 0 {
+		return fmt.Errorf("(%s) is not a validator and can't make proposals", proposer)
+	}
+	if proposal.ActivationHeight <= b.Height+1 {
+		return fmt.Errorf("activation height (%d) must leave time to vote after height (%d)", proposal.ActivationHeight, b.Height)
+	}
+
+	id := tx.Hash(TxHasher{})
+	if _, err := bc.getProposal(id); err != ErrProposalNotFound {
+		return fmt.Errorf("proposal (%s) already exists", id)
+	}
+
+	pending, err := bc.pendingProposals()
+	if err != nil {
+		return err
+	}
+	if len(pending) >= maxPendingProposals {
+		return fmt.Errorf("%d proposals are already pending", len(pending))
+	}
+	own := 0
+	for _, pendingID := range pending {
+		p, err := bc.getProposal(pendingID)
+		if err != nil {
+			return err
+		}
+		if p.Proposer == proposer {
+			own++
+		}
+	}
+	if own >= maxProposalsPerProposer {
+		return fmt.Errorf("(%s) already has %d pending proposals", proposer, own)
+	}
+	if err := bc.contractState.putGob(pendingProposalsKey, append(pending, id)); err != nil {
+		return err
+	}
+
+	proposal.ID = id
+	proposal.Proposer = proposer
+	proposal.Status = ProposalVoting
+	proposal.TotalPower = params.TotalPower()
+	for _, v := range params.Validators {
+		proposal.Electorate = append(proposal.Electorate, v.Address())
+		proposal.Powers = append(proposal.Powers, params.Power(v.Address()))
+	}
+
+	return bc.contractState.putGob(proposalKey(id), proposal)
+}
+
+func (bc *Blockchain) handleVote(tx *Transaction, vote VoteTx) error {
+	proposal, err := bc.getProposal(vote.Proposal)
+	if err != nil {
+		return err
+	}
+	if proposal.Status != ProposalVoting {
+		return fmt.Errorf("proposal (%s) is not open for voting", proposal.ID)
+	}
+
+	voter := tx.Sender()
+	power := proposal.votingPower(voter)
+	if power == 0 {
+		return fmt.Errorf("(%s) has no voting power on proposal (%s)", voter, proposal.ID)
+	}
+	for _, v := range proposal.Voters {
+		if v == voter {
+			return fmt.Errorf("(%s) already voted on proposal (%s)", voter, proposal.ID)
+		}
+	}
+
+	proposal.Voters = append(proposal.Voters, voter)
+	if vote.Approve {
+		proposal.Approvals += power
+	}
+
+	// more than 2/3 of the voting power approves
+	if HasQuorum(proposal.Approvals, proposal.TotalPower) {
+		proposal.Status = ProposalPassed
+	}
+
+	return bc.contractState.putGob(proposalKey(proposal.ID), proposal)
+}
+
+// activateProposals runs after block b has been applied. It applies the
+// passed proposals that activate with the next block and rejects the ones
+// that ran out of time.
+func (bc *Blockchain) activateProposals(b *Block) error {
+	pending, err := bc.pendingProposals()
+	if err != nil {
+		return err
+	}
+
+	stillPending := []types.Hash{}
+	for _, id := range pending {
+		proposal, err := bc.getProposal(id)
+		if err != nil {
+			return err
+		}
+
+		if proposal.ActivationHeight > b.Height+1 {
+			stillPending = append(stillPending, id)
+			continue
+		}
+
+		if proposal.Status == ProposalPassed {
+			params, err := bc.proposedParams(proposal)
+			if err != nil {
+				proposal.Status = ProposalRejected
+				bc.logger.Log("msg", "passed proposal cannot be applied", "id", id, "err", err)
+			} else {
+				if err := bc.contractState.putGob(paramsKey, params); err != nil {
+					return err
+				}
+				proposal.Status = ProposalExecuted
+
+				bc.logger.Log("msg", "activated proposal", "id", id, "height", b.Height+1)
+			}
+		} else {
+			proposal.Status = ProposalRejected
+		}
+
+		if err := bc.contractState.putGob(proposalKey(id), proposal); err != nil {
+			return err
+		}
+	}
+
+	if len(stillPending) == len(pending) {
+		return nil
+	}
+
+	return bc.contractState.putGob(pendingProposalsKey, stillPending)
+}
+
+// proposedParams returns the parameters in effect once the proposal is executed.
+func (bc *Blockchain) proposedParams(proposal *Proposal) (Params, error) {
+	if proposal.Kind == ProposalValidators {
+		return bc.params().changeValidators(proposal.AddValidators, proposal.RemoveValidators)
+	}
+
+	return proposal.Params, nil
+}
+
+// GetProposal returns the governance proposal with the given ID.
+func (bc *Blockchain) GetProposal(id types.Hash) (*Proposal, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.getProposal(id)
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+	"time"
+)
+
+func newBlockchainWithValidators(t *testing.T, n int) (*Blockchain, []crypto.PrivateKey) {
+	validators := make([]crypto.PrivateKey, n)
+	params := DefaultParams
+	params.Consensus = ConsensusPoA
+	params.Validators = nil
+	for i := range validators {
+		validators[i] = crypto.GeneratePrivateKey()
+		params.Validators = append(params.Validators, validators[i].PublicKey())
+	}
+
+	genesis, err := GenesisConfig{Params: &params}.Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	return bc, validators
+}
+
+// proposer returns the key whose turn it is to sign the next block.
+func proposer(t *testing.T, bc *Blockchain, validators []crypto.PrivateKey) crypto.PrivateKey {
+	address := bc.Params().Proposer(bc.Height() + 1).Address()
+	for _, v := range validators {
+		if v.PublicKey().Address() == address {
+			return v
+		}
+	}
+
+	t.Fatalf("proposer (%s) is not one of the given keys", address)
+	return crypto.PrivateKey{}
+}
+
+func TestBlockFromUnknownValidator(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 2)
+
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, crypto.GeneratePrivateKey(), nil)))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validators[1], nil)))
+}
+
+func TestBlockOutOfTurn(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+
+	// block 1 is for validators[1], then 2, 0, 1...
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, validators[0], nil)))
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, validators[2], nil)))
+	for i := 1; i <= 4; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validators[i%3], nil)))
+	}
+}
+
+func TestBlockOfLateProposer(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+	blockTime := bc.Params().BlockTime
+	first := nextBlock(t, bc, validators[1], nil)
+	first.Timestamp = time.Now().Add(-time.Minute).UnixNano()
+	assert.Nil(t, first.Sign(validators[1]))
+	assert.Nil(t, bc.AddBlock(first))
+
+	// the next block signed the given time after the last one
+	after := func(d time.Duration, privKey crypto.PrivateKey) *Block {
+		prevHeader, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		b := nextBlock(t, bc, privKey, nil)
+		b.Timestamp = prevHeader.Timestamp + int64(d)
+		assert.Nil(t, b.Sign(privKey))
+		return b
+	}
+
+	// block 2 is for validators[2], validators[0] takes over once it is late
+	assert.NotNil(t, bc.AddBlock(after(blockTime, validators[0])))
+	assert.NotNil(t, bc.AddBlock(after(2*blockTime, validators[2])))
+	assert.NotNil(t, bc.AddBlock(after(3*blockTime, validators[0])))
+	assert.Nil(t, bc.AddBlock(after(2*blockTime, validators[0])))
+
+	// the turns go on from the height
+	assert.NotNil(t, bc.AddBlock(after(blockTime, validators[1])))
+	assert.Nil(t, bc.AddBlock(after(blockTime, validators[0])))
+}
+
+func TestProposalActivation(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+
+	params := bc.Params()
+	params.MinFee = 5
+
+	propose := &Transaction{TxInner: ProposalTx{Params: params, ActivationHeight: 4}}
+	assert.Nil(t, propose.Sign(validators[0]))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{propose})))
+	id := propose.Hash(TxHasher{})
+
+	votes := []*Transaction{}
+	for _, v := range validators[:2] {
+		vote := &Transaction{TxInner: VoteTx{Proposal: id, Approve: true}}
+		assert.Nil(t, vote.Sign(v))
+		votes = append(votes, vote)
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), votes)))
+
+	// two out of three is not more than 2/3
+	proposal, err := bc.GetProposal(id)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalVoting, proposal.Status)
+
+	vote := &Transaction{TxInner: VoteTx{Proposal: id, Approve: true}}
+	assert.Nil(t, vote.Sign(validators[2]))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{vote})))
+
+	proposal, err = bc.GetProposal(id)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalExecuted, proposal.Status)
+	assert.Equal(t, uint64(5), bc.Params().MinFee)
+}
+
+func TestProposalRejectedWithoutQuorum(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+
+	params := bc.Params()
+	params.MinFee = 5
+
+	propose := &Transaction{TxInner: ProposalTx{Params: params, ActivationHeight: 3}}
+	assert.Nil(t, propose.Sign(validators[0]))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{propose})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), nil)))
+
+	proposal, err := bc.GetProposal(propose.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalRejected, proposal.Status)
+	assert.Equal(t, uint64(0), bc.Params().MinFee)
+}
+
+func TestValidatorProposal(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 2)
+	newValidator := crypto.GeneratePrivateKey()
+
+	propose := &Transaction{TxInner: ValidatorProposalTx{
+		Add:              []crypto.PublicKey{newValidator.PublicKey()},
+		Remove:           []crypto.PublicKey{validators[0].PublicKey()},
+		ActivationHeight: 3,
+	}}
+	assert.Nil(t, propose.Sign(validators[0]))
+
+	votes := []*Transaction{}
+	for _, v := range validators {
+		vote := &Transaction{TxInner: VoteTx{Proposal: propose.Hash(TxHasher{}), Approve: true}}
+		assert.Nil(t, vote.Sign(v))
+		votes = append(votes, vote)
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{propose})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), votes)))
+
+	proposal, err := bc.GetProposal(propose.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalExecuted, proposal.Status)
+
+	params := bc.Params()
+	assert.Equal(t, []crypto.PublicKey{validators[1].PublicKey(), newValidator.PublicKey()}, params.Validators)
+	assert.False(t, params.IsValidator(validators[0].PublicKey().Address()))
+
+	// block 3 is for newValidator
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, validators[0], nil)))
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, validators[1], nil)))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, newValidator, nil)))
+}
+
+func TestValidatorProposalInvalid(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 2)
+
+	for _, p := range []ValidatorProposalTx{
+		{ActivationHeight: 3},
+		{Add: []crypto.PublicKey{validators[0].PublicKey()}, ActivationHeight: 3},
+		{Remove: []crypto.PublicKey{crypto.GeneratePrivateKey().PublicKey()}, ActivationHeight: 3},
+		{Remove: []crypto.PublicKey{validators[0].PublicKey(), validators[1].PublicKey()}, ActivationHeight: 3},
+	} {
+		propose := &Transaction{TxInner: p}
+		assert.Nil(t, propose.Sign(validators[0]))
+
+		b := nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{propose})
+		assert.Nil(t, bc.AddBlock(b))
+		assert.Equal(t, 0, appliedTxs(t, bc, b))
+	}
+}
+
+func TestProposalWithoutValidators(t *testing.T) {
+	// the default open signer chain
+	bc := newBlockchainWithGenesis(t)
+	assert.Empty(t, bc.Params().Validators)
+
+	params := bc.Params()
+	params.MinFee = 5
+	propose := &Transaction{TxInner: ProposalTx{Params: params, ActivationHeight: 3}}
+	assert.Nil(t, propose.Sign(crypto.GeneratePrivateKey()))
+	b := nextBlock(t, bc, crypto.GeneratePrivateKey(), []*Transaction{propose})
+	assert.Nil(t, bc.AddBlock(b))
+
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+	_, err := bc.GetProposal(propose.Hash(TxHasher{}))
+	assert.ErrorIs(t, err, ErrProposalNotFound)
+}
+
+func TestProposalFromNonValidator(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 3)
+
+	params := bc.Params()
+	params.MinFee = 5
+	propose := &Transaction{TxInner: ProposalTx{Params: params, ActivationHeight: 4}}
+	assert.Nil(t, propose.Sign(crypto.GeneratePrivateKey()))
+	b := nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{propose})
+	assert.Nil(t, bc.AddBlock(b))
+
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+	_, err := bc.GetProposal(propose.Hash(TxHasher{}))
+	assert.ErrorIs(t, err, ErrProposalNotFound)
+}
+
+func TestProposalVotersFixedAtProposal(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 2)
+	newValidator := crypto.GeneratePrivateKey()
+
+	params := bc.Params()
+	params.MinFee = 5
+	propose := &Transaction{TxInner: ProposalTx{Params: params, ActivationHeight: 10}}
+	assert.Nil(t, propose.Sign(validators[0]))
+	id := propose.Hash(TxHasher{})
+
+	// the set grows to three once the proposal is open
+	add := &Transaction{TxInner: ValidatorProposalTx{Add: []crypto.PublicKey{newValidator.PublicKey()}, ActivationHeight: 3}}
+	assert.Nil(t, add.Sign(validators[0]))
+	votes := []*Transaction{}
+	for _, v := range validators {
+		vote := &Transaction{TxInner: VoteTx{Proposal: add.Hash(TxHasher{}), Approve: true}}
+		assert.Nil(t, vote.Sign(v))
+		votes = append(votes, vote)
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{propose, add})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), votes)))
+	assert.Len(t, bc.Params().Validators, 3)
+	validators = append(validators, newValidator)
+
+	// the new validator has no say on the older proposal
+	vote := &Transaction{TxInner: VoteTx{Proposal: id, Approve: true}}
+	assert.Nil(t, vote.Sign(newValidator))
+	b := nextBlock(t, bc, proposer(t, bc, validators), []*Transaction{vote})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	// both validators of the time are a quorum, out of a total of two
+	votes = []*Transaction{}
+	for _, v := range validators[:2] {
+		vote := &Transaction{TxInner: VoteTx{Proposal: id, Approve: true}}
+		assert.Nil(t, vote.Sign(v))
+		votes = append(votes, vote)
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, proposer(t, bc, validators), votes)))
+
+	proposal, err := bc.GetProposal(id)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalPassed, proposal.Status)
+	assert.Equal(t, uint64(2), proposal.TotalPower)
+}
+
+func TestPendingProposalsCapped(t *testing.T) {
+	bc, validators := newBlockchainWithValidators(t, 1)
+
+	params := bc.Params()
+	txs := []*Transaction{}
+	for i := 0; i <= maxProposalsPerProposer; i++ {
+		params.MinFee = uint64(i + 1)
+		propose := &Transaction{TxInner: ProposalTx{Params: params, ActivationHeight: 10}}
+		assert.Nil(t, propose.Sign(validators[0]))
+		txs = append(txs, propose)
+	}
+	b := nextBlock(t, bc, validators[0], txs)
+	assert.Nil(t, bc.AddBlock(b))
+
+	assert.Equal(t, maxProposalsPerProposer, appliedTxs(t, bc, b))
+	pending, err := bc.pendingProposals()
+	assert.Nil(t, err)
+	assert.Len(t, pending, maxProposalsPerProposer)
+}
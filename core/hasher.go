@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/gob"
 	"sharkchain/types"
 )
 
@@ -22,15 +23,36 @@ func (BlockHasher) Hash(b *Header) types.Hash {
 
 type TxHasher struct{}
 
-// Hash will hash the whole bytes of the TX no exception.
+// Hash will hash the whole bytes of the TX except the signature.
 func (TxHasher) Hash(tx *Transaction) types.Hash {
 	buf := new(bytes.Buffer)
 
-	binary.Write(buf, binary.LittleEndian, tx.Data)
-	//binary.Write(buf, binary.LittleEndian, tx.To)
-	//binary.Write(buf, binary.LittleEndian, tx.Value)
-	//binary.Write(buf, binary.LittleEndian, tx.From)
-	//binary.Write(buf, binary.LittleEndian, tx.Nonce)
+	writeBytes(buf, tx.Data)
+	writeBytes(buf, tx.To)
+	binary.Write(buf, binary.LittleEndian, tx.Value)
+	binary.Write(buf, binary.LittleEndian, tx.Asset)
+	binary.Write(buf, binary.LittleEndian, tx.Fee)
+	writeBytes(buf, tx.From)
+	binary.Write(buf, binary.LittleEndian, tx.Nonce)
+	binary.Write(buf, binary.LittleEndian, tx.ValidUntilHeight)
+	binary.Write(buf, binary.LittleEndian, tx.ValidUntilTime)
+
+	if tx.Multisig != nil {
+		buf.Write(tx.Multisig.Account.ToSlice())
+		writeBytes(buf, tx.Multisig.Keys.Bytes())
+	}
+
+	// gob output is deterministic as long as inner types don't contain maps
+	if tx.TxInner != nil {
+		gob.NewEncoder(buf).Encode(&tx.TxInner)
+	}
 
 	return types.Hash(sha256.Sum256(buf.Bytes()))
 }
+
+// writeBytes writes b prefixed with its length, so that adjacent variable
+// length fields can't be shifted into each other without changing the hash.
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+	buf.Write(b)
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"sharkchain/types"
+	"slices"
+)
+
+// GetAccountAt returns the account as it was after the block at the given
+// height, reverting the diffs of the later blocks on a copy.
+func (bc *Blockchain) GetAccountAt(address types.Address, height uint32) (*Account, error) {
+	// no block may be added while the state and the diffs are read
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	diffs, err := bc.diffsSince(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.accountState.accountBefore(address, diffs)
+}
+
+// GetBalancesAt returns every asset balance held by the address after the
+// block at the given height.
+func (bc *Blockchain) GetBalancesAt(address types.Address, height uint32) (map[AssetID]uint64, error) {
+	account, err := bc.GetAccountAt(address, height)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.NonZeroBalances(), nil
+}
+
+// GetVestingBalancesAt returns the vested and locked amounts of the address
+// after the block at the given height.
+func (bc *Blockchain) GetVestingBalancesAt(address types.Address, height uint32) (map[AssetID]VestingBalance, error) {
+	account, err := bc.GetAccountAt(address, height)
+	if err != nil {
+		return nil, err
+	}
+	header, err := bc.GetHeader(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.VestingBalances(header.Height, header.Timestamp), nil
+}
+
+// GetStorageAt returns the value of a key of the contract state after the
+// block at the given height.
+func (bc *Blockchain) GetStorageAt(key []byte, height uint32) ([]byte, error) {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	diffs, err := bc.diffsSince(height)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.contractState.getBefore(key, diffs)
+}
+
+// diffsSince returns the state diffs of the blocks above the given height,
+// oldest first. The add lock must be held.
+func (bc *Blockchain) diffsSince(height uint32) ([]*StateDiff, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	i, err := bc.index(height)
+	if err != nil {
+		return nil, err
+	}
+	// the diffs of the blocks up to a snapshot are not known
+	if bc.diffs[i] == nil && bc.blocks[i] == nil {
+		return nil, fmt.Errorf("state at height (%d) is older than the snapshot the chain started from", height)
+	}
+
+	return slices.Clone(bc.diffs[i+1:]), nil
+}
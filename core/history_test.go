@@ -0,0 +1,47 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestStateAtHeight(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey().PublicKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	for i, value := range []uint64{20, 30} {
+		tx := &Transaction{To: recipient, Value: value, Fee: 5}
+		assert.Nil(t, tx.Sign(sender))
+		assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte{byte(i)}))
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+	}
+
+	_, err := bc.GetBalancesAt(recipient.Address(), 0)
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+
+	for height, expected := range map[uint32]uint64{1: 20, 2: 50} {
+		balances, err := bc.GetBalancesAt(recipient.Address(), height)
+		assert.Nil(t, err)
+		assert.Equal(t, map[AssetID]uint64{NativeAsset: expected}, balances)
+	}
+	balances, err := bc.GetBalancesAt(sender.PublicKey().Address(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, map[AssetID]uint64{NativeAsset: 75}, balances)
+
+	_, err = bc.GetStorageAt([]byte("foo"), 0)
+	assert.NotNil(t, err)
+	value, err := bc.GetStorageAt([]byte("foo"), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0}, value)
+	value, err = bc.GetStorageAt([]byte("foo"), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, value)
+
+	_, err = bc.GetBalancesAt(recipient.Address(), 3)
+	assert.NotNil(t, err)
+	// the latest state is unchanged
+	assertBalance(t, bc, recipient.Address(), 50)
+}
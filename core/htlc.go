@@ -0,0 +1,176 @@
+package core
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sharkchain/types"
+)
+
+var (
+	ErrHTLCNotFound = errors.New("htlc not found")
+	ErrHTLCSettled  = errors.New("htlc already claimed or refunded")
+)
+
+// htlcEscrow holds the funds of all open HTLCs.
+var htlcEscrow = ModuleAddress("htlc")
+
+type HTLCStatus byte
+
+const (
+	HTLCOpen HTLCStatus = iota
+	HTLCClaimed
+	HTLCRefunded
+)
+
+func (s HTLCStatus) String() string {
+	switch s {
+	case HTLCOpen:
+		return "open"
+	case HTLCClaimed:
+		return "claimed"
+	case HTLCRefunded:
+		return "refunded"
+	default:
+		return "unknown"
+	}
+}
+
+// HTLC is a hash time-locked contract as tracked in the chain state. Its ID
+// is the hash of the tx that locked it, anyone can lock funds under a known
+// hash lock, that doesn't take over the HTLC of the swap.
+type HTLC struct {
+	ID        types.Hash
+	Sender    types.Address
+	Recipient types.Address
+	HashLock  types.Hash
+	Timeout   uint32
+	Asset     AssetID
+	Amount    uint64
+	Status    HTLCStatus
+	// Preimage is revealed by the claim, the other side of a swap needs it.
+	Preimage []byte
+}
+
+// HTLCLockTx locks Amount of Asset from the sender. Recipient can claim it
+// with the preimage of HashLock up to and including block height Timeout,
+// afterwards the sender can get it refunded.
+type HTLCLockTx struct {
+	Recipient types.Address
+	HashLock  types.Hash
+	Timeout   uint32
+	Asset     AssetID
+	Amount    uint64
+}
+
+// HTLCClaimTx pays the HTLC to its recipient, Preimage must hash to its hash
+// lock. Anyone knowing the preimage may send it.
+type HTLCClaimTx struct {
+	HTLC     types.Hash
+	Preimage []byte
+}
+
+// HTLCRefundTx returns the funds of an expired HTLC to its sender.
+type HTLCRefundTx struct {
+	HTLC types.Hash
+}
+
+func htlcKey(id types.Hash) []byte {
+	return append([]byte("htlc/"), id.ToSlice()...)
+}
+
+func (bc *Blockchain) getHTLC(id types.Hash) (*HTLC, error) {
+	htlc := &HTLC{}
+	found, err := bc.contractState.getGob(htlcKey(id), htlc)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrHTLCNotFound
+	}
+
+	return htlc, nil
+}
+
+func (bc *Blockchain) handleHTLCLock(tx *Transaction, b *Block, lock HTLCLockTx) error {
+	if lock.Amount == 0 {
+		return fmt.Errorf("htlc amount can't be zero")
+	}
+	if lock.Timeout <= b.Height {
+		return fmt.Errorf("htlc timeout (%d) must be above the current height (%d)", lock.Timeout, b.Height)
+	}
+
+	id := tx.Hash(TxHasher{})
+	if _, err := bc.getHTLC(id); err != ErrHTLCNotFound {
+		return fmt.Errorf("htlc (%s) already exists", id)
+	}
+
+	if err := bc.accountState.Transfer(tx.Sender(), htlcEscrow, lock.Asset, lock.Amount); err != nil {
+		return err
+	}
+
+	return bc.contractState.putGob(htlcKey(id), &HTLC{
+		ID:        id,
+		Sender:    tx.Sender(),
+		Recipient: lock.Recipient,
+		HashLock:  lock.HashLock,
+		Timeout:   lock.Timeout,
+		Asset:     lock.Asset,
+		Amount:    lock.Amount,
+		Status:    HTLCOpen,
+	})
+}
+
+func (bc *Blockchain) handleHTLCClaim(b *Block, claim HTLCClaimTx) error {
+	htlc, err := bc.getHTLC(claim.HTLC)
+	if err != nil {
+		return err
+	}
+	if htlc.Status != HTLCOpen {
+		return ErrHTLCSettled
+	}
+	if b.Height > htlc.Timeout {
+		return fmt.Errorf("htlc (%s) timed out at height (%d)", htlc.ID, htlc.Timeout)
+	}
+	if types.Hash(sha256.Sum256(claim.Preimage)) != htlc.HashLock {
+		return fmt.Errorf("preimage does not match the hash lock of htlc (%s)", htlc.ID)
+	}
+
+	if err := bc.accountState.Transfer(htlcEscrow, htlc.Recipient, htlc.Asset, htlc.Amount); err != nil {
+		return err
+	}
+
+	htlc.Status = HTLCClaimed
+	htlc.Preimage = claim.Preimage
+
+	return bc.contractState.putGob(htlcKey(htlc.ID), htlc)
+}
+
+func (bc *Blockchain) handleHTLCRefund(b *Block, refund HTLCRefundTx) error {
+	htlc, err := bc.getHTLC(refund.HTLC)
+	if err != nil {
+		return err
+	}
+	if htlc.Status != HTLCOpen {
+		return ErrHTLCSettled
+	}
+	if b.Height <= htlc.Timeout {
+		return fmt.Errorf("htlc (%s) can't be refunded before height (%d)", htlc.ID, htlc.Timeout+1)
+	}
+
+	if err := bc.accountState.Transfer(htlcEscrow, htlc.Sender, htlc.Asset, htlc.Amount); err != nil {
+		return err
+	}
+
+	htlc.Status = HTLCRefunded
+
+	return bc.contractState.putGob(htlcKey(htlc.ID), htlc)
+}
+
+// GetHTLC returns the HTLC with the given ID.
+func (bc *Blockchain) GetHTLC(id types.Hash) (*HTLC, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.getHTLC(id)
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"crypto/sha256"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"testing"
+)
+
+func TestHTLCClaim(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+	sender := crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	preimage := []byte("secret")
+	hashLock := types.Hash(sha256.Sum256(preimage))
+
+	lock := &Transaction{TxInner: HTLCLockTx{
+		Recipient: recipient.PublicKey().Address(),
+		HashLock:  hashLock,
+		Timeout:   5,
+		Amount:    60,
+	}}
+	assert.Nil(t, lock.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{lock})))
+	id := lock.Hash(TxHasher{})
+
+	// the sender can't take it back before the timeout
+	refund := &Transaction{TxInner: HTLCRefundTx{HTLC: id}}
+	assert.Nil(t, refund.Sign(sender))
+	b := nextBlock(t, bc, validator, []*Transaction{refund})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	claim := &Transaction{TxInner: HTLCClaimTx{HTLC: id, Preimage: preimage}}
+	assert.Nil(t, claim.Sign(recipient))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{claim})))
+
+	htlc, err := bc.GetHTLC(id)
+	assert.Nil(t, err)
+	assert.Equal(t, HTLCClaimed, htlc.Status)
+	assert.Equal(t, preimage, htlc.Preimage)
+
+	balance, err := bc.accountState.GetBalance(recipient.PublicKey().Address(), NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(60), balance)
+}
+
+func TestHTLCRefundAfterTimeout(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+	sender := crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	preimage := []byte("secret")
+	hashLock := types.Hash(sha256.Sum256(preimage))
+
+	lock := &Transaction{TxInner: HTLCLockTx{
+		Recipient: crypto.GeneratePrivateKey().PublicKey().Address(),
+		HashLock:  hashLock,
+		Timeout:   2,
+		Amount:    60,
+	}}
+	assert.Nil(t, lock.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{lock})))
+	id := lock.Hash(TxHasher{})
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, nil)))
+
+	refund := &Transaction{TxInner: HTLCRefundTx{HTLC: id}}
+	assert.Nil(t, refund.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{refund})))
+
+	// too late to claim
+	claim := &Transaction{TxInner: HTLCClaimTx{HTLC: id, Preimage: preimage}}
+	assert.Nil(t, claim.Sign(crypto.GeneratePrivateKey()))
+	b := nextBlock(t, bc, validator, []*Transaction{claim})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	htlc, err := bc.GetHTLC(id)
+	assert.Nil(t, err)
+	assert.Equal(t, HTLCRefunded, htlc.Status)
+
+	balance, err := bc.accountState.GetBalance(sender.PublicKey().Address(), NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+}
+
+func TestHTLCSameHashLock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+	sender, attacker := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+	bc.accountState.Mint(attacker.PublicKey().Address(), NativeAsset, 100)
+
+	preimage := []byte("secret")
+	hashLock := types.Hash(sha256.Sum256(preimage))
+
+	// a dust lock under the same hash lock doesn't block the swap
+	dust := &Transaction{TxInner: HTLCLockTx{
+		Recipient: attacker.PublicKey().Address(),
+		HashLock:  hashLock,
+		Timeout:   5,
+		Amount:    1,
+	}}
+	assert.Nil(t, dust.Sign(attacker))
+	lock := &Transaction{TxInner: HTLCLockTx{
+		Recipient: recipient.PublicKey().Address(),
+		HashLock:  hashLock,
+		Timeout:   5,
+		Amount:    60,
+	}}
+	assert.Nil(t, lock.Sign(sender))
+	b := nextBlock(t, bc, validator, []*Transaction{dust, lock})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 2, appliedTxs(t, bc, b))
+
+	// the preimage has to match the hash lock
+	id := lock.Hash(TxHasher{})
+	claim := &Transaction{TxInner: HTLCClaimTx{HTLC: id, Preimage: []byte("guess")}}
+	assert.Nil(t, claim.Sign(recipient))
+	b = nextBlock(t, bc, validator, []*Transaction{claim})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	claim = &Transaction{TxInner: HTLCClaimTx{HTLC: id, Preimage: preimage}}
+	assert.Nil(t, claim.Sign(recipient))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{claim})))
+	assertBalance(t, bc, recipient.PublicKey().Address(), 60)
+
+	htlc, err := bc.GetHTLC(dust.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, HTLCOpen, htlc.Status)
+}
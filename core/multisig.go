@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sharkchain/crypto"
+	"sharkchain/types"
+)
+
+var ErrNotMultisig = errors.New("tx is not sent by a multisig account")
+
+// MultisigAuth authorizes a tx sent by a multisig account. Keys must be the
+// current signer set of Account, which initially is the set Account was
+// derived from.
+type MultisigAuth struct {
+	Account    types.Address
+	Keys       crypto.MultisigKey
+	Signatures []crypto.KeySignature
+}
+
+// MultisigUpdateTx replaces the signer set of the multisig account sending
+// it. It is used to add and remove signers or to change the threshold.
+type MultisigUpdateTx struct {
+	Keys crypto.MultisigKey
+}
+
+// NewMultisigTransaction returns a tx to be sent by the given multisig account
+// which still needs to be signed by enough keys with SignMultisig.
+func NewMultisigTransaction(data []byte, account types.Address, keys crypto.MultisigKey) *Transaction {
+	return &Transaction{
+		Data: data,
+		Multisig: &MultisigAuth{
+			Account: account,
+			Keys:    keys,
+		},
+	}
+}
+
+// SignMultisig adds the signature of one of the signers of the multisig account.
+func (tx *Transaction) SignMultisig(privKey crypto.PrivateKey) error {
+	if tx.Multisig == nil {
+		return ErrNotMultisig
+	}
+
+	index := -1
+	pubKey := privKey.PublicKey()
+	for i, key := range tx.Multisig.Keys.Keys {
+		if string(key) == string(pubKey) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("key %x is not a signer of multisig account (%s)", []byte(pubKey), tx.Multisig.Account)
+	}
+
+	tx.hash = types.Hash{}
+	hash := tx.Hash(TxHasher{})
+	sig, err := privKey.Sign(hash.ToSlice())
+	if err != nil {
+		return err
+	}
+
+	tx.Multisig.Signatures = append(tx.Multisig.Signatures, crypto.KeySignature{
+		Index:     uint8(index),
+		Signature: sig,
+	})
+
+	return nil
+}
+
+func (m *MultisigAuth) verify(tx *Transaction) error {
+	hash := TxHasher{}.Hash(tx)
+	if err := m.Keys.Verify(m.Signatures, hash.ToSlice()); err != nil {
+		return fmt.Errorf("invalid multisig transaction: %w", err)
+	}
+
+	return nil
+}
+
+func multisigKey(address types.Address) []byte {
+	return append([]byte("multisig/"), address.ToSlice()...)
+}
+
+// checkMultisigSigners makes sure the keys that signed the tx are the
+// current signer set of the sending account.
+func (bc *Blockchain) checkMultisigSigners(auth *MultisigAuth) error {
+	current := crypto.MultisigKey{}
+	found, err := bc.contractState.getGob(multisigKey(auth.Account), &current)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		if auth.Keys.Address() != auth.Account {
+			return fmt.Errorf("keys don't derive multisig account (%s)", auth.Account)
+		}
+		return nil
+	}
+
+	if !auth.Keys.Equal(current) {
+		return fmt.Errorf("keys are not the current signers of multisig account (%s)", auth.Account)
+	}
+
+	return nil
+}
+
+func (bc *Blockchain) handleMultisigUpdate(tx *Transaction, update MultisigUpdateTx) error {
+	if tx.Multisig == nil {
+		return ErrNotMultisig
+	}
+
+	if err := update.Keys.Validate(); err != nil {
+		return err
+	}
+
+	return bc.contractState.putGob(multisigKey(tx.Multisig.Account), update.Keys)
+}
+
+// GetMultisigSigners returns the current signer set of a multisig account.
+func (bc *Blockchain) GetMultisigSigners(account types.Address) (crypto.MultisigKey, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	keys := crypto.MultisigKey{}
+	found, err := bc.contractState.getGob(multisigKey(account), &keys)
+	if err != nil {
+		return keys, err
+	}
+	if !found {
+		return keys, fmt.Errorf("multisig account (%s) never changed its signers", account)
+	}
+
+	return keys, nil
+}
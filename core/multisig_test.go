@@ -0,0 +1,94 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func randomMultisig(threshold uint8, n int) ([]crypto.PrivateKey, crypto.MultisigKey) {
+	privKeys := make([]crypto.PrivateKey, n)
+	keys := crypto.MultisigKey{Threshold: threshold}
+	for i := range privKeys {
+		privKeys[i] = crypto.GeneratePrivateKey()
+		keys.Keys = append(keys.Keys, privKeys[i].PublicKey())
+	}
+
+	return privKeys, keys
+}
+
+func TestVerifyMultisigTransaction(t *testing.T) {
+	privKeys, keys := randomMultisig(2, 3)
+	tx := NewMultisigTransaction([]byte("foo"), keys.Address(), keys)
+
+	assert.Nil(t, tx.SignMultisig(privKeys[0]))
+	assert.NotNil(t, tx.Verify())
+
+	// the same key twice doesn't count
+	assert.Nil(t, tx.SignMultisig(privKeys[0]))
+	assert.NotNil(t, tx.Verify())
+
+	assert.Nil(t, tx.SignMultisig(privKeys[2]))
+	assert.Nil(t, tx.Verify())
+	assert.Equal(t, keys.Address(), tx.Sender())
+
+	assert.NotNil(t, tx.SignMultisig(crypto.GeneratePrivateKey()))
+}
+
+func TestMultisigUpdateToInvalidKey(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKeys, keys := randomMultisig(1, 2)
+	account := keys.Address()
+
+	invalid := crypto.MultisigKey{Threshold: 1, Keys: []crypto.PublicKey{keys.Keys[0], make(crypto.PublicKey, 33)}}
+	assert.NotNil(t, invalid.Validate())
+
+	tx := NewMultisigTransaction(nil, account, keys)
+	tx.TxInner = MultisigUpdateTx{Keys: invalid}
+	assert.Nil(t, tx.SignMultisig(privKeys[0]))
+	b := nextBlock(t, bc, crypto.GeneratePrivateKey(), []*Transaction{tx})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+}
+
+func TestMultisigUpdateSigners(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	validator := crypto.GeneratePrivateKey()
+	privKeys, keys := randomMultisig(2, 3)
+	account := keys.Address()
+	bc.accountState.Mint(account, NativeAsset, 100)
+
+	// drop the last signer and require both remaining ones
+	newKeys := crypto.MultisigKey{Threshold: 2, Keys: keys.Keys[:2]}
+	tx := NewMultisigTransaction(nil, account, keys)
+	tx.TxInner = MultisigUpdateTx{Keys: newKeys}
+	assert.Nil(t, tx.SignMultisig(privKeys[1]))
+	assert.Nil(t, tx.SignMultisig(privKeys[2]))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+
+	signers, err := bc.GetMultisigSigners(account)
+	assert.Nil(t, err)
+	assert.True(t, newKeys.Equal(signers))
+
+	// the old signer set is not accepted anymore
+	to := crypto.GeneratePrivateKey().PublicKey()
+	tx = NewMultisigTransaction(nil, account, keys)
+	tx.To = to
+	tx.Value = 10
+	assert.Nil(t, tx.SignMultisig(privKeys[1]))
+	assert.Nil(t, tx.SignMultisig(privKeys[2]))
+	b := nextBlock(t, bc, validator, []*Transaction{tx})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	tx = NewMultisigTransaction(nil, account, newKeys)
+	tx.To = to
+	tx.Value = 10
+	assert.Nil(t, tx.SignMultisig(privKeys[0]))
+	assert.Nil(t, tx.SignMultisig(privKeys[1]))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+
+	balance, err := bc.accountState.GetBalance(to.Address(), NativeAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), balance)
+}
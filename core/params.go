@@ -0,0 +1,271 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"time"
+)
+
+// Params are the chain parameters every node has to agree on. They live in
+// the chain state and are changed through governance.
+type Params struct {
+	BlockTime time.Duration
+	// MaxBlockSize is the maximum encoded size in bytes of the transactions
+	// of a block, zero means no limit.
+	MaxBlockSize uint32
+	// MinFee is the minimum fee a tx has to pay to be included.
+	MinFee uint64
+	Reward RewardSchedule
+	// Consensus selects the engine producing and accepting blocks.
+	Consensus ConsensusType
+	// Validators are the keys allowed to sign blocks, taking turns in this
+	// order under ConsensusPoA. When empty any key is.
+	Validators []crypto.PublicKey
+	// Powers are the voting powers of the Validators, in the same order.
+	// When empty every validator has a power of one.
+	Powers []uint64
+	// PoW configures mining under ConsensusPoW.
+	PoW *PoWParams
+	// Staking makes the validators follow the bonded stake, nil when the
+	// set only changes through governance.
+	Staking *StakingParams
+	// CheckpointInterval is the distance between the finalized blocks the
+	// chain records as checkpoints, zero records none.
+	CheckpointInterval uint32
+	// SnapshotInterval is the distance between the blocks the state is
+	// snapshotted after, zero takes none.
+	SnapshotInterval uint32
+	// MinChallengePeriod is the fewest blocks a payment channel leaves its
+	// parties to challenge a unilateral close.
+	MinChallengePeriod uint32
+}
+
+var DefaultParams = Params{
+	BlockTime:          5 * time.Second,
+	Reward:             DefaultRewardSchedule,
+	CheckpointInterval: 100,
+	SnapshotInterval:   1000,
+	MinChallengePeriod: 60,
+}
+
+func (p Params) Validate() error {
+	if p.BlockTime <= 0 {
+		return fmt.Errorf("block time must be positive, got %s", p.BlockTime)
+	}
+
+	seen := make(map[string]bool, len(p.Validators))
+	for _, v := range p.Validators {
+		if seen[string(v)] {
+			return fmt.Errorf("validator %x listed twice", []byte(v))
+		}
+		seen[string(v)] = true
+	}
+	if len(p.Powers) > 0 && len(p.Powers) != len(p.Validators) {
+		return fmt.Errorf("%d powers given for %d validators", len(p.Powers), len(p.Validators))
+	}
+
+	if p.Staking != nil {
+		if err := p.Staking.Validate(); err != nil {
+			return err
+		}
+	}
+
+	switch p.Consensus {
+	case ConsensusSigner:
+	case ConsensusPoA, ConsensusBFT, ConsensusVRF:
+		if len(p.Validators) == 0 {
+			return fmt.Errorf("%s consensus needs at least one validator", p.Consensus)
+		}
+	case ConsensusPoW:
+		if p.PoW == nil {
+			return errors.New("proof-of-work needs its parameters")
+		}
+		return p.PoW.Validate()
+	default:
+		return fmt.Errorf("unknown consensus (%d)", p.Consensus)
+	}
+
+	return nil
+}
+
+// IsValidator reports whether the given address may sign blocks.
+func (p Params) IsValidator(address types.Address) bool {
+	if len(p.Validators) == 0 {
+		return true
+	}
+
+	return p.validatorIndex(address) >= 0
+}
+
+// Power returns the voting power of the given address, zero when it is not a
+// validator.
+func (p Params) Power(address types.Address) uint64 {
+	i := p.validatorIndex(address)
+	if i < 0 {
+		return 0
+	}
+	if len(p.Powers) == 0 {
+		return 1
+	}
+
+	return p.Powers[i]
+}
+
+// TotalPower returns the voting power of all validators.
+func (p Params) TotalPower() uint64 {
+	if len(p.Powers) == 0 {
+		return uint64(len(p.Validators))
+	}
+
+	total := uint64(0)
+	for _, power := range p.Powers {
+		total += power
+	}
+
+	return total
+}
+
+// Proposer returns the validator whose turn it is to sign the block at the
+// given height, nil when any key may sign it.
+func (p Params) Proposer(height uint32) crypto.PublicKey {
+	return p.ProposerAt(height, 0)
+}
+
+// ProposerAt returns the validator proposing the block at the given height in
+// the given BFT round, every round moves on to the next validator.
+func (p Params) ProposerAt(height, round uint32) crypto.PublicKey {
+	if len(p.Validators) == 0 {
+		return nil
+	}
+
+	return p.Validators[(height+round)%uint32(len(p.Validators))]
+}
+
+// ProposerAfter returns the validator proposing the block of the header
+// under PoA, given the header of its parent. A block late by two block times
+// or more passes the turn on to the next validator, and so on for every
+// further block time, so that an offline validator does not halt the chain.
+// The genesis timestamp is set by hand, the first block is never late.
+func (p Params) ProposerAfter(parent, h *Header) crypto.PublicKey {
+	round := uint32(0)
+	elapsed := time.Duration(h.Timestamp - parent.Timestamp)
+	if parent.Height > 0 && len(p.Validators) > 0 && elapsed >= 2*p.BlockTime {
+		round = uint32((elapsed/p.BlockTime - 1) % time.Duration(len(p.Validators)))
+	}
+
+	return p.ProposerAt(h.Height, round)
+}
+
+// changeValidators returns a copy of the parameters with the given validators
+// added and removed.
+func (p Params) changeValidators(add, remove []crypto.PublicKey) (Params, error) {
+	if len(add) == 0 && len(remove) == 0 {
+		return p, errors.New("no validator to add or remove")
+	}
+	if p.Staking != nil {
+		return p, errors.New("validators are chosen by stake")
+	}
+
+	validators := make([]crypto.PublicKey, 0, len(p.Validators)+len(add))
+	for _, v := range p.Validators {
+		removed := false
+		for _, r := range remove {
+			removed = removed || r.Address() == v.Address()
+		}
+		if !removed {
+			validators = append(validators, v)
+		}
+	}
+	if len(validators)+len(remove) != len(p.Validators) {
+		return p, errors.New("can only remove validators of the current set")
+	}
+
+	for _, a := range add {
+		if p.validatorIndex(a.Address()) >= 0 {
+			return p, fmt.Errorf("(%s) is already a validator", a.Address())
+		}
+		validators = append(validators, a)
+	}
+	if len(validators) == 0 {
+		return p, errors.New("cannot remove every validator")
+	}
+
+	p.Validators = validators
+
+	return p, p.Validate()
+}
+
+func (p Params) validatorIndex(address types.Address) int {
+	for i, v := range p.Validators {
+		if v.Address() == address {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ParamsTx sets the initial parameters, it is only valid in the genesis block.
+type ParamsTx struct {
+	Params Params
+}
+
+var paramsKey = []byte("params")
+
+func (bc *Blockchain) handleParams(b *Block, tx ParamsTx) error {
+	if b.Height != 0 {
+		return errors.New("params can only be set by the genesis block, use a proposal instead")
+	}
+
+	if err := tx.Params.Validate(); err != nil {
+		return err
+	}
+
+	return bc.contractState.putGob(paramsKey, tx.Params)
+}
+
+// paramsAt returns the parameters in effect after the block at the given
+// height. The add lock must be held.
+func (bc *Blockchain) paramsAt(height uint32) (Params, error) {
+	diffs, err := bc.diffsSince(height)
+	if err != nil {
+		return Params{}, err
+	}
+
+	bc.stateLock.RLock()
+	value, err := bc.contractState.getBefore(paramsKey, diffs)
+	bc.stateLock.RUnlock()
+	if err != nil {
+		// the genesis did not set any
+		return DefaultParams, nil
+	}
+
+	params := Params{}
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&params); err != nil {
+		return Params{}, err
+	}
+
+	return params, nil
+}
+
+func (bc *Blockchain) params() Params {
+	params := Params{}
+	found, err := bc.contractState.getGob(paramsKey, &params)
+	if err != nil || !found {
+		return DefaultParams
+	}
+
+	return params
+}
+
+// Params returns the parameters in effect for the next block.
+func (bc *Blockchain) Params() Params {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.params()
+}
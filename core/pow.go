@@ -0,0 +1,143 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"slices"
+)
+
+// PoWParams configure proof-of-work mining. Blocks are mined at the block
+// time of the chain parameters.
+type PoWParams struct {
+	// InitialDifficulty is the difficulty of the first mined block, the
+	// expected number of hashes needed to find its nonce.
+	InitialDifficulty uint64
+	// AdjustmentInterval is the number of blocks after which the difficulty
+	// is adjusted toward the block time.
+	AdjustmentInterval uint32
+}
+
+func (p PoWParams) Validate() error {
+	if p.InitialDifficulty == 0 {
+		return errors.New("initial difficulty must be positive")
+	}
+	if p.AdjustmentInterval == 0 {
+		return errors.New("difficulty adjustment interval must be positive")
+	}
+
+	return nil
+}
+
+// maxAdjustment bounds the factor by which the difficulty changes at once.
+const maxAdjustment = 4
+
+// maxHash is the highest possible block hash, 2^256-1.
+var maxHash = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// difficultyTarget returns the highest hash meeting the given difficulty.
+func difficultyTarget(difficulty uint64) *big.Int {
+	return new(big.Int).Div(maxHash, new(big.Int).SetUint64(difficulty))
+}
+
+func meetsTarget(h *Header, target *big.Int) bool {
+	hash := BlockHasher{}.Hash(h)
+	return new(big.Int).SetBytes(hash.ToSlice()).Cmp(target) <= 0
+}
+
+// MeetsDifficulty reports whether the hash of the header is low enough for
+// the difficulty it claims.
+func MeetsDifficulty(h *Header) bool {
+	if h.Difficulty == 0 {
+		return false
+	}
+
+	return meetsTarget(h, difficultyTarget(h.Difficulty))
+}
+
+// Mine searches for a nonce making the header meet its difficulty. It gives
+// up and returns false as soon as stop returns true, e.g. because another
+// block for the same height arrived.
+func Mine(h *Header, stop func() bool) bool {
+	if h.Difficulty == 0 {
+		return false
+	}
+	target := difficultyTarget(h.Difficulty)
+
+	for {
+		if stop() {
+			return false
+		}
+
+		for i := 0; i < 1024; i++ {
+			if meetsTarget(h, target) {
+				return true
+			}
+			h.Nonce++
+		}
+	}
+}
+
+// medianTimeSpan is the number of blocks whose median timestamp a mined
+// block has to come after. Without it a miner could backdate the block
+// starting a difficulty window and cut the difficulty of the next one.
+const medianTimeSpan = 11
+
+// medianTimePast returns the median timestamp of the last medianTimeSpan
+// headers, zero when there are none. The genesis timestamp is set by hand,
+// it does not count.
+func medianTimePast(headers []*Header) int64 {
+	timestamps := []int64{}
+	for i := len(headers) - 1; i >= 0 && len(timestamps) < medianTimeSpan; i-- {
+		if headers[i].Height == 0 {
+			break
+		}
+		timestamps = append(timestamps, headers[i].Timestamp)
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+	slices.Sort(timestamps)
+
+	return timestamps[len(timestamps)/2]
+}
+
+// nextDifficulty returns the difficulty of the block following the last of
+// the given headers.
+func nextDifficulty(headers []*Header, pow PoWParams, params Params) uint64 {
+	prev := headers[len(headers)-1]
+	height := prev.Height + 1
+	if prev.Difficulty == 0 {
+		return pow.InitialDifficulty
+	}
+	if height%pow.AdjustmentInterval != 0 {
+		return prev.Difficulty
+	}
+
+	// the genesis timestamp is set by hand, the window starts after it
+	start := uint32(1)
+	if height-1 > pow.AdjustmentInterval {
+		start = height - 1 - pow.AdjustmentInterval
+	}
+	// a chain started from a snapshot holds no headers before it
+	start = max(start, headers[0].Height)
+	intervals := height - 1 - start
+	if intervals == 0 {
+		return prev.Difficulty
+	}
+
+	expected := int64(intervals) * int64(params.BlockTime)
+	actual := prev.Timestamp - headers[start-headers[0].Height].Timestamp
+	actual = max(actual, expected/maxAdjustment, 1)
+	actual = min(actual, expected*maxAdjustment)
+
+	difficulty := new(big.Int).SetUint64(prev.Difficulty)
+	difficulty.Mul(difficulty, big.NewInt(expected))
+	difficulty.Div(difficulty, big.NewInt(actual))
+
+	if !difficulty.IsUint64() {
+		return math.MaxUint64
+	}
+
+	return max(difficulty.Uint64(), 1)
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"math/big"
+	"sharkchain/crypto"
+	"testing"
+	"time"
+)
+
+func newMinedBlockchain(t *testing.T) *Blockchain {
+	params := DefaultParams
+	params.Consensus = ConsensusPoW
+	params.PoW = &PoWParams{InitialDifficulty: 16, AdjustmentInterval: 2}
+
+	genesis, err := GenesisConfig{Params: &params}.Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	return bc
+}
+
+// nextMinedBlock mines a block the given time after the last one.
+func nextMinedBlock(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey, after time.Duration) *Block {
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(prevHeader, nil)
+	assert.Nil(t, err)
+	b.Timestamp = prevHeader.Timestamp + int64(after)
+	b.Difficulty = bc.NextDifficulty()
+	assert.True(t, Mine(b.Header, func() bool { return false }))
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+func TestMine(t *testing.T) {
+	h := &Header{Height: 1, Difficulty: 256}
+	assert.True(t, Mine(h, func() bool { return false }))
+	assert.True(t, MeetsDifficulty(h))
+
+	h = &Header{Height: 1, Difficulty: math.MaxUint64}
+	assert.False(t, Mine(h, func() bool { return true }))
+}
+
+func TestAddMinedBlocks(t *testing.T) {
+	bc := newMinedBlockchain(t)
+	miner := crypto.GeneratePrivateKey()
+
+	// signing is not enough
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, miner, nil)))
+
+	b := nextMinedBlock(t, bc, miner, time.Second)
+	assert.Equal(t, uint64(16), b.Difficulty)
+	assert.Nil(t, bc.AddBlock(b))
+
+	// the window starts at block 1, there is nothing to adjust by yet
+	for i := 0; i < 2; i++ {
+		b = nextMinedBlock(t, bc, miner, time.Second)
+		assert.Equal(t, uint64(16), b.Difficulty)
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	// a second instead of five, the difficulty rises by the maximum factor
+	b = nextMinedBlock(t, bc, miner, time.Second)
+	assert.Equal(t, uint64(64), b.Difficulty)
+	assert.Nil(t, bc.AddBlock(b))
+
+	// no adjustment in between
+	assert.Equal(t, uint64(64), bc.NextDifficulty())
+	assert.Equal(t, big.NewInt(1+16+16+16+64), bc.TotalWork())
+}
+
+func TestAddMinedBlockWithWrongDifficulty(t *testing.T) {
+	bc := newMinedBlockchain(t)
+	miner := crypto.GeneratePrivateKey()
+
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	b, err := NewBlockFromPrevHeader(prevHeader, nil)
+	assert.Nil(t, err)
+	b.Difficulty = 1
+	assert.True(t, Mine(b.Header, func() bool { return false }))
+	assert.Nil(t, b.Sign(miner))
+
+	assert.NotNil(t, bc.AddBlock(b))
+}
+
+func TestNextDifficultySlowBlocks(t *testing.T) {
+	pow := PoWParams{InitialDifficulty: 100, AdjustmentInterval: 2}
+	params := DefaultParams
+	headers := []*Header{
+		{Height: 0},
+		{Height: 1, Difficulty: 100, Timestamp: int64(10 * time.Second)},
+		{Height: 2, Difficulty: 100, Timestamp: int64(20 * time.Second)},
+		{Height: 3, Difficulty: 100, Timestamp: int64(30 * time.Second)},
+	}
+
+	// two blocks of ten seconds instead of five
+	assert.Equal(t, uint64(50), nextDifficulty(headers, pow, params))
+}
+
+func TestNextDifficultySkipsGenesisTimestamp(t *testing.T) {
+	pow := PoWParams{InitialDifficulty: 100, AdjustmentInterval: 4}
+	params := DefaultParams
+	now := time.Now().UnixNano()
+	headers := []*Header{
+		{Height: 0},
+		{Height: 1, Difficulty: 100, Timestamp: now},
+		{Height: 2, Difficulty: 100, Timestamp: now + int64(5*time.Second)},
+		{Height: 3, Difficulty: 100, Timestamp: now + int64(10*time.Second)},
+	}
+
+	// on time since block 1, however long ago the genesis is
+	assert.Equal(t, uint64(100), nextDifficulty(headers, pow, params))
+}
+
+func TestAddMinedBlockFromTheFuture(t *testing.T) {
+	bc := newMinedBlockchain(t)
+	miner := crypto.GeneratePrivateKey()
+	assert.Nil(t, bc.AddBlock(nextMinedBlock(t, bc, miner, time.Second)))
+
+	// warping the timestamp would cut the difficulty of the next window
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	ahead := time.Now().Add(time.Hour).UnixNano() - prevHeader.Timestamp
+	b := nextMinedBlock(t, bc, miner, time.Duration(ahead))
+	assert.ErrorIs(t, bc.AddBlock(b), ErrFutureBlock)
+}
+
+func TestAddMinedBlockBeforeMedianTime(t *testing.T) {
+	bc := newMinedBlockchain(t)
+	miner := crypto.GeneratePrivateKey()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, bc.AddBlock(nextMinedBlock(t, bc, miner, time.Second)))
+	}
+
+	// the median is the timestamp of block 2, a second before the last one
+	median, err := bc.GetHeader(2)
+	assert.Nil(t, err)
+	assert.Equal(t, median.Timestamp, bc.MedianTimePast())
+	assert.NotNil(t, bc.AddBlock(nextMinedBlock(t, bc, miner, -time.Second)))
+	assert.Nil(t, bc.AddBlock(nextMinedBlock(t, bc, miner, -time.Second+1)))
+}
+
+func TestMedianTimePast(t *testing.T) {
+	assert.Equal(t, int64(0), medianTimePast([]*Header{{Height: 0, Timestamp: 5}}))
+
+	headers := []*Header{{Height: 0, Timestamp: 100}}
+	for i := 1; i <= 2*medianTimeSpan; i++ {
+		headers = append(headers, &Header{Height: uint32(i), Timestamp: int64(i)})
+	}
+	// the last medianTimeSpan blocks, whatever their order
+	headers[len(headers)-1].Timestamp = 1
+	assert.Equal(t, int64(2*medianTimeSpan-medianTimeSpan/2-1), medianTimePast(headers))
+}
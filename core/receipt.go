@@ -0,0 +1,32 @@
+package core
+
+import "sharkchain/types"
+
+// OpStatus is the outcome of a single operation of a batch tx.
+type OpStatus string
+
+const (
+	OpApplied  OpStatus = "applied"
+	OpReverted OpStatus = "reverted"
+	OpFailed   OpStatus = "failed"
+	OpSkipped  OpStatus = "skipped"
+)
+
+type OpResult struct {
+	Status OpStatus
+	Err    string
+}
+
+// Receipt records the outcome of a tx included in a block.
+type Receipt struct {
+	TxHash types.Hash
+	Height uint32
+	// Err is empty when the tx applied successfully.
+	Err string
+	// Ops holds the result of each operation of a batch tx.
+	Ops []OpResult
+}
+
+func (r *Receipt) Success() bool {
+	return len(r.Err) == 0
+}
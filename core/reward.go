@@ -0,0 +1,35 @@
+package core
+
+// RewardSchedule determines how many native coins are minted to the
+// validator of each block.
+type RewardSchedule struct {
+	// InitialReward is the reward paid for block 1.
+	InitialReward uint64
+	// HalvingInterval is the number of blocks after which the reward is
+	// halved. Zero means the reward never changes.
+	HalvingInterval uint32
+}
+
+var DefaultRewardSchedule = RewardSchedule{
+	InitialReward:   50,
+	HalvingInterval: 210_000,
+}
+
+// RewardAt returns the block reward for the given height. The genesis block
+// is not rewarded.
+func (r RewardSchedule) RewardAt(height uint32) uint64 {
+	if height == 0 {
+		return 0
+	}
+
+	if r.HalvingInterval == 0 {
+		return r.InitialReward
+	}
+
+	halvings := (height - 1) / r.HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+
+	return r.InitialReward >> halvings
+}
@@ -0,0 +1,14 @@
+package core
+
+import "sharkchain/crypto"
+
+// BlockSigner is a crypto.Signer that is handed blocks and consensus
+// messages rather than their hashes, so it can see what it signs and refuse
+// to sign conflicting ones. Block.Sign, Vote.Sign and BlockProposal.Sign
+// prefer these methods over Sign.
+type BlockSigner interface {
+	crypto.Signer
+	SignHeader(h *Header) (*crypto.Signature, error)
+	SignVote(v *Vote) (*crypto.Signature, error)
+	SignProposal(p *BlockProposal) (*crypto.Signature, error)
+}
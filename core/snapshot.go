@@ -0,0 +1,359 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+	"sharkchain/types"
+	"slices"
+)
+
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// keptSnapshots is the number of the latest snapshots the chain holds.
+const keptSnapshots = 2
+
+// Snapshot is the state after a block. A node can start from it instead of
+// executing every block up to it.
+type Snapshot struct {
+	Height    uint32
+	BlockHash types.Hash
+	// StateRoot commits to the accounts, the supply, the contract state and
+	// the txs of the chain, see Root.
+	StateRoot types.Hash
+	// Block is the block the snapshot is taken after, Headers are the ones
+	// before it the consensus needs to validate the blocks following it.
+	Block   *Block
+	Headers []*Header
+	// TotalWork is the work of the chain ending at the block.
+	TotalWork *big.Int
+
+	Accounts []SnapshotAccount
+	Supply   []AssetAmount
+	Storage  []StorageEntry
+	// Txs are the hashes of every tx of the chain, the replay protection
+	// keeps refusing them.
+	Txs []types.Hash
+}
+
+// SnapshotAccount is an account with its balances ordered by asset.
+type SnapshotAccount struct {
+	Address  types.Address
+	Balances []AssetAmount
+	Vesting  []VestingSchedule
+}
+
+// StorageEntry is a key of the contract state and its value.
+type StorageEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// Root returns the hash of the state held by the snapshot. Everything is
+// ordered, so every node computes the same root for the same state.
+func (s *Snapshot) Root() (types.Hash, error) {
+	buf := &bytes.Buffer{}
+	state := struct {
+		Accounts []SnapshotAccount
+		Supply   []AssetAmount
+		Storage  []StorageEntry
+		Txs      []types.Hash
+	}{s.Accounts, s.Supply, s.Storage, s.Txs}
+	if err := gob.NewEncoder(buf).Encode(state); err != nil {
+		return types.Hash{}, err
+	}
+
+	return sha256.Sum256(buf.Bytes()), nil
+}
+
+// Verify checks that the snapshot holds the state its root commits to and
+// that its headers lead to its block. Whether the block and the root are
+// the ones of the chain is up to a trusted checkpoint.
+func (s *Snapshot) Verify() error {
+	if s.Block == nil || s.Block.Header == nil {
+		return fmt.Errorf("%w: no block", ErrInvalidSnapshot)
+	}
+	if s.Block.Height != s.Height || s.Block.Hash(BlockHasher{}) != s.BlockHash {
+		return fmt.Errorf("%w: block (%d) is not the one of the snapshot (%s)", ErrInvalidSnapshot, s.Block.Height, s.BlockHash)
+	}
+	dataHash, err := CalculateDataHash(s.Block.Transactions)
+	if err != nil {
+		return err
+	}
+	if dataHash != s.Block.DataHash {
+		return fmt.Errorf("%w: transactions of block (%d) don't match its data hash", ErrInvalidSnapshot, s.Height)
+	}
+
+	for i, h := range s.Headers {
+		next := s.Block.Header
+		if i+1 < len(s.Headers) {
+			next = s.Headers[i+1]
+		}
+		if h.Height+1 != next.Height || (BlockHasher{}).Hash(h) != next.PrevBlockHash {
+			return fmt.Errorf("%w: header (%d) is not the parent of (%d)", ErrInvalidSnapshot, h.Height, next.Height)
+		}
+	}
+
+	if s.TotalWork == nil {
+		return fmt.Errorf("%w: no total work", ErrInvalidSnapshot)
+	}
+
+	root, err := s.Root()
+	if err != nil {
+		return err
+	}
+	if root != s.StateRoot {
+		return fmt.Errorf("%w: state hashes to (%s) but the root is (%s)", ErrInvalidSnapshot, root, s.StateRoot)
+	}
+
+	return nil
+}
+
+// Snapshots returns the checkpoints of the snapshots the chain holds,
+// trusting one of them lets a new node start from its snapshot.
+func (bc *Blockchain) Snapshots() []Checkpoint {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	checkpoints := []Checkpoint{}
+	for _, s := range bc.snapshots {
+		checkpoints = append(checkpoints, Checkpoint{Height: s.Height, Hash: s.BlockHash, StateRoot: s.StateRoot})
+	}
+
+	return checkpoints
+}
+
+// GetSnapshot returns the snapshot taken after the block at the given height.
+func (bc *Blockchain) GetSnapshot(height uint32) (*Snapshot, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	for _, s := range bc.snapshots {
+		if s.Height == height {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no snapshot at height (%d)", height)
+}
+
+// RestoreSnapshot makes the chain start from the snapshot, the blocks up to
+// it are never executed. The chain may hold nothing but its genesis, and a
+// trusted checkpoint with the state root has to vouch for the snapshot.
+func (bc *Blockchain) RestoreSnapshot(s *Snapshot) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	if height := bc.Height(); height > 0 {
+		return fmt.Errorf("chain is at height (%d), only a new chain can be restored", height)
+	}
+
+	bc.lock.RLock()
+	c, ok := bc.trusted[s.Height]
+	bc.lock.RUnlock()
+
+	if !ok || c.StateRoot.IsZero() {
+		return fmt.Errorf("no trusted state root at height (%d) of the snapshot", s.Height)
+	}
+	if c.Hash != s.BlockHash || c.StateRoot != s.StateRoot {
+		return fmt.Errorf("%w: snapshot (%d) is of (%s) with root (%s)", ErrCheckpointMismatch, s.Height, s.BlockHash, s.StateRoot)
+	}
+	if err := s.Verify(); err != nil {
+		return err
+	}
+
+	contract := NewState()
+	contract.restore(s.Storage)
+
+	params := DefaultParams
+	if _, err := contract.getGob(paramsKey, &params); err != nil {
+		return err
+	}
+	if n := snapshotHeaders(params, s.Height); len(s.Headers) < n {
+		return fmt.Errorf("%w: (%d) headers before the block but (%d) are needed", ErrInvalidSnapshot, len(s.Headers), n)
+	}
+
+	bc.stateLock.Lock()
+	bc.accountState.restore(s.Accounts, s.Supply)
+	bc.accountState.SetBlock(s.Height, s.Block.Timestamp)
+	bc.contractState.restore(s.Storage)
+	bc.stateLock.Unlock()
+
+	headers := append(slices.Clone(s.Headers), s.Block.Header)
+
+	bc.lock.Lock()
+	bc.base = headers[0].Height
+	bc.headers = headers
+	bc.blocks = make([]*Block, len(headers))
+	bc.blocks[len(headers)-1] = s.Block
+	bc.diffs = make([]*StateDiff, len(headers))
+	bc.totalWork = new(big.Int).Set(s.TotalWork)
+	bc.work = map[types.Hash]*big.Int{s.BlockHash: new(big.Int).Set(s.TotalWork)}
+	bc.blockStore = map[types.Hash]*Block{s.BlockHash: s.Block}
+	bc.sideBlocks = make(map[types.Hash]*Block)
+	bc.txStore = make(map[types.Hash]*Transaction)
+	bc.snapshotTxs = make(map[types.Hash]bool, len(s.Txs))
+	for _, hash := range s.Txs {
+		bc.snapshotTxs[hash] = true
+	}
+	// the txs of the block the snapshot dropped are not part of the chain
+	for _, tx := range s.Block.Transactions {
+		if hash := tx.Hash(TxHasher{}); bc.snapshotTxs[hash] {
+			bc.txStore[hash] = tx
+		}
+	}
+	bc.receiptStore = make(map[types.Hash]*Receipt)
+	bc.finalized = s.Height
+	bc.checkpoints = nil
+	bc.snapshots = []*Snapshot{s}
+	bc.lock.Unlock()
+
+	bc.logger.Log(
+		"msg", "chain restored from snapshot",
+		"hash", s.BlockHash,
+		"height", s.Height,
+		"root", s.StateRoot,
+	)
+
+	return nil
+}
+
+// takeSnapshot takes a snapshot after the block when the snapshot interval
+// is reached. The block has to be the head of the chain, and the add lock
+// held.
+func (bc *Blockchain) takeSnapshot(b *Block) error {
+	bc.stateLock.RLock()
+	params := bc.params()
+	if params.SnapshotInterval == 0 || b.Height == 0 || b.Height%params.SnapshotInterval != 0 {
+		bc.stateLock.RUnlock()
+		return nil
+	}
+	s := &Snapshot{
+		Height:    b.Height,
+		BlockHash: b.Hash(BlockHasher{}),
+		Block:     b,
+		Storage:   bc.contractState.snapshot(),
+	}
+	s.Accounts, s.Supply = bc.accountState.snapshot()
+	bc.stateLock.RUnlock()
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	n := min(snapshotHeaders(params, b.Height), len(bc.headers)-1)
+	s.Headers = slices.Clone(bc.headers[len(bc.headers)-1-n : len(bc.headers)-1])
+	s.TotalWork = new(big.Int).Set(bc.totalWork)
+	s.Txs = make([]types.Hash, 0, len(bc.txStore)+len(bc.snapshotTxs))
+	for hash := range bc.txStore {
+		s.Txs = append(s.Txs, hash)
+	}
+	for hash := range bc.snapshotTxs {
+		s.Txs = append(s.Txs, hash)
+	}
+	slices.SortFunc(s.Txs, func(a, b types.Hash) int { return bytes.Compare(a[:], b[:]) })
+
+	root, err := s.Root()
+	if err != nil {
+		return err
+	}
+	s.StateRoot = root
+
+	bc.snapshots = append(bc.snapshots, s)
+	if len(bc.snapshots) > keptSnapshots {
+		bc.snapshots = slices.Delete(bc.snapshots, 0, len(bc.snapshots)-keptSnapshots)
+	}
+
+	return nil
+}
+
+// snapshotHeaders returns the number of headers before the block at the
+// given height the consensus needs to validate the blocks following it:
+// mining retargets the difficulty over the last adjustment interval.
+func snapshotHeaders(params Params, height uint32) int {
+	if params.PoW == nil {
+		return 0
+	}
+
+	return int(min(params.PoW.AdjustmentInterval, height))
+}
+
+// snapshot returns the accounts ordered by address and the supply ordered
+// by asset.
+func (s *AccountState) snapshot() ([]SnapshotAccount, []AssetAmount) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]SnapshotAccount, 0, len(s.accounts))
+	for address, account := range s.accounts {
+		balances := make([]AssetAmount, 0, len(account.Balances))
+		for asset, amount := range account.Balances {
+			balances = append(balances, AssetAmount{Asset: asset, Amount: amount})
+		}
+		slices.SortFunc(balances, compareAssets)
+
+		accounts = append(accounts, SnapshotAccount{
+			Address:  address,
+			Balances: balances,
+			Vesting:  slices.Clone(account.Vesting),
+		})
+	}
+	slices.SortFunc(accounts, func(a, b SnapshotAccount) int { return bytes.Compare(a.Address[:], b.Address[:]) })
+
+	supply := make([]AssetAmount, 0, len(s.supply))
+	for asset, amount := range s.supply {
+		supply = append(supply, AssetAmount{Asset: asset, Amount: amount})
+	}
+	slices.SortFunc(supply, compareAssets)
+
+	return accounts, supply
+}
+
+// restore replaces the accounts and the supply with the ones of a snapshot.
+func (s *AccountState) restore(accounts []SnapshotAccount, supply []AssetAmount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts = make(map[types.Address]*Account, len(accounts))
+	for _, a := range accounts {
+		account := NewAccount(a.Address)
+		for _, b := range a.Balances {
+			account.Balances[b.Asset] = b.Amount
+		}
+		account.Vesting = slices.Clone(a.Vesting)
+		s.accounts[a.Address] = account
+	}
+
+	s.supply = make(map[AssetID]uint64, len(supply))
+	for _, a := range supply {
+		s.supply[a.Asset] = a.Amount
+	}
+	s.journal = nil
+}
+
+// snapshot returns the entries ordered by key.
+func (s *State) snapshot() []StorageEntry {
+	entries := make([]StorageEntry, 0, len(s.data))
+	for key, value := range s.data {
+		entries = append(entries, StorageEntry{Key: []byte(key), Value: value})
+	}
+	slices.SortFunc(entries, func(a, b StorageEntry) int { return bytes.Compare(a.Key, b.Key) })
+
+	return entries
+}
+
+// restore replaces the entries with the ones of a snapshot.
+func (s *State) restore(entries []StorageEntry) {
+	s.data = make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		s.data[string(e.Key)] = e.Value
+	}
+	s.journal = nil
+}
+
+func compareAssets(a, b AssetAmount) int {
+	return cmp.Compare(a.Asset, b.Asset)
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestRestoreSnapshot(t *testing.T) {
+	genesis := randomZeroBlock(t)
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	replica, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	setParams(t, bc, func(p *Params) { p.SnapshotInterval = 2 })
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey().PublicKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	txx := []*Transaction{}
+	for _, value := range []uint64{20, 30} {
+		tx := &Transaction{To: recipient, Value: value, Fee: 5}
+		assert.Nil(t, tx.Sign(sender))
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+		txx = append(txx, tx)
+	}
+
+	snapshots := bc.Snapshots()
+	assert.Len(t, snapshots, 1)
+	assert.Equal(t, uint32(2), snapshots[0].Height)
+	s, err := bc.GetSnapshot(2)
+	assert.Nil(t, err)
+
+	// a snapshot is only restored when trusted with its root
+	assert.NotNil(t, replica.RestoreSnapshot(copySnapshot(t, s)))
+	assert.Nil(t, replica.SetCheckpoints(snapshots))
+	assert.Nil(t, replica.RestoreSnapshot(copySnapshot(t, s)))
+
+	assert.Equal(t, uint32(2), replica.Height())
+	assert.Equal(t, uint32(2), replica.FinalizedHeight())
+	assert.Equal(t, bc.TotalWork(), replica.TotalWork())
+	assert.Equal(t, bc.Params(), replica.Params())
+	for _, tx := range txx {
+		assert.True(t, replica.HasTx(tx.Hash(TxHasher{})))
+	}
+	_, err = replica.GetBlock(1)
+	assert.NotNil(t, err)
+
+	// and syncs only the blocks following it
+	tx := &Transaction{To: recipient, Value: 10, Fee: 5}
+	assert.Nil(t, tx.Sign(sender))
+	b := nextBlock(t, bc, validator, []*Transaction{tx})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Nil(t, replica.AddBlock(b))
+
+	for _, address := range []crypto.PublicKey{sender.PublicKey(), validator.PublicKey(), recipient} {
+		ours, err := bc.GetBalances(address.Address())
+		assert.Nil(t, err)
+		theirs, err := replica.GetBalances(address.Address())
+		assert.Nil(t, err)
+		assert.Equal(t, ours, theirs)
+	}
+	assert.Equal(t, bc.TotalSupply(NativeAsset), replica.TotalSupply(NativeAsset))
+
+	// a chain past its genesis is not restored
+	assert.NotNil(t, replica.RestoreSnapshot(copySnapshot(t, s)))
+}
+
+func TestRestoreTamperedSnapshot(t *testing.T) {
+	genesis := randomZeroBlock(t)
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	replica, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	setParams(t, bc, func(p *Params) { p.SnapshotInterval = 1 })
+	validator := crypto.GeneratePrivateKey()
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, nil)))
+	s, err := bc.GetSnapshot(1)
+	assert.Nil(t, err)
+	assert.Nil(t, replica.SetCheckpoints(bc.Snapshots()))
+
+	tampered := copySnapshot(t, s)
+	tampered.Supply[0].Amount++
+	assert.ErrorIs(t, tampered.Verify(), ErrInvalidSnapshot)
+	assert.ErrorIs(t, replica.RestoreSnapshot(tampered), ErrInvalidSnapshot)
+
+	// a snapshot consistent with another root than the trusted one
+	tampered.StateRoot, err = tampered.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, tampered.Verify())
+	assert.ErrorIs(t, replica.RestoreSnapshot(tampered), ErrCheckpointMismatch)
+
+	assert.Equal(t, uint32(0), replica.Height())
+	assert.Nil(t, replica.RestoreSnapshot(copySnapshot(t, s)))
+}
+
+func TestRestoreSnapshotWithDroppedTx(t *testing.T) {
+	genesis := randomZeroBlock(t)
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	replica, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	setParams(t, bc, func(p *Params) { p.SnapshotInterval = 1 })
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	// the second spend is underfunded
+	spend := &Transaction{To: crypto.GeneratePrivateKey().PublicKey(), Value: 60, Fee: 5}
+	assert.Nil(t, spend.Sign(sender))
+	underfunded := &Transaction{To: crypto.GeneratePrivateKey().PublicKey(), Value: 60, Fee: 5}
+	assert.Nil(t, underfunded.Sign(sender))
+	b := nextBlock(t, bc, validator, []*Transaction{spend, underfunded})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Len(t, b.Transactions, 2)
+	assert.Equal(t, 1, appliedTxs(t, bc, b))
+
+	s, err := bc.GetSnapshot(1)
+	assert.Nil(t, err)
+	assert.Nil(t, replica.SetCheckpoints(bc.Snapshots()))
+	assert.Nil(t, replica.RestoreSnapshot(copySnapshot(t, s)))
+
+	assert.True(t, replica.HasTx(spend.Hash(TxHasher{})))
+	assert.False(t, replica.HasTx(underfunded.Hash(TxHasher{})))
+	restored, err := replica.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Len(t, restored.Transactions, 2)
+}
+
+// copySnapshot returns the snapshot as a peer receives it.
+func copySnapshot(t *testing.T, s *Snapshot) *Snapshot {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(s))
+
+	other := new(Snapshot)
+	assert.Nil(t, gob.NewDecoder(buf).Decode(other))
+
+	return other
+}
@@ -0,0 +1,418 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"slices"
+	"sort"
+)
+
+var ErrValidatorNotFound = errors.New("validator not found")
+
+// stakingEscrow holds all bonded and unbonding stake.
+var stakingEscrow = ModuleAddress("staking")
+
+// StakingParams make the validator set follow the bonded stake. Every epoch
+// the candidates with the most stake become the validators, voting with
+// their stake.
+type StakingParams struct {
+	EpochLength   uint32
+	MaxValidators uint32
+	// MinStake is the stake a candidate needs to become a validator.
+	MinStake uint64
+	// UnbondingPeriod is the number of blocks undelegated stake stays
+	// locked before it can be withdrawn.
+	UnbondingPeriod uint32
+	// Commission is the percentage of the block reward a validator keeps
+	// before sharing the rest with its delegators.
+	Commission uint8
+	// SlashPercent is the share of the stake bonded to a validator that is
+	// burned when it signs two blocks at the same height.
+	SlashPercent uint8
+	// JailPeriod is the number of blocks a slashed validator can't be
+	// chosen again.
+	JailPeriod uint32
+}
+
+func (p StakingParams) Validate() error {
+	if p.EpochLength == 0 {
+		return errors.New("epoch length must be positive")
+	}
+	if p.MaxValidators == 0 {
+		return errors.New("max validators must be positive")
+	}
+	if p.UnbondingPeriod == 0 {
+		return errors.New("unbonding period must be positive")
+	}
+	if p.Commission > 100 {
+		return fmt.Errorf("commission (%d%%) is above 100%%", p.Commission)
+	}
+	if p.SlashPercent > 100 {
+		return fmt.Errorf("slash percent (%d%%) is above 100%%", p.SlashPercent)
+	}
+
+	return nil
+}
+
+// ValidatorInfo is a validator candidate as tracked in the chain state.
+type ValidatorInfo struct {
+	Address types.Address
+	Key     crypto.PublicKey
+	// Stake is the sum of all delegations, including the own one.
+	Stake       uint64
+	Delegations []Delegation
+	// JailedUntil is the height before which a slashed validator can't be
+	// chosen again.
+	JailedUntil uint32
+	// Unbonding lists the delegators with stake unbonding from the
+	// candidate, a slash still reaches it.
+	Unbonding []types.Address
+}
+
+type Delegation struct {
+	Delegator types.Address
+	Amount    uint64
+}
+
+// UnbondingEntry is undelegated stake waiting for the unbonding period.
+type UnbondingEntry struct {
+	Validator types.Address
+	Amount    uint64
+	// Height is the height the stake was undelegated at.
+	Height      uint32
+	CompletesAt uint32
+}
+
+// StakeTx bonds Amount of the native asset of the sender to itself, making
+// it a validator candidate.
+type StakeTx struct {
+	Amount uint64
+}
+
+// DelegateTx bonds Amount of the native asset of the sender to a candidate.
+type DelegateTx struct {
+	Validator types.Address
+	Amount    uint64
+}
+
+// UndelegateTx starts unbonding Amount of the stake the sender delegated to
+// Validator, the own stake of a candidate included.
+type UndelegateTx struct {
+	Validator types.Address
+	Amount    uint64
+}
+
+// UnbondTx withdraws all stake of the sender whose unbonding period is over.
+type UnbondTx struct{}
+
+func validatorInfoKey(address types.Address) []byte {
+	return append([]byte("staking/validator/"), address.ToSlice()...)
+}
+
+func unbondingKey(address types.Address) []byte {
+	return append([]byte("staking/unbonding/"), address.ToSlice()...)
+}
+
+// candidatesKey holds the addresses of all validator candidates.
+var candidatesKey = []byte("staking/candidates")
+
+func (bc *Blockchain) stakingParams() (*StakingParams, error) {
+	params := bc.params()
+	if params.Staking == nil {
+		return nil, errors.New("staking is not enabled")
+	}
+
+	return params.Staking, nil
+}
+
+func (bc *Blockchain) getValidatorInfo(address types.Address) (*ValidatorInfo, error) {
+	info := &ValidatorInfo{}
+	found, err := bc.contractState.getGob(validatorInfoKey(address), info)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrValidatorNotFound
+	}
+
+	return info, nil
+}
+
+func (bc *Blockchain) candidates() ([]types.Address, error) {
+	candidates := []types.Address{}
+	if _, err := bc.contractState.getGob(candidatesKey, &candidates); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+func (bc *Blockchain) getUnbonding(address types.Address) ([]UnbondingEntry, error) {
+	entries := []UnbondingEntry{}
+	if _, err := bc.contractState.getGob(unbondingKey(address), &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (bc *Blockchain) handleStake(tx *Transaction, stake StakeTx) error {
+	if _, err := bc.stakingParams(); err != nil {
+		return err
+	}
+	if tx.Multisig != nil {
+		return errors.New("a multisig account can't validate blocks")
+	}
+
+	address := tx.Sender()
+	info, err := bc.getValidatorInfo(address)
+	if err == ErrValidatorNotFound {
+		info = &ValidatorInfo{Address: address, Key: tx.From}
+
+		candidates, err := bc.candidates()
+		if err != nil {
+			return err
+		}
+		if err := bc.contractState.putGob(candidatesKey, append(candidates, address)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return bc.delegate(address, info, stake.Amount)
+}
+
+func (bc *Blockchain) handleDelegate(tx *Transaction, d DelegateTx) error {
+	if _, err := bc.stakingParams(); err != nil {
+		return err
+	}
+
+	info, err := bc.getValidatorInfo(d.Validator)
+	if err != nil {
+		return err
+	}
+
+	return bc.delegate(tx.Sender(), info, d.Amount)
+}
+
+func (bc *Blockchain) delegate(delegator types.Address, info *ValidatorInfo, amount uint64) error {
+	if amount == 0 {
+		return errors.New("stake amount can't be zero")
+	}
+	if err := bc.accountState.Transfer(delegator, stakingEscrow, NativeAsset, amount); err != nil {
+		return err
+	}
+
+	info.Stake += amount
+	i := info.delegationIndex(delegator)
+	if i < 0 {
+		info.Delegations = append(info.Delegations, Delegation{Delegator: delegator})
+		i = len(info.Delegations) - 1
+	}
+	info.Delegations[i].Amount += amount
+
+	return bc.contractState.putGob(validatorInfoKey(info.Address), info)
+}
+
+func (bc *Blockchain) handleUndelegate(tx *Transaction, b *Block, u UndelegateTx) error {
+	staking, err := bc.stakingParams()
+	if err != nil {
+		return err
+	}
+
+	info, err := bc.getValidatorInfo(u.Validator)
+	if err != nil {
+		return err
+	}
+
+	delegator := tx.Sender()
+	i := info.delegationIndex(delegator)
+	if i < 0 || u.Amount == 0 || info.Delegations[i].Amount < u.Amount {
+		return fmt.Errorf("(%s) has not delegated %d to (%s)", delegator, u.Amount, u.Validator)
+	}
+
+	info.Stake -= u.Amount
+	info.Delegations[i].Amount -= u.Amount
+	if info.Delegations[i].Amount == 0 {
+		info.Delegations = append(info.Delegations[:i], info.Delegations[i+1:]...)
+	}
+	if !slices.Contains(info.Unbonding, delegator) {
+		info.Unbonding = append(info.Unbonding, delegator)
+	}
+	if err := bc.contractState.putGob(validatorInfoKey(info.Address), info); err != nil {
+		return err
+	}
+
+	entries, err := bc.getUnbonding(delegator)
+	if err != nil {
+		return err
+	}
+
+	return bc.contractState.putGob(unbondingKey(delegator), append(entries, UnbondingEntry{
+		Validator:   u.Validator,
+		Amount:      u.Amount,
+		Height:      b.Height,
+		CompletesAt: b.Height + staking.UnbondingPeriod,
+	}))
+}
+
+func (bc *Blockchain) handleUnbond(tx *Transaction, b *Block) error {
+	delegator := tx.Sender()
+	entries, err := bc.getUnbonding(delegator)
+	if err != nil {
+		return err
+	}
+
+	var (
+		amount  uint64
+		pending = []UnbondingEntry{}
+	)
+	for _, e := range entries {
+		if b.Height >= e.CompletesAt {
+			amount += e.Amount
+		} else {
+			pending = append(pending, e)
+		}
+	}
+	if amount == 0 {
+		return fmt.Errorf("(%s) has no completed unbonding", delegator)
+	}
+
+	if err := bc.accountState.Transfer(stakingEscrow, delegator, NativeAsset, amount); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if b.Height < e.CompletesAt || slices.ContainsFunc(pending, func(p UnbondingEntry) bool { return p.Validator == e.Validator }) {
+			continue
+		}
+		if err := bc.removeUnbonding(e.Validator, delegator); err != nil {
+			return err
+		}
+	}
+
+	return bc.contractState.putGob(unbondingKey(delegator), pending)
+}
+
+// removeUnbonding takes the delegator off the list of the validator once no
+// stake of it unbonds from the validator anymore.
+func (bc *Blockchain) removeUnbonding(validator, delegator types.Address) error {
+	info, err := bc.getValidatorInfo(validator)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(info.Unbonding, delegator)
+	if i < 0 {
+		return nil
+	}
+	info.Unbonding = slices.Delete(info.Unbonding, i, i+1)
+
+	return bc.contractState.putGob(validatorInfoKey(validator), info)
+}
+
+// distributeReward pays the reward of a block produced by a candidate: the
+// commission to the candidate and the rest to the delegations in proportion
+// to their amount. The rounding remainder goes to the candidate as well.
+func (bc *Blockchain) distributeReward(info *ValidatorInfo, commission uint8, reward uint64) {
+	hi, lo := bits.Mul64(reward, uint64(commission))
+	kept, _ := bits.Div64(hi, lo, 100)
+	shared := reward - kept
+
+	paid := uint64(0)
+	if info.Stake > 0 {
+		for _, d := range info.Delegations {
+			hi, lo := bits.Mul64(shared, d.Amount)
+			share, _ := bits.Div64(hi, lo, info.Stake)
+			if share > 0 {
+				bc.accountState.Mint(d.Delegator, NativeAsset, share)
+				paid += share
+			}
+		}
+	}
+
+	bc.accountState.Mint(info.Address, NativeAsset, reward-paid)
+}
+
+// updateValidatorSet runs after block b has been applied. When the next
+// block starts an epoch the candidates with the most stake become the
+// validators. The set stays as it is when no candidate qualifies.
+func (bc *Blockchain) updateValidatorSet(b *Block) error {
+	params := bc.params()
+	if params.Staking == nil || (b.Height+1)%params.Staking.EpochLength != 0 {
+		return nil
+	}
+
+	candidates, err := bc.candidates()
+	if err != nil {
+		return err
+	}
+
+	active := []*ValidatorInfo{}
+	for _, address := range candidates {
+		info, err := bc.getValidatorInfo(address)
+		if err != nil {
+			return err
+		}
+		if info.JailedUntil > b.Height+1 {
+			continue
+		}
+		if info.Stake > 0 && info.Stake >= params.Staking.MinStake {
+			active = append(active, info)
+		}
+	}
+	if len(active) == 0 {
+		return nil
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].Stake != active[j].Stake {
+			return active[i].Stake > active[j].Stake
+		}
+		return bytes.Compare(active[i].Address.ToSlice(), active[j].Address.ToSlice()) < 0
+	})
+	if len(active) > int(params.Staking.MaxValidators) {
+		active = active[:params.Staking.MaxValidators]
+	}
+
+	params.Validators = make([]crypto.PublicKey, len(active))
+	params.Powers = make([]uint64, len(active))
+	for i, info := range active {
+		params.Validators[i] = info.Key
+		params.Powers[i] = info.Stake
+	}
+
+	bc.logger.Log("msg", "new validator set", "height", b.Height+1, "validators", len(active))
+
+	return bc.contractState.putGob(paramsKey, params)
+}
+
+func (info *ValidatorInfo) delegationIndex(delegator types.Address) int {
+	for i, d := range info.Delegations {
+		if d.Delegator == delegator {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// GetValidatorInfo returns the stake and delegations of a validator candidate.
+func (bc *Blockchain) GetValidatorInfo(address types.Address) (*ValidatorInfo, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.getValidatorInfo(address)
+}
+
+// GetUnbonding returns the stake of the given address waiting to be withdrawn.
+func (bc *Blockchain) GetUnbonding(address types.Address) ([]UnbondingEntry, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.getUnbonding(address)
+}
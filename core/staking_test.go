@@ -0,0 +1,137 @@
+package core
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"testing"
+)
+
+func newStakingBlockchain(t *testing.T) *Blockchain {
+	params := DefaultParams
+	params.Reward = RewardSchedule{InitialReward: 100}
+	params.Staking = &StakingParams{
+		EpochLength:     3,
+		MaxValidators:   2,
+		MinStake:        10,
+		UnbondingPeriod: 2,
+		Commission:      10,
+	}
+
+	genesis, err := GenesisConfig{Params: &params}.Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	return bc
+}
+
+func stakingTx(t *testing.T, privKey crypto.PrivateKey, inner any) *Transaction {
+	tx := &Transaction{TxInner: inner}
+	assert.Nil(t, tx.Sign(privKey))
+
+	return tx
+}
+
+func TestStakingValidatorSet(t *testing.T) {
+	bc := newStakingBlockchain(t)
+	a, b, c, d := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	for _, k := range []crypto.PrivateKey{a, b, c, d} {
+		bc.accountState.Mint(k.PublicKey().Address(), NativeAsset, 100)
+	}
+	producer := crypto.GeneratePrivateKey()
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, producer, []*Transaction{
+		stakingTx(t, a, StakeTx{Amount: 50}),
+		stakingTx(t, b, StakeTx{Amount: 30}),
+		stakingTx(t, c, StakeTx{Amount: 5}),
+		stakingTx(t, d, DelegateTx{Validator: b.PublicKey().Address(), Amount: 50}),
+	})))
+	assertBalance(t, bc, d.PublicKey().Address(), 50)
+
+	info, err := bc.GetValidatorInfo(b.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(80), info.Stake)
+
+	// the set changes with the epoch starting at block 3
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, producer, nil)))
+	params := bc.Params()
+	assert.Equal(t, []crypto.PublicKey{b.PublicKey(), a.PublicKey()}, params.Validators)
+	assert.Equal(t, []uint64{80, 50}, params.Powers)
+	assert.Equal(t, uint64(130), params.TotalPower())
+
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, producer, nil)))
+	assert.NotNil(t, bc.AddBlock(nextBlock(t, bc, c, nil)))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, b, nil)))
+
+	// 10 commission, the other 90 shared 30:50
+	assertBalance(t, bc, b.PublicKey().Address(), 70+10+33+1)
+	assertBalance(t, bc, d.PublicKey().Address(), 50+56)
+}
+
+func TestUndelegateAndUnbond(t *testing.T) {
+	bc := newStakingBlockchain(t)
+	a, d := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	bc.accountState.Mint(a.PublicKey().Address(), NativeAsset, 100)
+	bc.accountState.Mint(d.PublicKey().Address(), NativeAsset, 100)
+	producer := crypto.GeneratePrivateKey()
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, producer, []*Transaction{
+		stakingTx(t, a, StakeTx{Amount: 50}),
+		stakingTx(t, d, DelegateTx{Validator: a.PublicKey().Address(), Amount: 40}),
+	})))
+
+	// more than delegated
+	b := nextBlock(t, bc, producer, []*Transaction{
+		stakingTx(t, d, UndelegateTx{Validator: a.PublicKey().Address(), Amount: 41}),
+	})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, a, []*Transaction{
+		stakingTx(t, d, UndelegateTx{Validator: a.PublicKey().Address(), Amount: 40}),
+	})))
+
+	info, err := bc.GetValidatorInfo(a.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(50), info.Stake)
+	assert.Len(t, info.Delegations, 1)
+	assert.Equal(t, []types.Address{d.PublicKey().Address()}, info.Unbonding)
+
+	entries, err := bc.GetUnbonding(d.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, []UnbondingEntry{{Validator: a.PublicKey().Address(), Amount: 40, Height: 3, CompletesAt: 5}}, entries)
+
+	// still unbonding at block 4
+	b = nextBlock(t, bc, a, []*Transaction{stakingTx(t, d, UnbondTx{})})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, a, []*Transaction{stakingTx(t, d, UnbondTx{})})))
+	assertBalance(t, bc, d.PublicKey().Address(), 100)
+
+	info, err = bc.GetValidatorInfo(a.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Empty(t, info.Unbonding)
+}
+
+func TestDistributeLargeReward(t *testing.T) {
+	bc := newStakingBlockchain(t)
+	a, d := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	info := &ValidatorInfo{
+		Address: a.PublicKey().Address(),
+		Stake:   2,
+		Delegations: []Delegation{
+			{Delegator: a.PublicKey().Address(), Amount: 1},
+			{Delegator: d.PublicKey().Address(), Amount: 1},
+		},
+	}
+
+	// reward*commission overflows a uint64
+	reward := uint64(1 << 62)
+	bc.distributeReward(info, 50, reward)
+	assertBalance(t, bc, d.PublicKey().Address(), reward/4)
+	assertBalance(t, bc, a.PublicKey().Address(), reward-reward/4)
+}
@@ -1,11 +1,22 @@
 package core
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 )
 
 type State struct {
 	data map[string][]byte
+	// journal records every change since the last Commit so they can be reverted
+	journal []stateChange
+}
+
+// stateChange is a journal entry holding the value of a key before the change.
+type stateChange struct {
+	key     string
+	prev    []byte
+	existed bool
 }
 
 func NewState() *State {
@@ -15,12 +26,14 @@ func NewState() *State {
 }
 
 func (s *State) Put(k, v []byte) error {
+	s.record(string(k))
 	s.data[string(k)] = v
 
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
+	s.record(string(k))
 	delete(s.data, string(k))
 
 	return nil
@@ -36,3 +49,83 @@ func (s *State) Get(k []byte) ([]byte, error) {
 
 	return value, nil
 }
+
+// putGob stores the gob encoding of v under the given key.
+func (s *State) putGob(k []byte, v any) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+
+	return s.Put(k, buf.Bytes())
+}
+
+// getGob decodes the value stored under the given key into v and reports
+// whether the key was found.
+func (s *State) getGob(k []byte, v any) (bool, error) {
+	value, ok := s.data[string(k)]
+	if !ok {
+		return false, nil
+	}
+
+	return true, gob.NewDecoder(bytes.NewReader(value)).Decode(v)
+}
+
+// Snapshot returns an identifier for the current state which can be passed
+// to RevertToSnapshot until the next Commit.
+func (s *State) Snapshot() int {
+	return len(s.journal)
+}
+
+// RevertToSnapshot undoes every change made since the given snapshot was taken.
+func (s *State) RevertToSnapshot(id int) {
+	s.undo(s.journal[id:])
+	s.journal = s.journal[:id]
+}
+
+// Commit makes all changes so far permanent and invalidates older snapshots.
+// It returns the changes, see StateDiff.
+func (s *State) Commit() []stateChange {
+	changes := s.journal
+	s.journal = nil
+
+	return changes
+}
+
+func (s *State) undo(changes []stateChange) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if change.existed {
+			s.data[change.key] = change.prev
+		} else {
+			delete(s.data, change.key)
+		}
+	}
+}
+
+// getBefore returns the value of the key before the given blocks, which
+// have to be the last ones, oldest first.
+func (s *State) getBefore(k []byte, diffs []*StateDiff) ([]byte, error) {
+	for _, d := range diffs {
+		for _, change := range d.Storage {
+			if !bytes.Equal(change.Key, k) {
+				continue
+			}
+			if change.Created {
+				return nil, fmt.Errorf("given key %s not found", k)
+			}
+			return change.Old, nil
+		}
+	}
+
+	return s.Get(k)
+}
+
+func (s *State) record(key string) {
+	prev, existed := s.data[key]
+	s.journal = append(s.journal, stateChange{
+		key:     key,
+		prev:    prev,
+		existed: existed,
+	})
+}
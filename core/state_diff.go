@@ -0,0 +1,279 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"sharkchain/types"
+	"slices"
+)
+
+// StateDiff holds every value a block changed, before and after the block.
+// Applying it to the state at the parent gives the state after the block
+// without executing it, reverting it goes back.
+type StateDiff struct {
+	Height    uint32
+	BlockHash types.Hash
+	Accounts  []AccountDiff
+	Supply    []SupplyDiff
+	Storage   []StorageDiff
+	// Dropped are the txs of the block that failed, they changed nothing.
+	Dropped []types.Hash
+}
+
+// Applied reports whether the tx of the block was applied, it was dropped
+// otherwise.
+func (d *StateDiff) Applied(tx *Transaction) bool {
+	return !slices.Contains(d.Dropped, tx.Hash(TxHasher{}))
+}
+
+// AccountDiff holds the changes of a block to an account.
+type AccountDiff struct {
+	Address types.Address
+	// Created is set when the block brought the account into existence.
+	Created  bool
+	Balances []BalanceDiff
+	// Vesting are the schedules of the account after the block and
+	// OldVesting the ones before it, only set when the block changed them.
+	// A change always attaches a schedule, Vesting is never empty then.
+	Vesting    []VestingSchedule
+	OldVesting []VestingSchedule
+}
+
+type BalanceDiff struct {
+	Asset AssetID
+	Old   uint64
+	New   uint64
+}
+
+type SupplyDiff struct {
+	Asset AssetID
+	Old   uint64
+	New   uint64
+}
+
+// StorageDiff holds the change of a block to a key of the contract state.
+type StorageDiff struct {
+	Key []byte
+	Old []byte
+	New []byte
+	// Created is set when the key did not exist before the block, Deleted
+	// when it does not exist after it.
+	Created bool
+	Deleted bool
+}
+
+// GetStateDiff returns the changes of the block at the given height.
+func (bc *Blockchain) GetStateDiff(height uint32) (*StateDiff, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	i, err := bc.index(height)
+	if err != nil {
+		return nil, err
+	}
+	if bc.diffs[i] == nil {
+		return nil, fmt.Errorf("block (%d) is part of the snapshot the chain started from", height)
+	}
+
+	return bc.diffs[i], nil
+}
+
+// AddBlockWithStateDiff adds a block extending the chain by applying its
+// state diff instead of executing it, e.g. when replicating the chain of a
+// trusted node. The block is validated as usual, the receipts of its
+// transactions are not known.
+func (bc *Blockchain) AddBlockWithStateDiff(b *Block, d *StateDiff) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	if d.Height != b.Height || d.BlockHash != b.Hash(BlockHasher{}) {
+		return fmt.Errorf("state diff of block (%d) is not the one of (%s)", d.Height, b.Hash(BlockHasher{}))
+	}
+	if err := bc.checkCheckpoint(b); err != nil {
+		return err
+	}
+	if err := bc.validator.ValidateBlock(b); err != nil {
+		return err
+	}
+
+	bc.stateLock.Lock()
+	work := bc.engine().Work(b.Header)
+	interval := bc.params().CheckpointInterval
+	bc.accountState.ApplyDiff(d)
+	bc.contractState.ApplyDiff(d.Storage)
+	bc.accountState.SetBlock(b.Height, b.Timestamp)
+	bc.stateLock.Unlock()
+
+	if err := bc.appendBlock(b, d, nil, work, interval); err != nil {
+		return err
+	}
+
+	return bc.takeSnapshot(b)
+}
+
+// stateDiff builds the diff of block b from the txs it dropped and the
+// changes committed by it. The state lock must be held.
+func (bc *Blockchain) stateDiff(b *Block, dropped []types.Hash, accounts []accountChange, contract []stateChange) *StateDiff {
+	d := &StateDiff{
+		Height:    b.Height,
+		BlockHash: b.Hash(BlockHasher{}),
+		Dropped:   dropped,
+	}
+	d.Accounts, d.Supply = bc.accountState.diff(accounts)
+	d.Storage = bc.contractState.diff(contract)
+
+	return d
+}
+
+// diff turns committed changes into the values before and after them.
+func (s *AccountState) diff(changes []accountChange) ([]AccountDiff, []SupplyDiff) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var (
+		accounts = []AccountDiff{}
+		supply   = []SupplyDiff{}
+		index    = make(map[types.Address]int)
+		vesting  = make(map[types.Address]bool)
+		seen     = make(map[types.Address]map[AssetID]bool)
+		supplied = make(map[AssetID]bool)
+	)
+	for _, change := range changes {
+		if change.supply {
+			if !supplied[change.asset] && change.prev != s.supply[change.asset] {
+				supply = append(supply, SupplyDiff{Asset: change.asset, Old: change.prev, New: s.supply[change.asset]})
+			}
+			supplied[change.asset] = true
+			continue
+		}
+
+		i, ok := index[change.address]
+		if !ok {
+			i = len(accounts)
+			index[change.address] = i
+			accounts = append(accounts, AccountDiff{Address: change.address})
+			seen[change.address] = make(map[AssetID]bool)
+		}
+		d := &accounts[i]
+		account := s.accounts[change.address]
+
+		switch {
+		case change.created:
+			d.Created = true
+		case change.vesting:
+			if !vesting[change.address] {
+				d.OldVesting = slices.Clone(change.vestingBefore)
+				d.Vesting = slices.Clone(account.Vesting)
+				vesting[change.address] = true
+			}
+		case !seen[change.address][change.asset]:
+			seen[change.address][change.asset] = true
+			if balance := account.Balance(change.asset); balance != change.prev {
+				d.Balances = append(d.Balances, BalanceDiff{Asset: change.asset, Old: change.prev, New: balance})
+			}
+		}
+	}
+
+	// leave out the accounts which ended up unchanged
+	accounts = slices.DeleteFunc(accounts, func(d AccountDiff) bool {
+		return !d.Created && len(d.Balances) == 0 && len(d.Vesting) == 0
+	})
+
+	return accounts, supply
+}
+
+// ApplyDiff applies the account and supply changes of a block to the state
+// at its parent.
+func (s *AccountState) ApplyDiff(d *StateDiff) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range d.Accounts {
+		account := s.accounts[a.Address]
+		if account == nil {
+			account = NewAccount(a.Address)
+			s.accounts[a.Address] = account
+		}
+		for _, b := range a.Balances {
+			account.Balances[b.Asset] = b.New
+		}
+		if len(a.Vesting) > 0 {
+			account.Vesting = slices.Clone(a.Vesting)
+		}
+	}
+	for _, supply := range d.Supply {
+		s.supply[supply.Asset] = supply.New
+	}
+}
+
+// RevertDiff reverts the account and supply changes of the last block.
+func (s *AccountState) RevertDiff(d *StateDiff) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range d.Accounts {
+		if a.Created {
+			delete(s.accounts, a.Address)
+			continue
+		}
+
+		account := s.accounts[a.Address]
+		for _, b := range a.Balances {
+			account.Balances[b.Asset] = b.Old
+		}
+		if len(a.Vesting) > 0 {
+			account.Vesting = slices.Clone(a.OldVesting)
+		}
+	}
+	for _, supply := range d.Supply {
+		s.supply[supply.Asset] = supply.Old
+	}
+}
+
+// diff turns committed changes into the values before and after them.
+func (s *State) diff(changes []stateChange) []StorageDiff {
+	storage := []StorageDiff{}
+	seen := make(map[string]bool)
+	for _, change := range changes {
+		if seen[change.key] {
+			continue
+		}
+		seen[change.key] = true
+
+		value, exists := s.data[change.key]
+		if exists == change.existed && bytes.Equal(value, change.prev) {
+			continue
+		}
+		storage = append(storage, StorageDiff{
+			Key:     []byte(change.key),
+			Old:     change.prev,
+			New:     value,
+			Created: !change.existed,
+			Deleted: !exists,
+		})
+	}
+
+	return storage
+}
+
+// ApplyDiff applies the storage changes of a block to the state at its parent.
+func (s *State) ApplyDiff(storage []StorageDiff) {
+	for _, d := range storage {
+		if d.Deleted {
+			delete(s.data, string(d.Key))
+		} else {
+			s.data[string(d.Key)] = d.New
+		}
+	}
+}
+
+// RevertDiff reverts the storage changes of the last block.
+func (s *State) RevertDiff(storage []StorageDiff) {
+	for _, d := range storage {
+		if d.Created {
+			delete(s.data, string(d.Key))
+		} else {
+			s.data[string(d.Key)] = d.Old
+		}
+	}
+}
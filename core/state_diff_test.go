@@ -0,0 +1,81 @@
+package core
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestStateDiff(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	setParams(t, bc, func(p *Params) { p.Reward = RewardSchedule{} })
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	recipient := crypto.GeneratePrivateKey().PublicKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	for _, value := range []uint64{20, 30} {
+		tx := &Transaction{To: recipient, Value: value, Fee: 5}
+		assert.Nil(t, tx.Sign(sender))
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+		assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte("bar")))
+	}
+
+	d, err := bc.GetStateDiff(2)
+	assert.Nil(t, err)
+	b, err := bc.GetBlock(2)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(BlockHasher{}), d.BlockHash)
+	assert.Equal(t, []AccountDiff{
+		{Address: sender.PublicKey().Address(), Balances: []BalanceDiff{{Asset: NativeAsset, Old: 75, New: 40}}},
+		{Address: validator.PublicKey().Address(), Balances: []BalanceDiff{{Asset: NativeAsset, Old: 5, New: 10}}},
+		{Address: recipient.Address(), Balances: []BalanceDiff{{Asset: NativeAsset, Old: 20, New: 50}}},
+	}, d.Accounts)
+	assert.Empty(t, d.Supply)
+	assert.Equal(t, []StorageDiff{{Key: []byte("foo"), New: []byte("bar"), Created: true}}, d.Storage)
+
+	_, err = bc.GetStateDiff(3)
+	assert.NotNil(t, err)
+}
+
+func TestAddBlockWithStateDiff(t *testing.T) {
+	genesis := randomZeroBlock(t)
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	replica, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	setParams(t, bc, func(p *Params) { p.Reward = RewardSchedule{InitialReward: 10} })
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+	tx := &Transaction{To: crypto.GeneratePrivateKey().PublicKey(), Value: 20, Fee: 5}
+	assert.Nil(t, tx.Sign(sender))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{tx})))
+
+	b, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	d, err := bc.GetStateDiff(1)
+	assert.Nil(t, err)
+
+	other, err := bc.GetStateDiff(0)
+	assert.Nil(t, err)
+	assert.NotNil(t, replica.AddBlockWithStateDiff(b, other))
+	assert.Nil(t, replica.AddBlockWithStateDiff(b, d))
+
+	// the same state without executing the block
+	for _, address := range []crypto.PublicKey{sender.PublicKey(), validator.PublicKey(), tx.To} {
+		ours, err := bc.GetBalances(address.Address())
+		assert.Nil(t, err)
+		theirs, err := replica.GetBalances(address.Address())
+		assert.Nil(t, err)
+		assert.Equal(t, ours, theirs)
+	}
+	assert.Equal(t, bc.TotalSupply(NativeAsset), replica.TotalSupply(NativeAsset))
+	assert.Equal(t, bc.Params(), replica.Params())
+	assert.True(t, replica.HasTx(tx.Hash(TxHasher{})))
+
+	replica.revertTo(0)
+	_, err = replica.GetBalances(sender.PublicKey().Address())
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+	assert.Equal(t, uint64(0), replica.TotalSupply(NativeAsset))
+}
@@ -2,6 +2,8 @@ package core
 
 type Storage interface {
 	Put(*Block) error
+	// PutStateDiff stores the state changes of a block along with it.
+	PutStateDiff(*StateDiff) error
 }
 
 type MemoryStore struct {
@@ -14,3 +16,7 @@ func NewMemoryStore() *MemoryStore {
 func (s *MemoryStore) Put(b *Block) error {
 	return nil
 }
+
+func (s *MemoryStore) PutStateDiff(d *StateDiff) error {
+	return nil
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"sharkchain/crypto"
 	"sharkchain/types"
@@ -21,13 +23,28 @@ type MintTx struct {
 }
 
 type Transaction struct {
-	Data []byte
-
-	From      crypto.PublicKey
-	To        crypto.PublicKey
-	Value     uint64
+	// Only used for native NFT logic and other native transaction kinds.
+	TxInner any
+	Data    []byte
+
+	From  crypto.PublicKey
+	To    crypto.PublicKey
+	Value uint64
+	// Asset is the asset Value is denominated in.
+	Asset AssetID
+	// Fee is paid in the native asset to the validator including the tx.
+	Fee       uint64
 	Signature *crypto.Signature
 	Nonce     int64
+	// ValidUntilHeight is the last block height the tx may be included in,
+	// zero means no limit.
+	ValidUntilHeight uint32
+	// ValidUntilTime is the last block timestamp (unix nano) the tx may be
+	// included in, zero means no limit.
+	ValidUntilTime int64
+	// Multisig is set instead of From and Signature when the tx is sent
+	// by a multisig account.
+	Multisig *MultisigAuth
 	// cached version of the tx data hash
 	hash types.Hash
 }
@@ -45,30 +62,70 @@ func (tx *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 	return tx.hash
 }
 
+// Sign signs the hash of the transaction, so every field except the signature
+// itself is covered.
 func (tx *Transaction) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(tx.Data)
+	tx.From = privKey.PublicKey()
+	tx.hash = types.Hash{}
+
+	hash := tx.Hash(TxHasher{})
+	sig, err := privKey.Sign(hash.ToSlice())
 	if err != nil {
 		return err
 	}
 
-	tx.From = privKey.PublicKey()
 	tx.Signature = sig
 
 	return nil
 }
 
+// Sender returns the address of the account sending the tx.
+func (tx *Transaction) Sender() types.Address {
+	if tx.Multisig != nil {
+		return tx.Multisig.Account
+	}
+
+	return tx.From.Address()
+}
+
 func (tx *Transaction) Verify() error {
+	if tx.Multisig != nil {
+		return tx.Multisig.verify(tx)
+	}
+
 	if tx.Signature == nil {
 		return fmt.Errorf("transaction has no signature")
 	}
 
-	if !tx.Signature.Verify(tx.From, tx.Data) {
+	// don't trust the cached hash, the tx could have been altered after signing
+	hash := TxHasher{}.Hash(tx)
+	if !tx.Signature.Verify(tx.From, hash.ToSlice()) {
 		return fmt.Errorf("invalid transaction signature")
 	}
 
 	return nil
 }
 
+// Expired reports whether the tx can no longer be included in a block with
+// the given height and timestamp.
+func (tx *Transaction) Expired(height uint32, timestamp int64) bool {
+	if tx.ValidUntilHeight > 0 && height > tx.ValidUntilHeight {
+		return true
+	}
+
+	return tx.ValidUntilTime > 0 && timestamp > tx.ValidUntilTime
+}
+
+// Size returns the length of the encoded tx in bytes.
+func (tx *Transaction) Size() (int, error) {
+	buf := &bytes.Buffer{}
+	if err := tx.Encode(NewGobTxEncoder(buf)); err != nil {
+		return 0, err
+	}
+
+	return buf.Len(), nil
+}
+
 func (tx *Transaction) Decode(dec Decoder[*Transaction]) error {
 	return dec.Decode(tx)
 }
@@ -80,4 +137,22 @@ func (tx *Transaction) Encode(enc Encoder[*Transaction]) error {
 func init() {
 	//gob.Register(CollectionTx{})
 	//gob.Register(MintTx{})
+	gob.Register(BatchTx{})
+	gob.Register(MultisigUpdateTx{})
+	gob.Register(VestingTx{})
+	gob.Register(HTLCLockTx{})
+	gob.Register(HTLCClaimTx{})
+	gob.Register(HTLCRefundTx{})
+	gob.Register(ChannelOpenTx{})
+	gob.Register(ChannelCloseTx{})
+	gob.Register(ChannelChallengeTx{})
+	gob.Register(ChannelSettleTx{})
+	gob.Register(ParamsTx{})
+	gob.Register(ProposalTx{})
+	gob.Register(ValidatorProposalTx{})
+	gob.Register(VoteTx{})
+	gob.Register(StakeTx{})
+	gob.Register(DelegateTx{})
+	gob.Register(UndelegateTx{})
+	gob.Register(UnbondTx{})
 }
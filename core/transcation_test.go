@@ -53,3 +53,10 @@ func TestTxEncodeDecode(t *testing.T) {
 	assert.Nil(t, txDecoded.Decode(NewGobTxDecoder(buf)))
 	assert.Equal(t, tx, txDecoded)
 }
+
+func TestVerifyTransactionExpiryIsSigned(t *testing.T) {
+	tx := randomTxWithSignature(t)
+	tx.ValidUntilHeight = 10
+
+	assert.NotNil(t, tx.Verify())
+}
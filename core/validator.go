@@ -3,9 +3,25 @@ package core
 import (
 	"errors"
 	"fmt"
+	"sharkchain/types"
+	"time"
 )
 
-var ErrBlockKnown = errors.New("block already known")
+var (
+	ErrBlockKnown = errors.New("block already known")
+	// ErrUnknownParent is returned for a block whose parent is not known,
+	// neither in the chain nor in a competing branch.
+	ErrUnknownParent = errors.New("parent block unknown")
+	ErrTxExpired     = errors.New("transaction expired")
+	// ErrFutureBlock is returned for a block whose timestamp is too far
+	// ahead of the local time.
+	ErrFutureBlock = errors.New("block timestamp too far in the future")
+)
+
+// MaxFutureDrift is how far ahead of the local time the timestamp of a block
+// may be. The producer picks the timestamp, unbounded it could pick the
+// slot it is elected in or the difficulty it mines at.
+const MaxFutureDrift = 15 * time.Second
 
 type Validator interface {
 	ValidateBlock(*Block) error
@@ -22,6 +38,15 @@ func NewBlockValidator(bc *Blockchain) *BlockValidator {
 }
 
 func (v *BlockValidator) ValidateBlock(b *Block) error {
+	if err := v.validateUnsealed(b); err != nil {
+		return err
+	}
+
+	return v.bc.Engine().VerifySeal(v.bc, b)
+}
+
+// validateUnsealed runs every check except the consensus specific ones.
+func (v *BlockValidator) validateUnsealed(b *Block) error {
 	// check height
 	if v.bc.HasBlock(b.Height) {
 		// return fmt.Errorf("chain already contains block (%d) with hash (%s)", b.Height, b.Hash(BlockHasher{}))
@@ -33,6 +58,11 @@ func (v *BlockValidator) ValidateBlock(b *Block) error {
 		return fmt.Errorf("block (%s) with height (%d) is too high => current height (%d)", b.Hash(BlockHasher{}), b.Height, v.bc.Height())
 	}
 
+	if version := v.bc.VersionAt(b.Height); b.Version != version {
+		return fmt.Errorf("block (%d) has version (%d) but the fork schedule requires (%d)", b.Height, b.Version, version)
+	}
+	rules := v.bc.RulesAt(b.Height)
+
 	// check hash of pre block
 	prevHeader, err := v.bc.GetHeader(b.Height - 1)
 	if err != nil {
@@ -44,10 +74,83 @@ func (v *BlockValidator) ValidateBlock(b *Block) error {
 		return fmt.Errorf("the hash of the previous block (%s) is invalid", b.PrevBlockHash)
 	}
 
+	if rules.MonotonicTimestamps && b.Timestamp <= prevHeader.Timestamp {
+		return fmt.Errorf("block (%d) timestamp (%d) is not after its parent's (%d)", b.Height, b.Timestamp, prevHeader.Timestamp)
+	}
+	if err := checkFutureDrift(b.Header); err != nil {
+		return err
+	}
+
 	// verify block
-	if err := b.Verify(); err != nil {
+	if err := v.bc.verifyBlock(b); err != nil {
+		return err
+	}
+	// the signature covers the header only, the data hash ties the
+	// transactions to it
+	dataHash, err := CalculateDataHash(b.Transactions)
+	if err != nil {
 		return err
 	}
+	if dataHash != b.DataHash {
+		return fmt.Errorf("block (%d) data hash (%s) does not match its transactions (%s)", b.Height, b.DataHash, dataHash)
+	}
+
+	params := v.bc.Params()
+	if len(b.VRFProof) > 0 && params.Consensus != ConsensusVRF {
+		return fmt.Errorf("block (%d) carries a VRF proof but the chain does not use VRF election", b.Height)
+	}
+
+	if params.MaxBlockSize > 0 {
+		size := 0
+		for _, tx := range b.Transactions {
+			txSize, err := tx.Size()
+			if err != nil {
+				return err
+			}
+			size += txSize
+		}
+		if size > int(params.MaxBlockSize) {
+			return fmt.Errorf("block (%d) transactions take %d bytes, the maximum is %d", b.Height, size, params.MaxBlockSize)
+		}
+	}
+
+	if hash := CalculateEvidenceHash(b.Evidence); hash != b.EvidenceHash {
+		return fmt.Errorf("block (%d) evidence hash (%s) does not match its evidence (%s)", b.Height, b.EvidenceHash, hash)
+	}
+	punished := make(map[string]bool, len(b.Evidence))
+	for _, e := range b.Evidence {
+		if err := v.bc.CheckEvidence(e); err != nil {
+			return fmt.Errorf("block (%d) carries invalid evidence: %w", b.Height, err)
+		}
+		// one punishment per validator and height
+		key := string(punishedKey(e.Offender().Address(), e.Height()))
+		if punished[key] {
+			return fmt.Errorf("block (%d) punishes (%s) twice", b.Height, e.Offender().Address())
+		}
+		punished[key] = true
+	}
+
+	included := make(map[types.Hash]bool, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		hash := tx.Hash(TxHasher{})
+		if tx.Expired(b.Height, b.Timestamp) {
+			return fmt.Errorf("%w: tx (%s) in block (%d)", ErrTxExpired, hash, b.Height)
+		}
+		if included[hash] {
+			return fmt.Errorf("block (%d) includes tx (%s) twice", b.Height, hash)
+		}
+		included[hash] = true
+	}
+
+	return nil
+}
+
+// checkFutureDrift refuses a header timestamped more than MaxFutureDrift
+// ahead of the local time.
+func checkFutureDrift(h *Header) error {
+	if limit := time.Now().Add(MaxFutureDrift).UnixNano(); h.Timestamp > limit {
+		return fmt.Errorf("%w: block (%d) timestamp (%d) is (%s) ahead", ErrFutureBlock, h.Height, h.Timestamp, time.Duration(h.Timestamp-time.Now().UnixNano()))
+	}
 
 	return nil
 }
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"math/bits"
+	"sharkchain/types"
+)
+
+// MaxVestingSchedules bounds the schedules locking the balances of an
+// account, each transfer out of it walks all of them.
+const MaxVestingSchedules = 32
+
+// VestingSchedule locks Amount of Asset and releases it linearly between
+// Start and End, nothing is released before Cliff. The bounds are block
+// heights, or unix nano timestamps when ByTime is set.
+type VestingSchedule struct {
+	Asset  AssetID
+	Amount uint64
+	ByTime bool
+	Start  int64
+	Cliff  int64
+	End    int64
+}
+
+func (v VestingSchedule) Validate() error {
+	if v.Amount == 0 {
+		return fmt.Errorf("vesting amount can't be zero")
+	}
+
+	if v.Start > v.Cliff || v.Cliff > v.End {
+		return fmt.Errorf("vesting bounds must satisfy start (%d) <= cliff (%d) <= end (%d)", v.Start, v.Cliff, v.End)
+	}
+
+	return nil
+}
+
+// Locked returns the amount that is not vested yet at the given block.
+func (v VestingSchedule) Locked(height uint32, timestamp int64) uint64 {
+	now := int64(height)
+	if v.ByTime {
+		now = timestamp
+	}
+
+	if now < v.Cliff {
+		return v.Amount
+	}
+	if now >= v.End {
+		return 0
+	}
+
+	// Amount * elapsed can overflow 64 bits, the quotient can't.
+	hi, lo := bits.Mul64(v.Amount, uint64(now-v.Start))
+	vested, _ := bits.Div64(hi, lo, uint64(v.End-v.Start))
+
+	return v.Amount - vested
+}
+
+// VestingTx transfers the amount of the schedule from the sender to
+// Beneficiary, where it stays locked until it vests.
+type VestingTx struct {
+	Beneficiary types.Address
+	Schedule    VestingSchedule
+}
+
+// VestingBalance splits the amount granted through vesting schedules.
+type VestingBalance struct {
+	Vested uint64
+	Locked uint64
+}
+
+func (bc *Blockchain) handleVesting(tx *Transaction, vesting VestingTx) error {
+	if err := vesting.Schedule.Validate(); err != nil {
+		return err
+	}
+
+	return bc.accountState.TransferVesting(tx.Sender(), vesting.Beneficiary, vesting.Schedule)
+}
+
+// GetVestingBalances returns the vested and locked amount per asset of the
+// given address as of the latest block.
+func (bc *Blockchain) GetVestingBalances(address types.Address) (map[AssetID]VestingBalance, error) {
+	return bc.accountState.GetVestingBalances(address)
+}
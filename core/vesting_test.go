@@ -0,0 +1,118 @@
+package core
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+)
+
+func TestVestingScheduleLocked(t *testing.T) {
+	v := VestingSchedule{Amount: 1000, Start: 10, Cliff: 20, End: 110}
+
+	assert.Equal(t, uint64(1000), v.Locked(0, 0))
+	assert.Equal(t, uint64(1000), v.Locked(19, 0))
+	assert.Equal(t, uint64(900), v.Locked(20, 0))
+	assert.Equal(t, uint64(500), v.Locked(60, 0))
+	assert.Equal(t, uint64(0), v.Locked(110, 0))
+
+	v.ByTime = true
+	assert.Equal(t, uint64(500), v.Locked(0, 60))
+}
+
+func TestGenesisVestingLocksTransfer(t *testing.T) {
+	team := crypto.GeneratePrivateKey()
+	genesis, err := GenesisConfig{
+		Supply: 1000,
+		Vesting: []VestingTx{{
+			Beneficiary: team.PublicKey().Address(),
+			Schedule:    VestingSchedule{Amount: 100, Cliff: 2, End: 4},
+		}},
+	}.Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	vesting, err := bc.GetVestingBalances(team.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, VestingBalance{Locked: 100}, vesting[NativeAsset])
+
+	newTransfer := func(value uint64) *Transaction {
+		tx := &Transaction{To: crypto.GeneratePrivateKey().PublicKey(), Value: value}
+		assert.Nil(t, tx.Sign(team))
+		return tx
+	}
+
+	validator := crypto.GeneratePrivateKey()
+	b := nextBlock(t, bc, validator, []*Transaction{newTransfer(1)})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	// half of it vested at height 2
+	b = nextBlock(t, bc, validator, []*Transaction{newTransfer(51)})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 0, appliedTxs(t, bc, b))
+
+	b = nextBlock(t, bc, validator, []*Transaction{newTransfer(50)})
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, 1, appliedTxs(t, bc, b))
+
+	vesting, err = bc.GetVestingBalances(team.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, VestingBalance{Vested: 75, Locked: 25}, vesting[NativeAsset])
+}
+
+func TestVestedSchedulesDropped(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	beneficiary := crypto.GeneratePrivateKey().PublicKey().Address()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 100)
+
+	newVesting := func(schedule VestingSchedule) *Transaction {
+		tx := &Transaction{TxInner: VestingTx{Beneficiary: beneficiary, Schedule: schedule}}
+		assert.Nil(t, tx.Sign(sender))
+		return tx
+	}
+	vested := VestingSchedule{Amount: 10, End: 2}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{newVesting(vested)})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, nil)))
+
+	locked := VestingSchedule{Amount: 20, End: 10}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, validator, []*Transaction{newVesting(locked)})))
+	assert.Equal(t, []VestingSchedule{locked}, bc.accountState.accounts[beneficiary].Vesting)
+
+	d, err := bc.GetStateDiff(3)
+	assert.Nil(t, err)
+	for _, a := range d.Accounts {
+		if a.Address == beneficiary {
+			assert.Equal(t, []VestingSchedule{vested}, a.OldVesting)
+			assert.Equal(t, []VestingSchedule{locked}, a.Vesting)
+		}
+	}
+
+	bc.revertTo(2)
+	assert.Equal(t, []VestingSchedule{vested}, bc.accountState.accounts[beneficiary].Vesting)
+}
+
+func TestVestingSchedulesCapped(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sender, validator := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	beneficiary := crypto.GeneratePrivateKey().PublicKey().Address()
+	bc.accountState.Mint(sender.PublicKey().Address(), NativeAsset, 1000)
+
+	txs := []*Transaction{}
+	for i := 0; i <= MaxVestingSchedules; i++ {
+		tx := &Transaction{TxInner: VestingTx{
+			Beneficiary: beneficiary,
+			Schedule:    VestingSchedule{Amount: uint64(i + 1), End: 10},
+		}}
+		assert.Nil(t, tx.Sign(sender))
+		txs = append(txs, tx)
+	}
+	b := nextBlock(t, bc, validator, txs)
+	assert.Nil(t, bc.AddBlock(b))
+
+	assert.Equal(t, MaxVestingSchedules, appliedTxs(t, bc, b))
+	assert.Len(t, bc.accountState.accounts[beneficiary].Vesting, MaxVestingSchedules)
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sharkchain/crypto"
+	"sharkchain/types"
+)
+
+// VRFEngine elects the producers of a block by their VRF. Time is split
+// into slots of one block time after the parent block, in every slot a
+// validator is elected with a chance proportional to its power, so one is
+// elected per slot on average. Nobody can tell who is elected before the
+// elected validator reveals its proof in the block.
+type VRFEngine struct {
+	SignerEngine
+}
+
+func (e *VRFEngine) Prepare(bc *Blockchain, h *Header, signer crypto.Signer) error {
+	if err := e.SignerEngine.Prepare(bc, h, signer); err != nil {
+		return err
+	}
+	prover, ok := signer.(crypto.VRFProver)
+	if !ok {
+		return fmt.Errorf("signer of (%s) can't evaluate the VRF", signer.PublicKey().Address())
+	}
+
+	parent, err := bc.GetHeader(h.Height - 1)
+	if err != nil {
+		return err
+	}
+	slot, err := e.slot(parent, h)
+	if err != nil {
+		return err
+	}
+
+	alpha := vrfInput(parent, slot)
+	proof, err := prover.ProveVRF(alpha)
+	if err != nil {
+		return err
+	}
+	output, err := crypto.VerifyVRF(signer.PublicKey(), alpha, proof)
+	if err != nil {
+		return err
+	}
+	if !e.elected(signer.PublicKey().Address(), output) {
+		return fmt.Errorf("%w: not elected in slot (%d) of block (%d)", ErrNotProposer, slot, h.Height)
+	}
+
+	h.VRFProof = proof
+
+	return nil
+}
+
+func (e *VRFEngine) VerifySeal(bc *Blockchain, b *Block) error {
+	if err := e.SignerEngine.VerifySeal(bc, b); err != nil {
+		return err
+	}
+
+	parent, err := bc.GetHeader(b.Height - 1)
+	if err != nil {
+		return err
+	}
+	slot, err := e.slot(parent, b.Header)
+	if err != nil {
+		return err
+	}
+
+	output, err := crypto.VerifyVRF(b.Validator, vrfInput(parent, slot), b.VRFProof)
+	if err != nil {
+		return fmt.Errorf("block (%d): %w", b.Height, err)
+	}
+	if !e.elected(b.Validator.Address(), output) {
+		return fmt.Errorf("block (%d) signed by (%s) which is not elected in slot (%d)", b.Height, b.Validator.Address(), slot)
+	}
+
+	return nil
+}
+
+// slot returns the number of block times between the parent and the header.
+func (e *VRFEngine) slot(parent, h *Header) (uint64, error) {
+	if h.Timestamp <= parent.Timestamp {
+		return 0, fmt.Errorf("block (%d) timestamp (%d) is not after its parent's (%d)", h.Height, h.Timestamp, parent.Timestamp)
+	}
+
+	return uint64((h.Timestamp - parent.Timestamp) / int64(e.params.BlockTime)), nil
+}
+
+// elected reports whether output/2^256 is below the share of the validator in
+// the total power.
+func (e *VRFEngine) elected(address types.Address, output types.Hash) bool {
+	power := e.params.Power(address)
+	if power == 0 {
+		return false
+	}
+
+	lhs := new(big.Int).SetBytes(output.ToSlice())
+	lhs.Mul(lhs, new(big.Int).SetUint64(e.params.TotalPower()))
+	rhs := new(big.Int).Lsh(new(big.Int).SetUint64(power), 256)
+
+	return lhs.Cmp(rhs) < 0
+}
+
+// Randomness returns the randomness a header contributes to the election of
+// the next block: its VRF output, or its hash when it has no proof.
+func Randomness(h *Header) types.Hash {
+	if len(h.VRFProof) == 0 {
+		return BlockHasher{}.Hash(h)
+	}
+
+	return crypto.VRFOutput(h.VRFProof)
+}
+
+func vrfInput(parent *Header, slot uint64) []byte {
+	randomness := Randomness(parent)
+	return binary.LittleEndian.AppendUint64(randomness.ToSlice(), slot)
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/crypto"
+	"testing"
+	"time"
+)
+
+func newVRFBlockchain(t *testing.T, n int) (*Blockchain, []crypto.PrivateKey) {
+	bc, validators := newBlockchainWithValidators(t, n)
+	setParams(t, bc, func(p *Params) { p.Consensus = ConsensusVRF })
+
+	return bc, validators
+}
+
+// electedBlock returns the block of the first slot a validator is elected
+// in, along with a validator that is not elected in that slot if any.
+func electedBlock(t *testing.T, bc *Blockchain, validators []crypto.PrivateKey) (*Block, crypto.PrivateKey) {
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	blockTime := bc.Params().BlockTime
+
+	for slot := int64(0); slot < 100; slot++ {
+		var (
+			elected    *Block
+			notElected crypto.PrivateKey
+		)
+		for _, v := range validators {
+			b, err := NewBlockFromPrevHeader(prevHeader, nil)
+			assert.Nil(t, err)
+			b.Timestamp = prevHeader.Timestamp + slot*int64(blockTime) + 1
+
+			err = bc.Engine().Prepare(bc, b.Header, v)
+			if errors.Is(err, ErrNotProposer) {
+				notElected = v
+				continue
+			}
+			assert.Nil(t, err)
+			if elected == nil {
+				assert.Nil(t, b.Sign(v))
+				elected = b
+			}
+		}
+		if elected != nil {
+			return elected, notElected
+		}
+	}
+
+	t.Fatal("no validator elected in 100 slots")
+	return nil, crypto.PrivateKey{}
+}
+
+func TestVRFElection(t *testing.T) {
+	bc, validators := newVRFBlockchain(t, 3)
+
+	for i := 0; i < 5; i++ {
+		b, _ := electedBlock(t, bc, validators)
+		assert.Len(t, b.VRFProof, crypto.VRFProofLen)
+		assert.Nil(t, bc.AddBlock(b))
+	}
+	assert.Equal(t, uint32(5), bc.Height())
+}
+
+func TestVRFBlockNotElected(t *testing.T) {
+	bc, validators := newVRFBlockchain(t, 3)
+
+	// look for a slot someone is not elected in
+	for i := 0; i < 20; i++ {
+		b, notElected := electedBlock(t, bc, validators)
+		if notElected == (crypto.PrivateKey{}) {
+			assert.Nil(t, bc.AddBlock(b))
+			continue
+		}
+
+		// a valid proof of somebody not elected
+		prevHeader, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		slot := uint64((b.Timestamp - prevHeader.Timestamp) / int64(bc.Params().BlockTime))
+		proof, err := notElected.ProveVRF(vrfInput(prevHeader, slot))
+		assert.Nil(t, err)
+		forged := &Block{Header: &Header{}}
+		*forged.Header = *b.Header
+		forged.VRFProof = proof
+		assert.Nil(t, forged.Sign(notElected))
+		assert.NotNil(t, bc.AddBlock(forged))
+
+		// the proof of the elected validator is no use to others either
+		stolen := &Block{Header: &Header{}}
+		*stolen.Header = *b.Header
+		assert.Nil(t, stolen.Sign(notElected))
+		assert.NotNil(t, bc.AddBlock(stolen))
+
+		assert.Nil(t, bc.AddBlock(b))
+		return
+	}
+
+	t.Fatal("every validator elected in every slot")
+}
+
+func TestVRFBlockFromTheFuture(t *testing.T) {
+	bc, validators := newVRFBlockchain(t, 3)
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	blockTime := int64(bc.Params().BlockTime)
+
+	// trying slot after slot ahead of time until elected
+	future := time.Now().Add(time.Hour).UnixNano()
+	for slot := int64(0); slot < 100; slot++ {
+		for _, v := range validators {
+			b, err := NewBlockFromPrevHeader(prevHeader, nil)
+			assert.Nil(t, err)
+			b.Timestamp = future + slot*blockTime
+			if errors.Is(bc.Engine().Prepare(bc, b.Header, v), ErrNotProposer) {
+				continue
+			}
+			assert.Nil(t, b.Sign(v))
+
+			assert.ErrorIs(t, bc.AddBlock(b), ErrFutureBlock)
+			return
+		}
+	}
+
+	t.Fatal("no validator elected in 100 slots")
+}
+
+func TestVRFProofOnlyUnderVRF(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	b := nextBlock(t, bc, privKey, nil)
+	proof, err := privKey.ProveVRF([]byte("slot"))
+	assert.Nil(t, err)
+	b.VRFProof = proof
+	assert.Nil(t, b.Sign(privKey))
+
+	assert.NotNil(t, bc.AddBlock(b))
+}
@@ -6,6 +6,8 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"math/big"
 	"sharkchain/types"
@@ -27,6 +29,13 @@ func (k PrivateKey) Sign(data []byte) (*Signature, error) {
 	}, nil
 }
 
+// Signer signs with a key it does not have to expose, e.g. one held by a
+// separate process. PrivateKey is the Signer holding the key in memory.
+type Signer interface {
+	PublicKey() PublicKey
+	Sign(data []byte) (*Signature, error)
+}
+
 func GeneratePrivateKey() PrivateKey {
 	return NewPrivateKeyFromReader(rand.Reader)
 }
@@ -42,6 +51,33 @@ func NewPrivateKeyFromReader(r io.Reader) PrivateKey {
 	}
 }
 
+// NewPrivateKeyFromBytes restores a key returned by PrivateKey.Bytes.
+func NewPrivateKeyFromBytes(b []byte) (PrivateKey, error) {
+	if len(b) != 32 {
+		return PrivateKey{}, fmt.Errorf("private key has %d bytes instead of 32", len(b))
+	}
+
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(b)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return PrivateKey{}, errors.New("private key is out of range")
+	}
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(b)
+
+	return PrivateKey{
+		key: key,
+	}, nil
+}
+
+// Bytes returns the secret scalar of the key, it must never leave the
+// process holding the key.
+func (k PrivateKey) Bytes() []byte {
+	return k.key.D.FillBytes(make([]byte, 32))
+}
+
 func (k PrivateKey) PublicKey() PublicKey {
 	return elliptic.MarshalCompressed(k.key.PublicKey, k.key.PublicKey.X, k.key.PublicKey.Y)
 }
@@ -58,6 +94,16 @@ type PublicKey []byte
 //	return b
 //}
 
+// Validate checks that the key is a compressed point of the curve, keys
+// received from others have to be checked before they are kept.
+func (k PublicKey) Validate() error {
+	if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), k); x == nil {
+		return fmt.Errorf("public key %x is not a compressed P-256 point", []byte(k))
+	}
+
+	return nil
+}
+
 func (k PublicKey) Address() types.Address {
 	h := sha256.Sum256(k)
 
@@ -73,8 +119,13 @@ func (sig Signature) String() string {
 	return hex.EncodeToString(b)
 }
 
+// Verify reports whether the signature of the data was made by the key, an
+// invalid key or an incomplete signature never verifies.
 func (sig Signature) Verify(pubKey PublicKey, data []byte) bool {
 	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+	if x == nil || sig.R == nil || sig.S == nil {
+		return false
+	}
 	key := &ecdsa.PublicKey{
 		Curve: elliptic.P256(),
 		X:     x,
@@ -27,3 +27,35 @@ func TestPrivateKey_Sign(t *testing.T) {
 	b := sig.Verify(pubKey, msg)
 	assert.True(t, b)
 }
+
+func TestPrivateKeyFromBytes(t *testing.T) {
+	privKey := GeneratePrivateKey()
+
+	restored, err := NewPrivateKeyFromBytes(privKey.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.PublicKey(), restored.PublicKey())
+
+	msg := []byte("hello world")
+	sig, err := restored.Sign(msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(privKey.PublicKey(), msg))
+
+	_, err = NewPrivateKeyFromBytes(make([]byte, 32))
+	assert.NotNil(t, err)
+	_, err = NewPrivateKeyFromBytes([]byte{1})
+	assert.NotNil(t, err)
+}
+
+func TestVerifyWithInvalidKey(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := []byte("hello world")
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+
+	assert.Nil(t, privKey.PublicKey().Validate())
+	for _, key := range []PublicKey{nil, {0x02}, make(PublicKey, 33)} {
+		assert.NotNil(t, key.Validate())
+		assert.False(t, sig.Verify(key, msg))
+	}
+	assert.False(t, Signature{}.Verify(privKey.PublicKey(), msg))
+}
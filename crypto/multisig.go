@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sharkchain/types"
+)
+
+// MultisigKey is a set of public keys of which at least Threshold have to
+// sign. The order of the keys matters, signatures refer to keys by index.
+type MultisigKey struct {
+	Threshold uint8
+	Keys      []PublicKey
+}
+
+// KeySignature is a signature made by the key at Index of a MultisigKey.
+type KeySignature struct {
+	Index     uint8
+	Signature *Signature
+}
+
+func (m MultisigKey) Validate() error {
+	if len(m.Keys) == 0 || len(m.Keys) > 255 {
+		return fmt.Errorf("multisig needs between 1 and 255 keys, got %d", len(m.Keys))
+	}
+
+	if m.Threshold == 0 || int(m.Threshold) > len(m.Keys) {
+		return fmt.Errorf("multisig threshold %d out of range for %d keys", m.Threshold, len(m.Keys))
+	}
+
+	seen := make(map[string]bool, len(m.Keys))
+	for _, key := range m.Keys {
+		if err := key.Validate(); err != nil {
+			return err
+		}
+		if seen[string(key)] {
+			return fmt.Errorf("multisig key %x listed twice", []byte(key))
+		}
+		seen[string(key)] = true
+	}
+
+	return nil
+}
+
+// Bytes returns the threshold followed by the length prefixed keys.
+func (m MultisigKey) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(m.Threshold)
+	for _, key := range m.Keys {
+		buf.WriteByte(byte(len(key)))
+		buf.Write(key)
+	}
+
+	return buf.Bytes()
+}
+
+// Address derives the address of a multisig account from its initial set of keys.
+func (m MultisigKey) Address() types.Address {
+	h := sha256.Sum256(m.Bytes())
+
+	return types.AddressFromBytes(h[len(h)-20:])
+}
+
+func (m MultisigKey) Equal(other MultisigKey) bool {
+	return bytes.Equal(m.Bytes(), other.Bytes())
+}
+
+// Verify checks that at least Threshold distinct keys signed data.
+func (m MultisigKey) Verify(sigs []KeySignature, data []byte) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	signed := make(map[uint8]bool, len(sigs))
+	for _, sig := range sigs {
+		if int(sig.Index) >= len(m.Keys) {
+			return fmt.Errorf("signature refers to unknown key %d", sig.Index)
+		}
+		if sig.Signature == nil || !sig.Signature.Verify(m.Keys[sig.Index], data) {
+			return fmt.Errorf("invalid signature of key %d", sig.Index)
+		}
+		signed[sig.Index] = true
+	}
+
+	if len(signed) < int(m.Threshold) {
+		return fmt.Errorf("got %d of %d required signatures", len(signed), m.Threshold)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"sharkchain/types"
+)
+
+// The VRF follows ECVRF-P256-SHA256-TAI of RFC 9381, except for the nonce
+// which is derived from the key and the hashed input by SHA-256.
+const (
+	vrfSuite = 0x01
+	// vrfChallengeLen is the length of the challenge in bytes.
+	vrfChallengeLen = 16
+	// VRFProofLen is the length of a proof: Gamma, the challenge and s.
+	VRFProofLen = 33 + vrfChallengeLen + 32
+)
+
+var ErrInvalidVRFProof = errors.New("invalid VRF proof")
+
+// VRFProver evaluates the verifiable random function of a key. PrivateKey
+// is one, a Signer holding the key elsewhere may be one too.
+type VRFProver interface {
+	ProveVRF(alpha []byte) ([]byte, error)
+}
+
+// ProveVRF returns the proof of the VRF output for alpha, the output itself
+// is returned by VerifyVRF.
+func (k PrivateKey) ProveVRF(alpha []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	x := k.key.D.FillBytes(make([]byte, 32))
+
+	hx, hy, err := vrfHashToCurve(k.PublicKey(), alpha)
+	if err != nil {
+		return nil, err
+	}
+	gx, gy := curve.ScalarMult(hx, hy, x)
+
+	// the nonce is secret and unique per key and input
+	nonce := sha256.Sum256(append(x, elliptic.MarshalCompressed(curve, hx, hy)...))
+	kn := new(big.Int).Mod(new(big.Int).SetBytes(nonce[:]), n)
+	if kn.Sign() == 0 {
+		return nil, errors.New("VRF nonce is zero")
+	}
+	ux, uy := curve.ScalarBaseMult(kn.Bytes())
+	vx, vy := curve.ScalarMult(hx, hy, kn.Bytes())
+
+	c := vrfChallenge(k.PublicKey(), hx, hy, gx, gy, ux, uy, vx, vy)
+	s := new(big.Int).Mul(c, k.key.D)
+	s.Add(s, kn).Mod(s, n)
+
+	proof := elliptic.MarshalCompressed(curve, gx, gy)
+	proof = append(proof, c.FillBytes(make([]byte, vrfChallengeLen))...)
+	proof = append(proof, s.FillBytes(make([]byte, 32))...)
+
+	return proof, nil
+}
+
+// VerifyVRF checks the proof of the VRF of the key for alpha and returns its
+// output.
+func VerifyVRF(pubKey PublicKey, alpha, proof []byte) (types.Hash, error) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	if len(proof) != VRFProofLen {
+		return types.Hash{}, ErrInvalidVRFProof
+	}
+	yx, yy := elliptic.UnmarshalCompressed(curve, pubKey)
+	gx, gy := elliptic.UnmarshalCompressed(curve, proof[:33])
+	if yx == nil || gx == nil {
+		return types.Hash{}, ErrInvalidVRFProof
+	}
+	c := new(big.Int).SetBytes(proof[33 : 33+vrfChallengeLen])
+	s := new(big.Int).SetBytes(proof[33+vrfChallengeLen:])
+	if s.Cmp(n) >= 0 {
+		return types.Hash{}, ErrInvalidVRFProof
+	}
+
+	hx, hy, err := vrfHashToCurve(pubKey, alpha)
+	if err != nil {
+		return types.Hash{}, err
+	}
+
+	// U = s*B - c*Y and V = s*H - c*Gamma
+	negC := new(big.Int).Sub(n, c)
+	negC.Mod(negC, n)
+	ux, uy := curve.ScalarBaseMult(s.Bytes())
+	cyx, cyy := curve.ScalarMult(yx, yy, negC.Bytes())
+	ux, uy = curve.Add(ux, uy, cyx, cyy)
+	vx, vy := curve.ScalarMult(hx, hy, s.Bytes())
+	cgx, cgy := curve.ScalarMult(gx, gy, negC.Bytes())
+	vx, vy = curve.Add(vx, vy, cgx, cgy)
+
+	if vrfChallenge(pubKey, hx, hy, gx, gy, ux, uy, vx, vy).Cmp(c) != 0 {
+		return types.Hash{}, ErrInvalidVRFProof
+	}
+
+	return VRFOutput(proof), nil
+}
+
+// VRFOutput returns the output of a proof verified before.
+func VRFOutput(proof []byte) types.Hash {
+	if len(proof) != VRFProofLen {
+		return types.Hash{}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{vrfSuite, 0x03})
+	buf.Write(proof[:33])
+	buf.WriteByte(0x00)
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+// vrfHashToCurve maps the key and input to a point by try and increment.
+func vrfHashToCurve(pubKey PublicKey, alpha []byte) (*big.Int, *big.Int, error) {
+	curve := elliptic.P256()
+	for ctr := 0; ctr < 256; ctr++ {
+		buf := &bytes.Buffer{}
+		buf.Write([]byte{vrfSuite, 0x01})
+		buf.Write(pubKey)
+		buf.Write(alpha)
+		buf.Write([]byte{byte(ctr), 0x00})
+		hash := sha256.Sum256(buf.Bytes())
+
+		if x, y := elliptic.UnmarshalCompressed(curve, append([]byte{0x02}, hash[:]...)); x != nil {
+			return x, y, nil
+		}
+	}
+
+	return nil, nil, errors.New("could not hash VRF input to the curve")
+}
+
+func vrfChallenge(pubKey PublicKey, points ...*big.Int) *big.Int {
+	curve := elliptic.P256()
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{vrfSuite, 0x02})
+	buf.Write(pubKey)
+	for i := 0; i < len(points); i += 2 {
+		buf.Write(elliptic.MarshalCompressed(curve, points[i], points[i+1]))
+	}
+	buf.WriteByte(0x00)
+	hash := sha256.Sum256(buf.Bytes())
+
+	return new(big.Int).SetBytes(hash[:vrfChallengeLen])
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVRF(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	alpha := []byte("slot 1")
+
+	proof, err := privKey.ProveVRF(alpha)
+	assert.Nil(t, err)
+	assert.Len(t, proof, VRFProofLen)
+
+	output, err := VerifyVRF(privKey.PublicKey(), alpha, proof)
+	assert.Nil(t, err)
+
+	// the output only depends on the key and the input
+	again, err := privKey.ProveVRF(alpha)
+	assert.Nil(t, err)
+	againOutput, err := VerifyVRF(privKey.PublicKey(), alpha, again)
+	assert.Nil(t, err)
+	assert.Equal(t, output, againOutput)
+
+	other, err := privKey.ProveVRF([]byte("slot 2"))
+	assert.Nil(t, err)
+	otherOutput, err := VerifyVRF(privKey.PublicKey(), []byte("slot 2"), other)
+	assert.Nil(t, err)
+	assert.NotEqual(t, output, otherOutput)
+}
+
+func TestVerifyVRFInvalid(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	alpha := []byte("slot 1")
+	proof, err := privKey.ProveVRF(alpha)
+	assert.Nil(t, err)
+
+	_, err = VerifyVRF(privKey.PublicKey(), []byte("slot 2"), proof)
+	assert.ErrorIs(t, err, ErrInvalidVRFProof)
+	_, err = VerifyVRF(GeneratePrivateKey().PublicKey(), alpha, proof)
+	assert.ErrorIs(t, err, ErrInvalidVRFProof)
+	_, err = VerifyVRF(privKey.PublicKey(), alpha, proof[1:])
+	assert.ErrorIs(t, err, ErrInvalidVRFProof)
+
+	for _, i := range []int{1, 40, VRFProofLen - 1} {
+		tampered := append([]byte{}, proof...)
+		tampered[i] ^= 1
+		_, err = VerifyVRF(privKey.PublicKey(), alpha, tampered)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,516 @@
+package network
+
+import (
+	"errors"
+	"github.com/go-kit/log"
+	"sharkchain/core"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"time"
+)
+
+// BFTTimeouts are how long a validator waits in each step of a round before
+// moving on. Every round waits Delta longer than the one before.
+type BFTTimeouts struct {
+	Propose   time.Duration
+	Prevote   time.Duration
+	Precommit time.Duration
+	Delta     time.Duration
+}
+
+var DefaultBFTTimeouts = BFTTimeouts{
+	Propose:   3 * time.Second,
+	Prevote:   time.Second,
+	Precommit: time.Second,
+	Delta:     500 * time.Millisecond,
+}
+
+type BFTOpts struct {
+	Logger   log.Logger
+	Chain    *core.Blockchain
+	Signer   crypto.Signer
+	Timeouts BFTTimeouts
+	// Broadcast sends a *core.BlockProposal or *core.Vote to the other validators.
+	Broadcast func(msg any)
+	// NewBlock builds a signed block for the next height.
+	NewBlock func() (*core.Block, error)
+	// OnCommit is called with every block committed and added to the chain.
+	OnCommit func(*core.Block)
+}
+
+type roundStep byte
+
+const (
+	stepPropose roundStep = iota
+	stepPrevote
+	stepPrecommit
+)
+
+type bftTimeout struct {
+	height uint32
+	round  uint32
+	step   roundStep
+}
+
+// bftStart starts the first round of a height once the block time passed.
+type bftStart struct {
+	height uint32
+}
+
+// BFT runs the Tendermint style propose, prevote and precommit rounds of a
+// validator. A block is committed once more than 2/3 of the validators
+// precommitted it in the same round, their precommits become the commit
+// certificate of the block.
+//
+// All messages and timeouts are handled one at a time by the loop started
+// with Start.
+type BFT struct {
+	BFTOpts
+
+	msgCh  chan any
+	quitCh chan struct{}
+
+	height  uint32
+	round   uint32
+	step    roundStep
+	started bool
+	params  core.Params
+
+	// a validator locked on a block only prevotes for it, until more than
+	// 2/3 prevoted another block in a later round
+	lockedRound int32
+	lockedBlock *core.Block
+	validRound  int32
+	validBlock  *core.Block
+	// ownBlock is the block we built for this height, proposing it again in
+	// later rounds so we never sign two blocks at the same height
+	ownBlock *core.Block
+
+	proposals map[uint32]*core.BlockProposal
+	// invalid holds the rounds whose proposal failed validation
+	invalid map[uint32]bool
+	votes   map[uint32]map[core.VoteType]map[types.Address]*core.Vote
+	// future holds the messages for the next height until we get there
+	future []any
+
+	// each of these happens once per round
+	prevoteTimeout   bool
+	precommitTimeout bool
+	polSeen          bool
+}
+
+func NewBFT(opts BFTOpts) *BFT {
+	if opts.Timeouts == (BFTTimeouts{}) {
+		opts.Timeouts = DefaultBFTTimeouts
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+
+	return &BFT{
+		BFTOpts: opts,
+		msgCh:   make(chan any, 1024),
+		quitCh:  make(chan struct{}),
+	}
+}
+
+func (b *BFT) Start() {
+	b.newHeight()
+	b.startHeight()
+
+	go b.loop()
+}
+
+func (b *BFT) Stop() {
+	close(b.quitCh)
+}
+
+// Handle queues a *core.BlockProposal or *core.Vote received from another validator.
+func (b *BFT) Handle(msg any) {
+	select {
+	case b.msgCh <- msg:
+	case <-b.quitCh:
+	}
+}
+
+func (b *BFT) loop() {
+	for {
+		select {
+		case msg := <-b.msgCh:
+			// the chain moved on without us, e.g. by syncing committed blocks
+			if b.Chain.Height()+1 != b.height {
+				b.newHeight()
+				b.startHeight()
+			}
+
+			switch m := msg.(type) {
+			case *core.BlockProposal:
+				b.handleProposal(m)
+			case *core.Vote:
+				b.handleVote(m)
+			case bftTimeout:
+				b.handleTimeout(m)
+			case bftStart:
+				if m.height == b.height && !b.started {
+					b.startHeight()
+				}
+			}
+		case <-b.quitCh:
+			return
+		}
+	}
+}
+
+// newHeight resets the state for the block following the chain. Messages
+// are collected right away, the rounds only start with startHeight.
+func (b *BFT) newHeight() {
+	b.height = b.Chain.Height() + 1
+	b.params = b.Chain.Params()
+	b.round = 0
+	b.step = stepPropose
+	b.started = false
+	b.lockedRound, b.lockedBlock = -1, nil
+	b.validRound, b.validBlock = -1, nil
+	b.ownBlock = nil
+	b.proposals = make(map[uint32]*core.BlockProposal)
+	b.invalid = make(map[uint32]bool)
+	b.votes = make(map[uint32]map[core.VoteType]map[types.Address]*core.Vote)
+
+	future := b.future
+	b.future = nil
+	for _, msg := range future {
+		switch m := msg.(type) {
+		case *core.BlockProposal:
+			b.handleProposal(m)
+		case *core.Vote:
+			b.handleVote(m)
+		}
+	}
+}
+
+func (b *BFT) startHeight() {
+	b.started = true
+	b.startRound(0)
+}
+
+func (b *BFT) startRound(round uint32) {
+	b.round = round
+	b.step = stepPropose
+	b.prevoteTimeout = false
+	b.precommitTimeout = false
+	b.polSeen = false
+
+	b.schedule(b.Timeouts.Propose, stepPropose)
+
+	proposer := b.params.ProposerAt(b.height, round)
+	if proposer.Address() == b.Signer.PublicKey().Address() {
+		b.propose()
+	}
+
+	b.process()
+}
+
+func (b *BFT) propose() {
+	block, validRound := b.validBlock, b.validRound
+	if block == nil && b.ownBlock == nil {
+		var err error
+		if b.ownBlock, err = b.NewBlock(); err != nil {
+			b.Logger.Log("msg", "could not build block to propose", "height", b.height, "err", err)
+			return
+		}
+	}
+	if block == nil {
+		block = b.ownBlock
+	}
+
+	p := &core.BlockProposal{
+		Height:     b.height,
+		Round:      b.round,
+		ValidRound: validRound,
+		Block:      block,
+	}
+	if err := p.Sign(b.Signer); err != nil {
+		b.Logger.Log("msg", "could not sign proposal", "err", err)
+		return
+	}
+
+	b.Broadcast(p)
+	b.handleProposal(p)
+}
+
+func (b *BFT) handleProposal(p *core.BlockProposal) {
+	if p.Height != b.height {
+		if p.Height == b.height+1 {
+			b.future = append(b.future, p)
+		}
+		return
+	}
+	if _, ok := b.proposals[p.Round]; ok {
+		return
+	}
+
+	if proposer := b.params.ProposerAt(p.Height, p.Round); proposer.Address() != p.Proposer.Address() {
+		b.Logger.Log("msg", "proposal from wrong proposer", "height", p.Height, "round", p.Round, "from", p.Proposer.Address())
+		return
+	}
+	if err := p.Verify(); err != nil {
+		b.Logger.Log("msg", "invalid proposal", "height", p.Height, "round", p.Round, "err", err)
+		return
+	}
+
+	b.proposals[p.Round] = p
+	if err := b.Chain.ValidateProposal(p.Block); err != nil {
+		b.Logger.Log("msg", "proposed block is invalid", "height", p.Height, "round", p.Round, "err", err)
+		b.invalid[p.Round] = true
+	} else if !b.params.IsValidator(p.Block.Validator.Address()) {
+		b.Logger.Log("msg", "proposed block is not signed by a validator", "height", p.Height, "round", p.Round)
+		b.invalid[p.Round] = true
+	}
+
+	b.process()
+}
+
+func (b *BFT) handleVote(v *core.Vote) {
+	if v.Height != b.height {
+		if v.Height == b.height+1 {
+			b.future = append(b.future, v)
+		}
+		return
+	}
+
+	address := v.Validator.Address()
+	if b.params.Power(address) == 0 {
+		return
+	}
+	if v.Type != core.Prevote && v.Type != core.Precommit {
+		return
+	}
+
+	votes := b.votesOf(v.Round, v.Type)
+	// only the first vote counts, a second one would be a double sign
+	if _, ok := votes[address]; ok {
+		return
+	}
+	if err := v.Verify(); err != nil {
+		b.Logger.Log("msg", "invalid vote", "err", err)
+		return
+	}
+	votes[address] = v
+
+	b.process()
+}
+
+func (b *BFT) handleTimeout(t bftTimeout) {
+	if t.height != b.height || t.round != b.round {
+		return
+	}
+
+	switch t.step {
+	case stepPropose:
+		if b.step == stepPropose {
+			b.vote(core.Prevote, types.Hash{})
+		}
+	case stepPrevote:
+		if b.step == stepPrevote {
+			b.vote(core.Precommit, types.Hash{})
+		}
+	case stepPrecommit:
+		b.startRound(b.round + 1)
+	}
+}
+
+// process applies the rules of the algorithm to the messages collected so
+// far. Every rule that acts returns, the action leads to process again.
+func (b *BFT) process() {
+	if !b.started {
+		return
+	}
+
+	// a block precommitted by more than 2/3 in any round is committed
+	for round, p := range b.proposals {
+		if hash, ok := b.majority(round, core.Precommit); ok && hash == p.Block.Hash(core.BlockHasher{}) {
+			b.commit(p, round)
+			return
+		}
+	}
+
+	p := b.proposals[b.round]
+	valid := p != nil && !b.invalid[b.round]
+
+	if b.step == stepPropose && p != nil {
+		hash := p.Block.Hash(core.BlockHasher{})
+		if p.ValidRound < 0 {
+			if valid && (b.lockedRound < 0 || b.isLocked(hash)) {
+				b.vote(core.Prevote, hash)
+			} else {
+				b.vote(core.Prevote, types.Hash{})
+			}
+			return
+		}
+
+		vr := uint32(p.ValidRound)
+		if polHash, ok := b.majority(vr, core.Prevote); ok && polHash == hash && vr < b.round {
+			if valid && (b.lockedRound <= p.ValidRound || b.isLocked(hash)) {
+				b.vote(core.Prevote, hash)
+			} else {
+				b.vote(core.Prevote, types.Hash{})
+			}
+			return
+		}
+	}
+
+	if b.step == stepPrevote && !b.prevoteTimeout && b.hasQuorum(b.round, core.Prevote) {
+		b.prevoteTimeout = true
+		b.schedule(b.Timeouts.Prevote, stepPrevote)
+	}
+
+	if b.step >= stepPrevote && valid && !b.polSeen {
+		hash := p.Block.Hash(core.BlockHasher{})
+		if polHash, ok := b.majority(b.round, core.Prevote); ok && polHash == hash {
+			b.polSeen = true
+			b.validRound, b.validBlock = int32(b.round), p.Block
+			if b.step == stepPrevote {
+				b.lockedRound, b.lockedBlock = int32(b.round), p.Block
+				b.vote(core.Precommit, hash)
+				return
+			}
+		}
+	}
+
+	if b.step == stepPrevote {
+		if hash, ok := b.majority(b.round, core.Prevote); ok && hash.IsZero() {
+			b.vote(core.Precommit, types.Hash{})
+			return
+		}
+	}
+
+	if !b.precommitTimeout && b.hasQuorum(b.round, core.Precommit) {
+		b.precommitTimeout = true
+		b.schedule(b.Timeouts.Precommit, stepPrecommit)
+	}
+
+	// more than 1/3 of the validators are in a later round, catch up
+	for round := range b.votes {
+		if round > b.round && 3*b.votersPower(round) > b.params.TotalPower() {
+			b.startRound(round)
+			return
+		}
+	}
+}
+
+func (b *BFT) vote(t core.VoteType, hash types.Hash) {
+	if t == core.Prevote {
+		b.step = stepPrevote
+	} else {
+		b.step = stepPrecommit
+	}
+
+	v := &core.Vote{
+		Type:      t,
+		Height:    b.height,
+		Round:     b.round,
+		BlockHash: hash,
+	}
+	if err := v.Sign(b.Signer); err != nil {
+		b.Logger.Log("msg", "could not sign vote", "err", err)
+		return
+	}
+
+	b.Broadcast(v)
+	b.handleVote(v)
+}
+
+func (b *BFT) commit(p *core.BlockProposal, round uint32) {
+	block := p.Block
+	hash := block.Hash(core.BlockHasher{})
+
+	commit := &core.CommitCertificate{
+		Height:    b.height,
+		Round:     round,
+		BlockHash: hash,
+	}
+	for _, v := range b.votesOf(round, core.Precommit) {
+		if v.BlockHash == hash {
+			commit.Precommits = append(commit.Precommits, v)
+		}
+	}
+	block.Commit = commit
+
+	err := b.Chain.AddBlock(block)
+	if err != nil && !errors.Is(err, core.ErrBlockKnown) {
+		b.Logger.Log("msg", "could not add committed block", "height", b.height, "err", err)
+		return
+	}
+	if err == nil && b.OnCommit != nil {
+		b.OnCommit(block)
+	}
+
+	b.newHeight()
+
+	// leave the block time for transactions to come in
+	height := b.height
+	time.AfterFunc(b.params.BlockTime, func() { b.Handle(bftStart{height: height}) })
+}
+
+func (b *BFT) schedule(d time.Duration, step roundStep) {
+	t := bftTimeout{height: b.height, round: b.round, step: step}
+	d += time.Duration(b.round) * b.Timeouts.Delta
+
+	time.AfterFunc(d, func() { b.Handle(t) })
+}
+
+func (b *BFT) votesOf(round uint32, t core.VoteType) map[types.Address]*core.Vote {
+	if b.votes[round] == nil {
+		b.votes[round] = make(map[core.VoteType]map[types.Address]*core.Vote)
+	}
+	if b.votes[round][t] == nil {
+		b.votes[round][t] = make(map[types.Address]*core.Vote)
+	}
+
+	return b.votes[round][t]
+}
+
+// votersPower returns the power of the validators which sent any vote in the round.
+func (b *BFT) votersPower(round uint32) uint64 {
+	voters := make(map[types.Address]bool)
+	for _, votes := range b.votes[round] {
+		for address := range votes {
+			voters[address] = true
+		}
+	}
+
+	power := uint64(0)
+	for address := range voters {
+		power += b.params.Power(address)
+	}
+
+	return power
+}
+
+// majority returns the block hash more than 2/3 voted for, the zero hash
+// when they voted for no block.
+func (b *BFT) majority(round uint32, t core.VoteType) (types.Hash, bool) {
+	power := make(map[types.Hash]uint64)
+	for address, v := range b.votesOf(round, t) {
+		power[v.BlockHash] += b.params.Power(address)
+		if core.HasQuorum(power[v.BlockHash], b.params.TotalPower()) {
+			return v.BlockHash, true
+		}
+	}
+
+	return types.Hash{}, false
+}
+
+// hasQuorum reports whether more than 2/3 voted in the round, for any block.
+func (b *BFT) hasQuorum(round uint32, t core.VoteType) bool {
+	power := uint64(0)
+	for address := range b.votesOf(round, t) {
+		power += b.params.Power(address)
+	}
+
+	return core.HasQuorum(power, b.params.TotalPower())
+}
+
+func (b *BFT) isLocked(hash types.Hash) bool {
+	return b.lockedBlock != nil && b.lockedBlock.Hash(core.BlockHasher{}) == hash
+}
@@ -0,0 +1,147 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"sharkchain/core"
+	"sharkchain/crypto"
+	"testing"
+	"time"
+)
+
+var testBFTTimeouts = BFTTimeouts{
+	Propose:   100 * time.Millisecond,
+	Prevote:   50 * time.Millisecond,
+	Precommit: 50 * time.Millisecond,
+	Delta:     20 * time.Millisecond,
+}
+
+// newBFTNetwork connects n validators which exchange their messages
+// directly. Only the first running of them take part.
+func newBFTNetwork(t *testing.T, n, running int) []*BFT {
+	keys := make([]crypto.PrivateKey, n)
+	params := core.DefaultParams
+	params.Consensus = core.ConsensusBFT
+	params.BlockTime = 10 * time.Millisecond
+	for i := range keys {
+		keys[i] = crypto.GeneratePrivateKey()
+		params.Validators = append(params.Validators, keys[i].PublicKey())
+	}
+
+	genesis, err := core.GenesisConfig{Params: &params}.Block()
+	assert.Nil(t, err)
+
+	nodes := make([]*BFT, running)
+	for i := range nodes {
+		chain, err := core.NewBlockchain(log.NewNopLogger(), genesis)
+		assert.Nil(t, err)
+
+		key := keys[i]
+		nodes[i] = NewBFT(BFTOpts{
+			Chain:    chain,
+			Signer:   key,
+			Timeouts: testBFTTimeouts,
+			NewBlock: func() (*core.Block, error) {
+				prevHeader, err := chain.GetHeader(chain.Height())
+				if err != nil {
+					return nil, err
+				}
+				b, err := core.NewBlockFromPrevHeader(prevHeader, nil)
+				if err != nil {
+					return nil, err
+				}
+
+				return b, b.Sign(key)
+			},
+		})
+	}
+
+	for i := range nodes {
+		from := i
+		nodes[i].Broadcast = func(msg any) {
+			for j, node := range nodes {
+				if j != from {
+					// every node decodes its own copy, as if sent over the wire
+					go node.Handle(copyMessage(t, msg))
+				}
+			}
+		}
+	}
+
+	return nodes
+}
+
+func copyMessage(t *testing.T, msg any) any {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(msg))
+
+	switch msg.(type) {
+	case *core.BlockProposal:
+		p := new(core.BlockProposal)
+		assert.Nil(t, gob.NewDecoder(buf).Decode(p))
+		return p
+	default:
+		v := new(core.Vote)
+		assert.Nil(t, gob.NewDecoder(buf).Decode(v))
+		return v
+	}
+}
+
+func waitForHeight(t *testing.T, nodes []*BFT, height uint32) {
+	deadline := time.Now().Add(10 * time.Second)
+	for _, node := range nodes {
+		for node.Chain.Height() < height {
+			if time.Now().After(deadline) {
+				t.Fatalf("no block (%d) committed in time, at height (%d)", height, node.Chain.Height())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func assertSameChain(t *testing.T, nodes []*BFT, height uint32) {
+	for h := uint32(1); h <= height; h++ {
+		first, err := nodes[0].Chain.GetBlock(h)
+		assert.Nil(t, err)
+		assert.NotNil(t, first.Commit)
+
+		for _, node := range nodes[1:] {
+			b, err := node.Chain.GetBlock(h)
+			assert.Nil(t, err)
+			assert.Equal(t, first.Hash(core.BlockHasher{}), b.Hash(core.BlockHasher{}))
+		}
+	}
+
+	for _, node := range nodes {
+		assert.Equal(t, node.Chain.Height(), node.Chain.FinalizedHeight())
+	}
+}
+
+func TestBFTCommitsBlocks(t *testing.T) {
+	nodes := newBFTNetwork(t, 4, 4)
+	for _, node := range nodes {
+		node.Start()
+		defer node.Stop()
+	}
+
+	waitForHeight(t, nodes, 3)
+	assertSameChain(t, nodes, 3)
+}
+
+func TestBFTSkipsOfflineProposer(t *testing.T) {
+	// the fourth validator never proposes, its heights need a second round
+	nodes := newBFTNetwork(t, 4, 3)
+	for _, node := range nodes {
+		node.Start()
+		defer node.Stop()
+	}
+
+	waitForHeight(t, nodes, 4)
+	assertSameChain(t, nodes, 4)
+
+	b, err := nodes[0].Chain.GetBlock(3)
+	assert.Nil(t, err)
+	assert.NotEqual(t, uint32(0), b.Commit.Round)
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"sharkchain/core"
+	"sharkchain/types"
+	"sync"
+)
+
+// EvidencePool holds the double sign evidence waiting to be included in a
+// block.
+type EvidencePool struct {
+	mu       sync.RWMutex
+	evidence map[types.Hash]*core.DoubleSignEvidence
+}
+
+func NewEvidencePool() *EvidencePool {
+	return &EvidencePool{
+		evidence: make(map[types.Hash]*core.DoubleSignEvidence),
+	}
+}
+
+// Add reports whether the evidence was not in the pool yet.
+func (p *EvidencePool) Add(e *core.DoubleSignEvidence) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hash := e.Hash()
+	if _, ok := p.evidence[hash]; ok {
+		return false
+	}
+	p.evidence[hash] = e
+
+	return true
+}
+
+func (p *EvidencePool) Contains(hash types.Hash) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.evidence[hash]
+	return ok
+}
+
+func (p *EvidencePool) Pending() []*core.DoubleSignEvidence {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	evidence := make([]*core.DoubleSignEvidence, 0, len(p.evidence))
+	for _, e := range p.evidence {
+		evidence = append(evidence, e)
+	}
+
+	return evidence
+}
+
+// Prune removes the evidence the check fails for, e.g. because its offender
+// has been punished in the meantime.
+func (p *EvidencePool) Prune(check func(*core.DoubleSignEvidence) error) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for hash, e := range p.evidence {
+		if check(e) != nil {
+			delete(p.evidence, hash)
+			n++
+		}
+	}
+
+	return n
+}
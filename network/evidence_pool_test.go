@@ -0,0 +1,51 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/core"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"testing"
+)
+
+func randomEvidence(t *testing.T) *core.DoubleSignEvidence {
+	privKey := crypto.GeneratePrivateKey()
+	a, err := core.NewBlock(&core.Header{Height: 1, DataHash: types.RandomHash()}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, a.Sign(privKey))
+	b, err := core.NewBlock(&core.Header{Height: 1, DataHash: types.RandomHash()}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	e, err := core.NewDoubleSignEvidence(a, b)
+	assert.Nil(t, err)
+
+	return e
+}
+
+func TestEvidencePoolAdd(t *testing.T) {
+	p := NewEvidencePool()
+	e := randomEvidence(t)
+
+	assert.True(t, p.Add(e))
+	assert.False(t, p.Add(e))
+	assert.True(t, p.Contains(e.Hash()))
+	assert.Len(t, p.Pending(), 1)
+}
+
+func TestEvidencePoolPrune(t *testing.T) {
+	p := NewEvidencePool()
+	punished := randomEvidence(t)
+	p.Add(punished)
+	p.Add(randomEvidence(t))
+
+	n := p.Prune(func(e *core.DoubleSignEvidence) error {
+		if e == punished {
+			return core.ErrEvidenceKnown
+		}
+		return nil
+	})
+	assert.Equal(t, 1, n)
+	assert.False(t, p.Contains(punished.Hash()))
+	assert.Len(t, p.Pending(), 1)
+}
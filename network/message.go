@@ -1,6 +1,10 @@
 package network
 
-import "sharkchain/core"
+import (
+	"math/big"
+	"sharkchain/core"
+	"sharkchain/types"
+)
 
 type GetBlocksMessage struct {
 	From uint32
@@ -19,4 +23,26 @@ type StatusMessage struct {
 	ID            string
 	Version       uint32
 	CurrentHeight uint32
+	// TotalWork of the chain of the server, the fork choice prefers the
+	// chain with the most work.
+	TotalWork *big.Int
+	// Snapshots are the heights of the snapshots the server serves.
+	Snapshots []uint32
+}
+
+// GetSnapshotMessage requests a chunk of the snapshot taken at the height.
+type GetSnapshotMessage struct {
+	Height uint32
+	Index  uint32
+}
+
+// SnapshotChunkMessage carries a chunk of an encoded snapshot, the snapshot
+// is the concatenation of its chunks in order.
+type SnapshotChunkMessage struct {
+	Height    uint32
+	BlockHash types.Hash
+	StateRoot types.Hash
+	Index     uint32
+	Total     uint32
+	Data      []byte
 }
@@ -0,0 +1,170 @@
+package network
+
+import (
+	"net"
+	"sharkchain/core"
+	"sharkchain/types"
+	"slices"
+	"sync"
+)
+
+const (
+	// MaxOrphanDistance is how far above our last block an orphan may be,
+	// the blocks further ahead are synced in order from the peers.
+	MaxOrphanDistance = 64
+	// maxOrphansPerPeer is the share of the orphan pool a peer may hold.
+	maxOrphansPerPeer = 10
+	// orphanDifficultyDrop is how far below the current difficulty a mined
+	// orphan may be, the difficulty adjusts while the chain grows.
+	orphanDifficultyDrop = 16
+)
+
+// peerHost identifies a peer by its IP, the port of a connection changes
+// whenever the peer reconnects. The peers sharing an IP share its identity.
+func peerHost(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+
+	return addr.String()
+}
+
+// OrphanPool buffers blocks whose parent is not known yet, until their
+// ancestors arrive. When full the oldest block is evicted, a peer can't
+// hold more than its share of the pool though.
+type OrphanPool struct {
+	mu         sync.Mutex
+	maxLength  int
+	maxPerPeer int
+	blocks     map[types.Hash]*core.Block
+	// children holds the hashes of the orphans by the hash of their parent
+	children map[types.Hash][]types.Hash
+	// order holds the hashes in the order they were added
+	order []types.Hash
+	// senders holds the host of the peer each orphan came from, perPeer
+	// how many orphans every host has in the pool
+	senders map[types.Hash]string
+	perPeer map[string]int
+}
+
+func NewOrphanPool(maxLength, maxPerPeer int) *OrphanPool {
+	return &OrphanPool{
+		maxLength:  maxLength,
+		maxPerPeer: maxPerPeer,
+		blocks:     make(map[types.Hash]*core.Block),
+		children:   make(map[types.Hash][]types.Hash),
+		senders:    make(map[types.Hash]string),
+		perPeer:    make(map[string]int),
+	}
+}
+
+// Add keeps the block received from the given peer, it reports false when
+// the block is in the pool already or the host of the peer has its share of
+// orphans.
+func (p *OrphanPool) Add(from net.Addr, b *core.Block) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hash := b.Hash(core.BlockHasher{})
+	if _, ok := p.blocks[hash]; ok {
+		return false
+	}
+	host := peerHost(from)
+	if p.perPeer[host] >= p.maxPerPeer {
+		return false
+	}
+	if len(p.order) == p.maxLength {
+		p.remove(p.order[0])
+	}
+
+	p.blocks[hash] = b
+	p.children[b.PrevBlockHash] = append(p.children[b.PrevBlockHash], hash)
+	p.order = append(p.order, hash)
+	p.senders[hash] = host
+	p.perPeer[host]++
+
+	return true
+}
+
+func (p *OrphanPool) Contains(hash types.Hash) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.blocks[hash]
+	return ok
+}
+
+func (p *OrphanPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.blocks)
+}
+
+// Root returns the oldest ancestor of the block in the pool, its parent is
+// the block missing to connect them.
+func (p *OrphanPool) Root(b *core.Block) *core.Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		parent, ok := p.blocks[b.PrevBlockHash]
+		if !ok {
+			return b
+		}
+		b = parent
+	}
+}
+
+// TakeChildren removes the blocks building on the given one from the pool
+// and returns them.
+func (p *OrphanPool) TakeChildren(parent types.Hash) []*core.Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	children := []*core.Block{}
+	for _, hash := range slices.Clone(p.children[parent]) {
+		children = append(children, p.blocks[hash])
+		p.remove(hash)
+	}
+
+	return children
+}
+
+// Prune removes the blocks up to the given height, e.g. the finalized one,
+// as they can't connect anymore. It returns the number of removed blocks.
+func (p *OrphanPool) Prune(height uint32) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for hash, b := range p.blocks {
+		if b.Height <= height {
+			p.remove(hash)
+			n++
+		}
+	}
+
+	return n
+}
+
+func (p *OrphanPool) remove(hash types.Hash) {
+	b := p.blocks[hash]
+	delete(p.blocks, hash)
+
+	siblings := slices.DeleteFunc(p.children[b.PrevBlockHash], func(h types.Hash) bool { return h == hash })
+	if len(siblings) == 0 {
+		delete(p.children, b.PrevBlockHash)
+	} else {
+		p.children[b.PrevBlockHash] = siblings
+	}
+
+	p.order = slices.DeleteFunc(p.order, func(h types.Hash) bool { return h == hash })
+
+	from := p.senders[hash]
+	delete(p.senders, hash)
+	p.perPeer[from]--
+	if p.perPeer[from] == 0 {
+		delete(p.perPeer, from)
+	}
+}
@@ -0,0 +1,72 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sharkchain/core"
+	"sharkchain/types"
+	"testing"
+)
+
+func randomOrphan(t *testing.T, height uint32, prevBlockHash types.Hash) *core.Block {
+	b, err := core.NewBlock(&core.Header{Height: height, PrevBlockHash: prevBlockHash, DataHash: types.RandomHash()}, nil)
+	assert.Nil(t, err)
+
+	return b
+}
+
+func TestOrphanPoolConnect(t *testing.T) {
+	p := NewOrphanPool(10, 10)
+	peer := &net.TCPAddr{Port: 3000}
+	missing := types.RandomHash()
+	a := randomOrphan(t, 3, missing)
+	b := randomOrphan(t, 4, a.Hash(core.BlockHasher{}))
+	c := randomOrphan(t, 4, a.Hash(core.BlockHasher{}))
+
+	assert.True(t, p.Add(peer, b))
+	assert.True(t, p.Add(peer, a))
+	assert.True(t, p.Add(peer, c))
+	assert.False(t, p.Add(peer, c))
+	assert.Equal(t, a, p.Root(b))
+
+	assert.Empty(t, p.TakeChildren(types.RandomHash()))
+	assert.Equal(t, []*core.Block{a}, p.TakeChildren(missing))
+	assert.ElementsMatch(t, []*core.Block{b, c}, p.TakeChildren(a.Hash(core.BlockHasher{})))
+	assert.Equal(t, 0, p.Len())
+}
+
+func TestOrphanPoolMaxLength(t *testing.T) {
+	p := NewOrphanPool(2, 2)
+	peer := &net.TCPAddr{Port: 3000}
+	oldest := randomOrphan(t, 1, types.RandomHash())
+	p.Add(peer, oldest)
+	p.Add(peer, randomOrphan(t, 2, types.RandomHash()))
+	p.Add(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3000}, randomOrphan(t, 3, types.RandomHash()))
+
+	assert.Equal(t, 2, p.Len())
+	assert.False(t, p.Contains(oldest.Hash(core.BlockHasher{})))
+	assert.Empty(t, p.TakeChildren(oldest.PrevBlockHash))
+
+	assert.Equal(t, 1, p.Prune(2))
+	assert.Equal(t, 1, p.Len())
+}
+
+func TestOrphanPoolPerPeer(t *testing.T) {
+	p := NewOrphanPool(10, 2)
+	peer := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3000}
+	other := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3000}
+
+	first := randomOrphan(t, 1, types.RandomHash())
+	assert.True(t, p.Add(peer, first))
+	assert.True(t, p.Add(peer, randomOrphan(t, 2, types.RandomHash())))
+	assert.False(t, p.Add(peer, randomOrphan(t, 3, types.RandomHash())))
+	// reconnecting from another port doesn't renew the share
+	reconnected := &net.TCPAddr{IP: peer.IP, Port: 4000}
+	assert.False(t, p.Add(reconnected, randomOrphan(t, 3, types.RandomHash())))
+	assert.True(t, p.Add(other, randomOrphan(t, 3, types.RandomHash())))
+	assert.Equal(t, 3, p.Len())
+
+	// a connected orphan makes room for another one
+	assert.Len(t, p.TakeChildren(first.PrevBlockHash), 1)
+	assert.True(t, p.Add(peer, randomOrphan(t, 3, types.RandomHash())))
+}
@@ -14,12 +14,18 @@ import (
 type MessageType byte
 
 const (
-	MessageTypeTx        MessageType = 0x1
-	MessageTypeBlock     MessageType = 0x2
-	MessageTypeGetBlocks MessageType = 0x3
-	MessageTypeStatus    MessageType = 0x4
-	MessageTypeGetStatus MessageType = 0x5
-	MessageTypeBlocks    MessageType = 0x6
+	MessageTypeTx            MessageType = 0x1
+	MessageTypeBlock         MessageType = 0x2
+	MessageTypeGetBlocks     MessageType = 0x3
+	MessageTypeStatus        MessageType = 0x4
+	MessageTypeGetStatus     MessageType = 0x5
+	MessageTypeBlocks        MessageType = 0x6
+	MessageTypeProposal      MessageType = 0x7
+	MessageTypePrevote       MessageType = 0x8
+	MessageTypePrecommit     MessageType = 0x9
+	MessageTypeEvidence      MessageType = 0xa
+	MessageTypeGetSnapshot   MessageType = 0xb
+	MessageTypeSnapshotChunk MessageType = 0xc
 )
 
 type RPC struct {
@@ -126,6 +132,64 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 			Data: blocks,
 		}, nil
 
+	case MessageTypeProposal:
+		proposal := new(core.BlockProposal)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(proposal); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: proposal,
+		}, nil
+
+	case MessageTypePrevote, MessageTypePrecommit:
+		vote := new(core.Vote)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(vote); err != nil {
+			return nil, err
+		}
+		if (msg.Header == MessageTypePrevote) != (vote.Type == core.Prevote) {
+			return nil, fmt.Errorf("message %x carries a %s", msg.Header, vote.Type)
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: vote,
+		}, nil
+
+	case MessageTypeEvidence:
+		evidence := new(core.DoubleSignEvidence)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(evidence); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: evidence,
+		}, nil
+
+	case MessageTypeGetSnapshot:
+		getSnapshot := new(GetSnapshotMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getSnapshot); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getSnapshot,
+		}, nil
+
+	case MessageTypeSnapshotChunk:
+		chunk := new(SnapshotChunkMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(chunk); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: chunk,
+		}, nil
+
 	default:
 		return nil, fmt.Errorf("invalid message header %x", msg.Header)
 	}
@@ -8,15 +8,16 @@ import (
 	"github.com/go-kit/log"
 	"net"
 	"os"
+	"sharkchain/api"
 	"sharkchain/core"
 	"sharkchain/crypto"
+	"sharkchain/signer"
 	"sharkchain/types"
+	"slices"
 	"sync"
 	"time"
 )
 
-var defaultBlockTime = 5 * time.Second
-
 type ServerOpts struct {
 	APIListenAddr string
 	SeedNodes     []string
@@ -25,8 +26,29 @@ type ServerOpts struct {
 
 	ID         string
 	Logger     log.Logger
-	BlockTime  time.Duration
 	PrivateKey *crypto.PrivateKey
+	// Signer signs the blocks of a validator instead of PrivateKey, e.g. a
+	// *signer.RemoteSigner keeping the key out of the node.
+	Signer crypto.Signer
+	// Genesis defaults to core.DefaultGenesis when nil. The block time and
+	// the other chain parameters are read from the chain state.
+	Genesis *core.GenesisConfig
+	// ForkSchedule defaults to core.DefaultForkSchedule when nil.
+	ForkSchedule core.ForkSchedule
+	// Checkpoints are trusted, peers serving a chain conflicting with them
+	// are not synced from.
+	Checkpoints []core.Checkpoint
+	// FastSync starts the node from the snapshot of the latest checkpoint
+	// trusted with a state root, only the blocks after it are executed. A
+	// validator can't fast sync.
+	FastSync bool
+	// DataDir holds the files of the node, the sign state of a validator
+	// among them. A validator requires it, it keeps a restarted validator
+	// from signing a block at a height it already signed.
+	DataDir string
+	// BFTTimeouts are used when the chain runs BFT consensus, they default
+	// to DefaultBFTTimeouts.
+	BFTTimeouts BFTTimeouts
 
 	RPCDecodeFunc RPCDecodeFunc
 	RPCProcessor  RPCProcessor
@@ -36,13 +58,24 @@ type Server struct {
 	TCPTransport *TCPTransport
 	peerCh       chan *TCPPeer // used to initialize network by async connections
 	peerMap      map[net.Addr]*TCPPeer
+	// refused are the peers that served blocks conflicting with a checkpoint
+	refused map[net.Addr]bool
 
 	mu sync.RWMutex
 
 	ServerOpts
-	memPool     *TxPool
+	memPool  *TxPool
+	evidence *EvidencePool
+	orphans  *OrphanPool
+	// snapshotSync downloads the snapshot to start from, nil when not
+	// fast syncing or once restored.
+	snapshotSync *SnapshotSync
+	// served holds the chunks of the snapshot last served to a peer
+	served      *servedSnapshot
 	chain       *core.Blockchain
 	isValidator bool // depends on weather has private key
+	// bft runs the consensus rounds when the chain uses BFT and we validate
+	bft *BFT
 
 	rpcCh  chan RPC
 	quitCh chan struct{}
@@ -50,22 +83,62 @@ type Server struct {
 }
 
 func NewServer(opts ServerOpts) (*Server, error) {
-	if opts.BlockTime == time.Duration(0) {
-		opts.BlockTime = defaultBlockTime
-	}
 	if opts.RPCDecodeFunc == nil {
 		opts.RPCDecodeFunc = DefaultRPCDecodeFunc
 	}
+	if opts.Genesis == nil {
+		opts.Genesis = &core.DefaultGenesis
+	}
+	if opts.ForkSchedule == nil {
+		opts.ForkSchedule = core.DefaultForkSchedule
+	}
 	if opts.Logger == nil {
 		opts.Logger = log.NewLogfmtLogger(os.Stderr)
 		opts.Logger = log.With(opts.Logger, "addr", opts.ID)
 	}
+	if opts.Signer == nil && opts.PrivateKey != nil {
+		opts.Signer = *opts.PrivateKey
+	}
+	if opts.Signer != nil {
+		if opts.DataDir == "" {
+			return nil, errors.New("a validator requires a data directory for its sign state")
+		}
+		guard, err := signer.OpenSignGuard(opts.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		opts.Signer = signer.NewGuardedSigner(opts.Signer, guard)
+	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock())
+	genesis, err := genesisBlock(*opts.Genesis)
 	if err != nil {
 		return nil, err
 	}
 
+	chain, err := core.NewBlockchain(opts.Logger, genesis)
+	if err != nil {
+		return nil, err
+	}
+	if err := chain.SetForkSchedule(opts.ForkSchedule); err != nil {
+		return nil, err
+	}
+	if err := chain.SetCheckpoints(opts.Checkpoints); err != nil {
+		return nil, err
+	}
+
+	var snapshotSync *SnapshotSync
+	if opts.FastSync {
+		if opts.Signer != nil {
+			return nil, errors.New("a validator can't fast sync")
+		}
+
+		c, ok := latestStateRoot(opts.Checkpoints)
+		if !ok {
+			return nil, errors.New("fast sync needs a checkpoint trusted with a state root")
+		}
+		snapshotSync = NewSnapshotSync(c)
+	}
+
 	// Channel being used to communicate between the JSON RPC server
 	// and the node that will process this message.
 	// ? why use a noncached channel
@@ -75,14 +148,14 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	// Only boot up the API server if the config has a valid port number.
 	// TODO this should be put to server.Start()
 	if len(opts.APIListenAddr) > 0 {
-		//apiServerCfg := api.ServerConfig{
-		//	Logger:     opts.Logger,
-		//	ListenAddr: opts.APIListenAddr,
-		//}
-		//apiServer := api.NewServer(apiServerCfg, chain, txChan)
-		//go apiServer.Start()
-		//
-		//opts.Logger.Log("msg", "JSON API server running", "port", opts.APIListenAddr)
+		apiServerCfg := api.ServerConfig{
+			Logger:     opts.Logger,
+			ListenAddr: opts.APIListenAddr,
+		}
+		apiServer := api.NewServer(apiServerCfg, chain, txChan)
+		go apiServer.Start()
+
+		opts.Logger.Log("msg", "JSON API server running", "port", opts.APIListenAddr)
 	}
 
 	peerCh := make(chan *TCPPeer)
@@ -92,10 +165,14 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		TCPTransport: tr,
 		peerCh:       peerCh,
 		peerMap:      make(map[net.Addr]*TCPPeer),
+		refused:      make(map[net.Addr]bool),
 		ServerOpts:   opts,
 		chain:        chain,
 		memPool:      NewTxPool(1000),
-		isValidator:  opts.PrivateKey != nil,
+		evidence:     NewEvidencePool(),
+		orphans:      NewOrphanPool(100, maxOrphansPerPeer),
+		snapshotSync: snapshotSync,
+		isValidator:  opts.Signer != nil,
 		rpcCh:        make(chan RPC),
 		quitCh:       make(chan struct{}, 1),
 		txChan:       txChan,
@@ -106,6 +183,19 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	if s.RPCProcessor == nil {
 		s.RPCProcessor = s
 	}
+	chain.SetReorgHandler(s.onReorg)
+
+	if s.isValidator && chain.Params().Consensus == core.ConsensusBFT {
+		s.bft = NewBFT(BFTOpts{
+			Logger:    opts.Logger,
+			Chain:     chain,
+			Signer:    opts.Signer,
+			Timeouts:  opts.BFTTimeouts,
+			Broadcast: s.broadcastConsensus,
+			NewBlock:  s.newBlock,
+			OnCommit:  s.onCommit,
+		})
+	}
 
 	return s, nil
 }
@@ -136,10 +226,15 @@ func (s *Server) Start() {
 	s.bootstrapNetwork()
 	s.Logger.Log("msg", "accepting TCP connection on", "addr", s.ListenAddr, "id", s.ID)
 
-	if s.isValidator {
+	if s.bft != nil {
+		s.bft.Start()
+	} else if s.isValidator {
 		go s.validatorLoop()
 	}
 
+	snapshotTicker := time.NewTicker(SnapshotTimeout / 2)
+	defer snapshotTicker.Stop()
+
 free:
 	for {
 		select {
@@ -175,6 +270,9 @@ free:
 					s.Logger.Log("error", err)
 				}
 			}
+		case now := <-snapshotTicker.C:
+			s.checkSnapshotSync(now)
+
 		case <-s.quitCh:
 			break free
 		default:
@@ -185,18 +283,19 @@ free:
 }
 
 func (s *Server) validatorLoop() {
-	ticker := time.NewTicker(s.BlockTime)
-
-	s.Logger.Log("msg", "Starting validator loop", "blockTime", s.BlockTime)
+	s.Logger.Log("msg", "Starting validator loop", "blockTime", s.chain.Params().BlockTime)
 
 	for {
-		fmt.Println("creating new block")
+		// the block time can be changed by governance at any height
+		timer := time.NewTimer(s.chain.Params().BlockTime)
 
-		if err := s.createNewBlock(); err != nil {
+		if err := s.createNewBlock(); errors.Is(err, core.ErrNotProposer) || errors.Is(err, signer.ErrDoubleSign) {
+			s.Logger.Log("msg", "skipping block", "reason", err)
+		} else if err != nil {
 			s.Logger.Log("create block error", err)
 		}
 
-		<-ticker.C
+		<-timer.C
 	}
 }
 
@@ -207,7 +306,7 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 	case *core.Transaction:
 		return s.processTransaction(t)
 	case *core.Block:
-		return s.processBlock(t)
+		return s.processBlock(msg.From, t)
 	case *GetStatusMessage:
 		return s.processGetStatusMessage(msg.From, t)
 	case *StatusMessage:
@@ -216,6 +315,16 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 		return s.processGetBlocksMessage(msg.From, t)
 	case *BlocksMessage:
 		return s.processBlocksMessage(msg.From, t)
+	case *core.DoubleSignEvidence:
+		return s.processEvidence(t)
+	case *GetSnapshotMessage:
+		return s.processGetSnapshotMessage(msg.From, t)
+	case *SnapshotChunkMessage:
+		return s.processSnapshotChunkMessage(msg.From, t)
+	case *core.BlockProposal, *core.Vote:
+		if s.bft != nil {
+			s.bft.Handle(t)
+		}
 	}
 
 	return nil
@@ -229,15 +338,17 @@ func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage)
 		ourHeight = s.chain.Height()
 	)
 
-	if data.To == 0 {
-		for i := int(data.From); i <= int(ourHeight); i++ {
-			block, err := s.chain.GetBlock(uint32(i))
-			if err != nil {
-				return err
-			}
-
-			blocks = append(blocks, block)
+	to := ourHeight
+	if data.To != 0 && data.To < to {
+		to = data.To
+	}
+	for i := int(data.From); i <= int(to); i++ {
+		block, err := s.chain.GetBlock(uint32(i))
+		if err != nil {
+			return err
 		}
+
+		blocks = append(blocks, block)
 	}
 
 	blocksMsg := &BlocksMessage{
@@ -275,17 +386,28 @@ func (s *Server) sendGetStatusMessage(peer *TCPPeer) error {
 }
 
 func (s *Server) processTransaction(tx *core.Transaction) error {
-	fmt.Printf("processing transaction from %s\n", tx.From.Address().String())
+	fmt.Printf("processing transaction from %s\n", tx.Sender().String())
 
 	if err := tx.Verify(); err != nil {
 		return err
 	}
 
+	if tx.Expired(s.chain.Height()+1, time.Now().UnixNano()) {
+		return core.ErrTxExpired
+	}
+
+	if minFee := s.chain.Params().MinFee; tx.Fee < minFee {
+		return fmt.Errorf("tx fee (%d) is below the minimum fee (%d)", tx.Fee, minFee)
+	}
+
 	hash := tx.Hash(core.TxHasher{})
 
 	if s.memPool.Contains(hash) {
 		return nil
 	}
+	if s.chain.HasTx(hash) {
+		return fmt.Errorf("tx (%s) is already part of the chain", hash)
+	}
 
 	// TODO need to broadcast
 	go s.broadcastTx(tx)
@@ -317,6 +439,93 @@ func (s *Server) broadcastBlock(b *core.Block) error {
 	return s.broadcast(msg.Bytes())
 }
 
+// broadcastConsensus sends a *core.BlockProposal or *core.Vote of the BFT rounds.
+func (s *Server) broadcastConsensus(msg any) {
+	var msgType MessageType
+	switch m := msg.(type) {
+	case *core.BlockProposal:
+		msgType = MessageTypeProposal
+	case *core.Vote:
+		msgType = MessageTypePrevote
+		if m.Type == core.Precommit {
+			msgType = MessageTypePrecommit
+		}
+	default:
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(msg); err != nil {
+		s.Logger.Log("msg", "could not encode consensus message", "err", err)
+		return
+	}
+
+	go s.broadcast(NewMessage(msgType, buf.Bytes()).Bytes())
+}
+
+// onCommit is called by the BFT rounds for every block they committed.
+func (s *Server) onCommit(b *core.Block) {
+	s.memPool.RemovePending(b.Transactions)
+	s.memPool.Prune(b.Height+1, b.Timestamp)
+	s.evidence.Prune(s.chain.CheckEvidence)
+
+	go s.broadcastBlock(b)
+}
+
+// onReorg returns the transactions of the reverted blocks the new chain does
+// not include to the pool, and the evidence they carried.
+func (s *Server) onReorg(removed, added []*core.Block) {
+	for _, b := range added {
+		s.memPool.RemovePending(b.Transactions)
+	}
+
+	for _, b := range removed {
+		txx := []*core.Transaction{}
+		for _, tx := range b.Transactions {
+			if !s.chain.HasTx(tx.Hash(core.TxHasher{})) {
+				txx = append(txx, tx)
+			}
+		}
+		s.memPool.Restore(txx)
+
+		for _, e := range b.Evidence {
+			if s.chain.CheckEvidence(e) == nil {
+				s.evidence.Add(e)
+			}
+		}
+	}
+}
+
+// processEvidence keeps valid evidence for the next block we produce and
+// passes it on to the peers.
+func (s *Server) processEvidence(e *core.DoubleSignEvidence) error {
+	if s.evidence.Contains(e.Hash()) {
+		return nil
+	}
+	if err := s.chain.CheckEvidence(e); err != nil {
+		return err
+	}
+
+	s.Logger.Log("msg", "double sign detected", "validator", e.Offender().Address(), "height", e.Height())
+
+	if s.evidence.Add(e) {
+		go s.broadcastEvidence(e)
+	}
+
+	return nil
+}
+
+func (s *Server) broadcastEvidence(e *core.DoubleSignEvidence) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(e); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeEvidence, buf.Bytes())
+
+	return s.broadcast(msg.Bytes())
+}
+
 func (s *Server) broadcastTx(tx *core.Transaction) error {
 	buf := &bytes.Buffer{}
 	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
@@ -332,7 +541,11 @@ func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error
 	s.Logger.Log("msg", "received BLOCKS!!!!!!!!", "from", from)
 
 	for _, block := range data.Blocks {
-		if err := s.chain.AddBlock(block); err != nil {
+		err := s.addBlock(from, block)
+		if errors.Is(err, core.ErrCheckpointMismatch) {
+			s.refusePeer(from)
+		}
+		if err != nil && !errors.Is(err, core.ErrBlockKnown) && !errors.Is(err, core.ErrUnknownParent) {
 			s.Logger.Log("error", err.Error())
 			return err
 		}
@@ -344,6 +557,30 @@ func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error
 func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error {
 	s.Logger.Log("msg", "received STATUS message", "from", from)
 
+	if s.isRefused(from) {
+		s.Logger.Log("msg", "cannot sync, peer conflicts with a checkpoint", "addr", from)
+		return nil
+	}
+
+	// the blocks are synced once the snapshot is restored
+	if s.snapshotSync != nil {
+		height := s.snapshotSync.Checkpoint().Height
+		if !slices.Contains(data.Snapshots, height) {
+			s.Logger.Log("msg", "cannot fast sync, peer has no snapshot", "height", height, "addr", from)
+			return nil
+		}
+		if s.snapshotSync.Start(from) {
+			return s.sendGetSnapshot(from, height, 0)
+		}
+		return nil
+	}
+
+	// follow the chain with the most work, peers not reporting it are
+	// compared by height
+	if data.TotalWork != nil && data.TotalWork.Cmp(s.chain.TotalWork()) <= 0 {
+		s.Logger.Log("msg", "cannot sync, not more work", "ourWork", s.chain.TotalWork(), "theirWork", data.TotalWork, "addr", from)
+		return nil
+	}
 	if data.CurrentHeight <= s.chain.Height() {
 		s.Logger.Log("msg", "cannot sync blockHeight to low", "ourHeight", s.chain.Height(), "theirHeight", data.CurrentHeight, "addr", from)
 		return nil
@@ -361,36 +598,191 @@ func (s *Server) requestBlocksLoop(peer net.Addr) error {
 	ticker := time.NewTicker(3 * time.Second)
 
 	for {
+		if s.isRefused(peer) {
+			return nil
+		}
 		ourHeight := s.chain.Height()
 
 		s.Logger.Log("msg", "requesting new blocks", "requesting height", ourHeight+1)
 
 		// In this case we are 100% sure that the node has blocks heigher than us.
-		getBlocksMessage := &GetBlocksMessage{
-			From: ourHeight + 1,
-			To:   0,
+		if err := s.sendGetBlocks(peer, ourHeight+1, 0); err != nil {
+			return err
 		}
 
-		buf := new(bytes.Buffer)
-		if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
+		<-ticker.C
+	}
+}
+
+// refusePeer stops syncing from a peer serving a chain that conflicts with
+// a checkpoint.
+func (s *Server) refusePeer(addr net.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refused[addr] = true
+	s.Logger.Log("msg", "peer conflicts with a checkpoint", "addr", addr)
+}
+
+func (s *Server) isRefused(addr net.Addr) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.refused[addr]
+}
+
+// sendGetBlocks requests the blocks of the given heights from the peer, to
+// its last block when to is 0.
+func (s *Server) sendGetBlocks(addr net.Addr, from, to uint32) error {
+	getBlocksMessage := &GetBlocksMessage{
+		From: from,
+		To:   to,
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
+	peer, ok := s.peerMap[addr]
+	if !ok {
+		return fmt.Errorf("peer %s not known", addr)
+	}
+
+	if err := peer.Send(msg.Bytes()); err != nil {
+		s.Logger.Log("error", "failed to send to peer", "err", err, "peer", peer)
+	}
+
+	return nil
+}
+
+// servedSnapshot holds the encoded chunks of a snapshot, encoding the
+// snapshot for every chunk requested would take too long.
+type servedSnapshot struct {
+	hash   types.Hash
+	root   types.Hash
+	chunks [][]byte
+}
+
+func (s *Server) processGetSnapshotMessage(from net.Addr, data *GetSnapshotMessage) error {
+	s.Logger.Log("msg", "received getSnapshot message", "from", from, "height", data.Height, "index", data.Index)
+
+	snapshot, err := s.chain.GetSnapshot(data.Height)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	served := s.served
+	if served == nil || served.hash != snapshot.BlockHash || served.root != snapshot.StateRoot {
+		chunks, err := snapshotChunks(snapshot)
+		if err != nil {
+			s.mu.Unlock()
 			return err
 		}
+		served = &servedSnapshot{hash: snapshot.BlockHash, root: snapshot.StateRoot, chunks: chunks}
+		s.served = served
+	}
+	s.mu.Unlock()
 
-		s.mu.RLock()
-		defer s.mu.RUnlock()
+	if int(data.Index) >= len(served.chunks) {
+		return fmt.Errorf("snapshot (%d) has no chunk (%d)", data.Height, data.Index)
+	}
 
-		msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
-		peer, ok := s.peerMap[peer]
-		if !ok {
-			return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
-		}
+	return s.send(from, MessageTypeSnapshotChunk, &SnapshotChunkMessage{
+		Height:    snapshot.Height,
+		BlockHash: snapshot.BlockHash,
+		StateRoot: snapshot.StateRoot,
+		Index:     data.Index,
+		Total:     uint32(len(served.chunks)),
+		Data:      served.chunks[data.Index],
+	})
+}
 
-		if err := peer.Send(msg.Bytes()); err != nil {
-			s.Logger.Log("error", "failed to send to peer", "err", err, "peer", peer)
+// processSnapshotChunkMessage keeps the chunk and requests the next one.
+// Once all arrived the chain is restored from the snapshot and the blocks
+// after it are synced from the peer.
+func (s *Server) processSnapshotChunkMessage(from net.Addr, data *SnapshotChunkMessage) error {
+	if s.snapshotSync == nil {
+		return nil
+	}
+
+	next, done, err := s.snapshotSync.Add(from, data)
+	if err != nil {
+		return err
+	}
+	if !done {
+		return s.sendGetSnapshot(from, data.Height, next)
+	}
+
+	snapshot, err := s.snapshotSync.Snapshot()
+	if err == nil {
+		err = s.chain.RestoreSnapshot(snapshot)
+	}
+	if err != nil {
+		s.snapshotSync.Reset()
+		s.refusePeer(from)
+		return err
+	}
+	s.snapshotSync = nil
+
+	go s.requestBlocksLoop(from)
+
+	return nil
+}
+
+// checkSnapshotSync moves the snapshot download on to another peer when the
+// current one stalls, by asking the others for their status again.
+func (s *Server) checkSnapshotSync(now time.Time) {
+	if s.snapshotSync == nil {
+		return
+	}
+	stalled, ok := s.snapshotSync.Timeout(now)
+	if !ok {
+		return
+	}
+	s.Logger.Log("msg", "snapshot download timed out", "addr", stalled)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for addr, peer := range s.peerMap {
+		if addr.String() == stalled.String() {
+			continue
+		}
+		if err := s.sendGetStatusMessage(peer); err != nil {
+			s.Logger.Log("err", err)
 		}
+	}
+}
 
-		<-ticker.C
+func (s *Server) sendGetSnapshot(addr net.Addr, height, index uint32) error {
+	return s.send(addr, MessageTypeGetSnapshot, &GetSnapshotMessage{
+		Height: height,
+		Index:  index,
+	})
+}
+
+// send encodes the message and sends it to the peer.
+func (s *Server) send(addr net.Addr, msgType MessageType, data any) error {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(data); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peer, ok := s.peerMap[addr]
+	if !ok {
+		return fmt.Errorf("peer %s not known", addr)
 	}
+
+	return peer.Send(NewMessage(msgType, buf.Bytes()).Bytes())
 }
 
 func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage) error {
@@ -398,7 +790,13 @@ func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage)
 
 	statusMessage := &StatusMessage{
 		CurrentHeight: s.chain.Height(),
+		Version:       s.chain.VersionAt(s.chain.Height()),
+		TotalWork:     s.chain.TotalWork(),
 		ID:            s.ID,
+		Snapshots:     []uint32{},
+	}
+	for _, c := range s.chain.Snapshots() {
+		statusMessage.Snapshots = append(statusMessage.Snapshots, c.Height)
 	}
 
 	buf := new(bytes.Buffer)
@@ -419,68 +817,238 @@ func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage)
 	return peer.Send(msg.Bytes())
 }
 
-func (s *Server) processBlock(b *core.Block) error {
-	if err := s.chain.AddBlock(b); err != nil {
-		s.Logger.Log("error", err.Error())
+func (s *Server) processBlock(from net.Addr, b *core.Block) error {
+	// a different block for a height we have may be signed by the same
+	// validator
+	if e, ok := s.chain.DetectDoubleSign(b); ok {
+		if err := s.processEvidence(e); err != nil {
+			s.Logger.Log("error", err.Error())
+		}
+	}
+
+	if err := s.addBlock(from, b); err != nil {
+		// an orphan waits for its parent
+		if errors.Is(err, core.ErrUnknownParent) {
+			return nil
+		}
+		if !errors.Is(err, core.ErrBlockKnown) {
+			s.Logger.Log("error", err.Error())
+		}
 		return err
 	}
 
+	s.memPool.Prune(b.Height+1, b.Timestamp)
+	s.evidence.Prune(s.chain.CheckEvidence)
+
 	go s.broadcastBlock(b)
 
 	return nil
 }
 
-func (s *Server) createNewBlock() error {
-	fmt.Println("creating a new block")
-
-	currentHeader, err := s.chain.GetHeader(s.chain.Height())
+// addBlock adds the block to the chain along with the orphans building on
+// it. A block whose parent is unknown becomes an orphan itself and its
+// missing ancestors are requested from the peer that sent it.
+func (s *Server) addBlock(from net.Addr, b *core.Block) error {
+	err := s.chain.AddBlock(b)
+	if errors.Is(err, core.ErrUnknownParent) {
+		// the parents of an orphan get requested, only for blocks of a
+		// possible producer close enough to our last one
+		if err := b.VerifySignature(); err != nil {
+			return err
+		}
+		if err := s.checkOrphanProducer(b); err != nil {
+			return err
+		}
+		if height := s.chain.Height(); b.Height > height+MaxOrphanDistance {
+			return fmt.Errorf("orphan block (%d) is more than (%d) blocks above our last one (%d)", b.Height, MaxOrphanDistance, height)
+		}
+		if s.orphans.Add(from, b) {
+			s.requestParent(from, b)
+		}
+		return err
+	}
 	if err != nil {
 		return err
 	}
 
-	// For now we are going to use all transactions that are in the pending pool
-	// Later on when we know the internal structure of our transaction
-	// we will implement some kind of complexity function to determine how
-	// many transactions can be included in a block.
-	txx := s.memPool.Pending()
+	s.connectOrphans(b)
+	s.orphans.Prune(s.chain.FinalizedHeight())
 
-	block, err := core.NewBlockFromPrevHeader(currentHeader, txx)
-	if err != nil {
-		return err
+	return nil
+}
+
+// checkOrphanProducer refuses an orphan no producer of our chain could have
+// made. Its parent is unknown, so is the validator set at its height, the
+// current one is the best guess. Under PoW the block has to meet a
+// difficulty of at least a fraction of the current one.
+func (s *Server) checkOrphanProducer(b *core.Block) error {
+	params := s.chain.Params()
+	switch {
+	case params.Consensus == core.ConsensusPoW:
+		if !core.MeetsDifficulty(b.Header) || b.Difficulty < s.chain.NextDifficulty()/orphanDifficultyDrop {
+			return fmt.Errorf("orphan block (%d) does not meet the difficulty of the chain", b.Height)
+		}
+	case len(params.Validators) > 0:
+		if !params.IsValidator(b.Validator.Address()) {
+			return fmt.Errorf("orphan block (%d) is signed by (%s) which is not a validator", b.Height, b.Validator.Address())
+		}
+	}
+
+	return nil
+}
+
+// requestParent asks the peer for the blocks missing to connect the orphan:
+// the ones between our last block and the orphans, or when those are not
+// above our last block the parent of the oldest of them.
+func (s *Server) requestParent(from net.Addr, b *core.Block) {
+	root := s.orphans.Root(b)
+	if root.Height == 0 || root.Height-1 <= s.chain.FinalizedHeight() {
+		return
+	}
+
+	missing := root.Height - 1
+	first := min(s.chain.Height()+1, missing)
+
+	s.Logger.Log("msg", "requesting parents of orphan", "height", b.Height, "from", first, "to", missing, "addr", from)
+
+	go func() {
+		if err := s.sendGetBlocks(from, first, missing); err != nil {
+			s.Logger.Log("error", err)
+		}
+	}()
+}
+
+// connectOrphans adds the orphans waiting for the given block and,
+// recursively, the ones waiting for them.
+func (s *Server) connectOrphans(parent *core.Block) {
+	queue := []*core.Block{parent}
+	for len(queue) > 0 {
+		for _, b := range s.orphans.TakeChildren(queue[0].Hash(core.BlockHasher{})) {
+			if err := s.chain.AddBlock(b); err != nil {
+				if !errors.Is(err, core.ErrBlockKnown) {
+					s.Logger.Log("msg", "orphan block rejected", "height", b.Height, "err", err)
+				}
+				continue
+			}
+			queue = append(queue, b)
+		}
+		queue = queue[1:]
 	}
-	if err := block.Sign(*s.PrivateKey); err != nil {
-		s.Logger.Log("Fail to sign new block", err)
+}
+
+func (s *Server) createNewBlock() error {
+	fmt.Println("creating a new block")
+
+	block, err := s.newBlock()
+	if errors.Is(err, core.ErrSealAborted) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+
+	// txs that didn't fit stay pending for the next block
+	txx := block.Transactions
 	if err := s.chain.AddBlock(block); err != nil {
 		s.Logger.Log("Fail to add new block", err)
 		return err
 	}
+	s.memPool.RemovePending(txx)
+	s.evidence.Prune(s.chain.CheckEvidence)
+
 	return nil
 }
 
-func genesisBlock() *core.Block {
-	header := &core.Header{
-		Version:   1,
-		DataHash:  types.Hash{},
-		Height:    0,
-		Timestamp: 000000,
+// newBlock builds a block on top of the chain from the pending transactions
+// and seals it. It returns core.ErrSealAborted when the chain reached the
+// height of the block first and signer.ErrDoubleSign when we signed another
+// block at that height before.
+func (s *Server) newBlock() (*core.Block, error) {
+	currentHeader, err := s.chain.GetHeader(s.chain.Height())
+	if err != nil {
+		return nil, err
+	}
+
+	height := currentHeader.Height + 1
+	rules := s.chain.RulesAt(height)
+
+	now := time.Now().UnixNano()
+	if rules.MonotonicTimestamps && now <= currentHeader.Timestamp {
+		now = currentHeader.Timestamp + 1
+	}
+
+	// Drop whatever expired while waiting in the pool, the block would be rejected otherwise.
+	if n := s.memPool.Prune(height, now); n > 0 {
+		s.Logger.Log("msg", "evicted expired transactions", "count", n)
+	}
+
+	// Take the pending transactions in order until the block is full.
+	txx, err := s.selectTransactions(s.chain.Params())
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := core.NewBlockFromPrevHeader(currentHeader, txx)
+	if err != nil {
+		return nil, err
+	}
+	block.Version = s.chain.VersionAt(height)
+	block.Timestamp = now
+	for _, e := range s.evidence.Pending() {
+		if s.chain.CheckEvidence(e) == nil {
+			block.AddEvidence(e)
+		}
+	}
+
+	engine := s.chain.Engine()
+	if err := engine.Prepare(s.chain, block.Header, s.Signer); err != nil {
+		return nil, err
+	}
+	// give up once a block for this height arrived from somebody else
+	err = engine.Seal(block, s.Signer, func() bool { return s.chain.Height() >= height })
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+func (s *Server) selectTransactions(params core.Params) ([]*core.Transaction, error) {
+	txx := []*core.Transaction{}
+	size := 0
+	for _, tx := range s.memPool.Pending() {
+		if tx.Fee < params.MinFee {
+			continue
+		}
+		if s.chain.HasTx(tx.Hash(core.TxHasher{})) {
+			continue
+		}
+
+		txSize, err := tx.Size()
+		if err != nil {
+			return nil, err
+		}
+		if params.MaxBlockSize > 0 && size+txSize > int(params.MaxBlockSize) {
+			break
+		}
+
+		size += txSize
+		txx = append(txx, tx)
 	}
 
-	b, _ := core.NewBlock(header, nil)
+	return txx, nil
+}
 
-	// create a single transaction
-	coinbase := crypto.PublicKey{}
-	tx := core.NewTransaction(nil)
-	tx.From = coinbase
-	tx.To = coinbase
-	tx.Value = 10_000_000
-	b.Transactions = append(b.Transactions, tx)
+func genesisBlock(cfg core.GenesisConfig) (*core.Block, error) {
+	b, err := cfg.Block()
+	if err != nil {
+		return nil, err
+	}
 
 	privKey := crypto.GeneratePrivateKey()
 	if err := b.Sign(privKey); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return b
+	return b, nil
 }
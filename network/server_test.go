@@ -0,0 +1,112 @@
+package network
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sharkchain/core"
+	"sharkchain/crypto"
+	"sharkchain/signer"
+	"sharkchain/types"
+	"testing"
+)
+
+func TestValidatorDataDir(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	opts := ServerOpts{Logger: log.NewNopLogger(), PrivateKey: &privKey}
+
+	_, err := NewServer(opts)
+	assert.NotNil(t, err)
+
+	opts.DataDir = t.TempDir()
+	s, err := NewServer(opts)
+	assert.Nil(t, err)
+	assert.True(t, s.isValidator)
+
+	// a second node on the same sign state
+	_, err = NewServer(opts)
+	assert.ErrorIs(t, err, signer.ErrGuardLocked)
+}
+
+func TestOrphanBlocks(t *testing.T) {
+	s, err := NewServer(ServerOpts{Logger: log.NewNopLogger()})
+	assert.Nil(t, err)
+	peer := &net.TCPAddr{Port: 3000}
+
+	unsigned := randomOrphan(t, 2, types.RandomHash())
+	assert.NotNil(t, s.addBlock(peer, unsigned))
+
+	far := randomOrphan(t, MaxOrphanDistance+1, types.RandomHash())
+	assert.Nil(t, far.Sign(crypto.GeneratePrivateKey()))
+	assert.NotNil(t, s.addBlock(peer, far))
+	assert.Equal(t, 0, s.orphans.Len())
+
+	near := randomOrphan(t, MaxOrphanDistance, types.RandomHash())
+	assert.Nil(t, near.Sign(crypto.GeneratePrivateKey()))
+	assert.ErrorIs(t, s.addBlock(peer, near), core.ErrUnknownParent)
+	assert.True(t, s.orphans.Contains(near.Hash(core.BlockHasher{})))
+}
+
+func TestOrphanOfUnknownValidator(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	params := core.DefaultParams
+	params.Consensus = core.ConsensusPoA
+	params.Validators = []crypto.PublicKey{validator.PublicKey()}
+	s, err := NewServer(ServerOpts{Logger: log.NewNopLogger(), Genesis: &core.GenesisConfig{Params: &params}})
+	assert.Nil(t, err)
+	peer := &net.TCPAddr{Port: 3000}
+
+	// signed, but not by a validator
+	b := randomOrphan(t, 2, types.RandomHash())
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	err = s.addBlock(peer, b)
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, core.ErrUnknownParent)
+	assert.Equal(t, 0, s.orphans.Len())
+
+	b = randomOrphan(t, 2, types.RandomHash())
+	assert.Nil(t, b.Sign(validator))
+	assert.ErrorIs(t, s.addBlock(peer, b), core.ErrUnknownParent)
+	assert.Equal(t, 1, s.orphans.Len())
+}
+
+func TestOrphanBelowDifficulty(t *testing.T) {
+	params := core.DefaultParams
+	params.Consensus = core.ConsensusPoW
+	params.PoW = &core.PoWParams{InitialDifficulty: 1 << 10, AdjustmentInterval: 10}
+	s, err := NewServer(ServerOpts{Logger: log.NewNopLogger(), Genesis: &core.GenesisConfig{Params: &params}})
+	assert.Nil(t, err)
+	peer := &net.TCPAddr{Port: 3000}
+	miner := crypto.GeneratePrivateKey()
+
+	cheap := randomOrphan(t, 2, types.RandomHash())
+	cheap.Difficulty = 1
+	assert.True(t, core.Mine(cheap.Header, func() bool { return false }))
+	assert.Nil(t, cheap.Sign(miner))
+	err = s.addBlock(peer, cheap)
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, core.ErrUnknownParent)
+
+	mined := randomOrphan(t, 2, types.RandomHash())
+	mined.Difficulty = 1 << 10
+	assert.True(t, core.Mine(mined.Header, func() bool { return false }))
+	assert.Nil(t, mined.Sign(miner))
+	assert.ErrorIs(t, s.addBlock(peer, mined), core.ErrUnknownParent)
+}
+
+func TestProcessIncludedTransaction(t *testing.T) {
+	s, err := NewServer(ServerOpts{Logger: log.NewNopLogger()})
+	assert.Nil(t, err)
+
+	tx := core.NewTransaction([]byte("foo"))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	prevHeader, err := s.chain.GetHeader(0)
+	assert.Nil(t, err)
+	b, err := core.NewBlockFromPrevHeader(prevHeader, []*core.Transaction{tx})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, s.chain.AddBlock(b))
+
+	assert.NotNil(t, s.processTransaction(tx))
+	assert.Equal(t, 0, s.memPool.PendingCount())
+}
@@ -0,0 +1,181 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sharkchain/core"
+	"sync"
+	"time"
+)
+
+// SnapshotChunkSize is the size of the chunks a snapshot is served in, a
+// message has to fit the read buffer of the peers.
+const SnapshotChunkSize = 2048
+
+// MaxSnapshotSize bounds the encoded snapshot a peer may announce, the chunks
+// are kept in memory until all arrived.
+const MaxSnapshotSize = 512 << 20
+
+// SnapshotTimeout is how long the peer may take to send the next chunk
+// before the download moves on to another one.
+const SnapshotTimeout = 10 * time.Second
+
+// snapshotChunks encodes the snapshot and splits it into chunks.
+func snapshotChunks(s *core.Snapshot) ([][]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(s); err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	chunks := [][]byte{}
+	for len(data) > 0 {
+		n := min(len(data), SnapshotChunkSize)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+
+	return chunks, nil
+}
+
+// SnapshotSync downloads the snapshot of a trusted checkpoint chunk by chunk
+// from a single peer.
+type SnapshotSync struct {
+	mu         sync.Mutex
+	checkpoint core.Checkpoint
+	// peer is the one the chunks are downloaded from, nil until started
+	peer   net.Addr
+	total  uint32
+	chunks [][]byte
+	// progress is when the download started or the last chunk arrived
+	progress time.Time
+	// stalled holds the peers that timed out and until when they are
+	// passed over
+	stalled map[string]time.Time
+}
+
+func NewSnapshotSync(c core.Checkpoint) *SnapshotSync {
+	return &SnapshotSync{
+		checkpoint: c,
+		stalled:    make(map[string]time.Time),
+	}
+}
+
+// Checkpoint returns the checkpoint of the snapshot being downloaded.
+func (s *SnapshotSync) Checkpoint() core.Checkpoint {
+	return s.checkpoint
+}
+
+// Start downloads from the given peer, it reports false when the download
+// from another one is under way or the peer recently timed out.
+func (s *SnapshotSync) Start(peer net.Addr) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.peer != nil || time.Now().Before(s.stalled[peer.String()]) {
+		return false
+	}
+	s.peer = peer
+	s.progress = time.Now()
+
+	return true
+}
+
+// Timeout drops the download when the peer sent no chunk for
+// SnapshotTimeout, so another peer can take over. It returns the peer,
+// which is passed over for the next SnapshotTimeout.
+func (s *SnapshotSync) Timeout(now time.Time) (net.Addr, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.peer == nil || now.Sub(s.progress) < SnapshotTimeout {
+		return nil, false
+	}
+
+	peer := s.peer
+	s.stalled[peer.String()] = now.Add(SnapshotTimeout)
+	s.resetWithoutLock()
+
+	return peer, true
+}
+
+// Add keeps a chunk received from the peer. It returns the index of the
+// chunk to request next and whether all of them arrived.
+func (s *SnapshotSync) Add(from net.Addr, msg *SnapshotChunkMessage) (uint32, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.peer == nil || s.peer.String() != from.String() {
+		return 0, false, fmt.Errorf("snapshot chunk from %s which it was not requested from", from)
+	}
+	if msg.Height != s.checkpoint.Height || msg.BlockHash != s.checkpoint.Hash || msg.StateRoot != s.checkpoint.StateRoot {
+		return 0, false, fmt.Errorf("snapshot chunk of (%d) with root (%s) is not of the checkpoint", msg.Height, msg.StateRoot)
+	}
+	if msg.Index != uint32(len(s.chunks)) {
+		return 0, false, fmt.Errorf("snapshot chunk (%d) while expecting (%d)", msg.Index, len(s.chunks))
+	}
+	if msg.Total == 0 || (s.total > 0 && msg.Total != s.total) {
+		return 0, false, fmt.Errorf("snapshot chunk (%d) of (%d) while expecting (%d)", msg.Index, msg.Total, s.total)
+	}
+	if msg.Total > MaxSnapshotSize/SnapshotChunkSize {
+		return 0, false, fmt.Errorf("snapshot of (%d) chunks exceeds the maximum size of %d bytes", msg.Total, MaxSnapshotSize)
+	}
+	if len(msg.Data) > SnapshotChunkSize {
+		return 0, false, fmt.Errorf("snapshot chunk (%d) of %d bytes is larger than %d", msg.Index, len(msg.Data), SnapshotChunkSize)
+	}
+
+	s.total = msg.Total
+	s.chunks = append(s.chunks, msg.Data)
+	s.progress = time.Now()
+
+	return uint32(len(s.chunks)), uint32(len(s.chunks)) == s.total, nil
+}
+
+// Snapshot decodes the downloaded chunks, it is up to the chain to verify
+// the snapshot.
+func (s *SnapshotSync) Snapshot() (*core.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.total == 0 || uint32(len(s.chunks)) != s.total {
+		return nil, errors.New("snapshot download is not complete")
+	}
+
+	snapshot := new(core.Snapshot)
+	if err := gob.NewDecoder(bytes.NewReader(bytes.Join(s.chunks, nil))).Decode(snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// Reset drops the downloaded chunks so the download can start over from
+// another peer.
+func (s *SnapshotSync) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resetWithoutLock()
+}
+
+func (s *SnapshotSync) resetWithoutLock() {
+	s.peer = nil
+	s.total = 0
+	s.chunks = nil
+}
+
+// latestStateRoot returns the latest of the checkpoints trusted with a state
+// root.
+func latestStateRoot(checkpoints []core.Checkpoint) (core.Checkpoint, bool) {
+	latest, ok := core.Checkpoint{}, false
+	for _, c := range checkpoints {
+		if !c.StateRoot.IsZero() && (!ok || c.Height > latest.Height) {
+			latest, ok = c, true
+		}
+	}
+
+	return latest, ok
+}
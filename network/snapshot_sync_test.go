@@ -0,0 +1,136 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sharkchain/core"
+	"sharkchain/types"
+	"testing"
+	"time"
+)
+
+func TestSnapshotSync(t *testing.T) {
+	snapshot := &core.Snapshot{Height: 10, BlockHash: types.RandomHash(), StateRoot: types.RandomHash()}
+	for i := 0; i < 100; i++ {
+		snapshot.Storage = append(snapshot.Storage, core.StorageEntry{Key: []byte{byte(i)}, Value: make([]byte, 100)})
+	}
+	chunks, err := snapshotChunks(snapshot)
+	assert.Nil(t, err)
+	assert.Greater(t, len(chunks), 1)
+
+	chunk := func(i int) *SnapshotChunkMessage {
+		return &SnapshotChunkMessage{
+			Height:    snapshot.Height,
+			BlockHash: snapshot.BlockHash,
+			StateRoot: snapshot.StateRoot,
+			Index:     uint32(i),
+			Total:     uint32(len(chunks)),
+			Data:      chunks[i],
+		}
+	}
+
+	// every chunk fits the read buffer of a peer
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(chunk(0)))
+	assert.LessOrEqual(t, len(NewMessage(MessageTypeSnapshotChunk, buf.Bytes()).Bytes()), 4096)
+
+	peer := &net.TCPAddr{Port: 3000}
+	other := &net.TCPAddr{Port: 4000}
+	s := NewSnapshotSync(core.Checkpoint{Height: snapshot.Height, Hash: snapshot.BlockHash, StateRoot: snapshot.StateRoot})
+
+	_, _, err = s.Add(peer, chunk(0))
+	assert.NotNil(t, err)
+	assert.True(t, s.Start(peer))
+	assert.False(t, s.Start(other))
+
+	_, _, err = s.Add(other, chunk(0))
+	assert.NotNil(t, err)
+	_, _, err = s.Add(peer, chunk(1))
+	assert.NotNil(t, err)
+	wrongRoot := chunk(0)
+	wrongRoot.StateRoot = types.RandomHash()
+	_, _, err = s.Add(peer, wrongRoot)
+	assert.NotNil(t, err)
+
+	for i := range chunks {
+		_, err := s.Snapshot()
+		assert.NotNil(t, err)
+
+		next, done, err := s.Add(peer, chunk(i))
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(i+1), next)
+		assert.Equal(t, i == len(chunks)-1, done)
+	}
+
+	received, err := s.Snapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot.StateRoot, received.StateRoot)
+	assert.Equal(t, snapshot.Storage, received.Storage)
+
+	s.Reset()
+	assert.True(t, s.Start(other))
+}
+
+func TestSnapshotSyncBounds(t *testing.T) {
+	c := core.Checkpoint{Height: 10, Hash: types.RandomHash(), StateRoot: types.RandomHash()}
+	chunk := &SnapshotChunkMessage{
+		Height:    c.Height,
+		BlockHash: c.Hash,
+		StateRoot: c.StateRoot,
+		Total:     MaxSnapshotSize/SnapshotChunkSize + 1,
+		Data:      make([]byte, SnapshotChunkSize),
+	}
+	peer := &net.TCPAddr{Port: 3000}
+	s := NewSnapshotSync(c)
+	assert.True(t, s.Start(peer))
+
+	_, _, err := s.Add(peer, chunk)
+	assert.NotNil(t, err)
+
+	chunk.Total = 2
+	chunk.Data = make([]byte, SnapshotChunkSize+1)
+	_, _, err = s.Add(peer, chunk)
+	assert.NotNil(t, err)
+
+	chunk.Data = make([]byte, SnapshotChunkSize)
+	_, _, err = s.Add(peer, chunk)
+	assert.Nil(t, err)
+}
+
+func TestSnapshotSyncTimeout(t *testing.T) {
+	c := core.Checkpoint{Height: 10, Hash: types.RandomHash(), StateRoot: types.RandomHash()}
+	peer := &net.TCPAddr{Port: 3000}
+	other := &net.TCPAddr{Port: 4000}
+	s := NewSnapshotSync(c)
+
+	_, ok := s.Timeout(time.Now().Add(time.Hour))
+	assert.False(t, ok)
+
+	assert.True(t, s.Start(peer))
+	_, ok = s.Timeout(time.Now())
+	assert.False(t, ok)
+
+	stalled, ok := s.Timeout(time.Now().Add(SnapshotTimeout))
+	assert.True(t, ok)
+	assert.Equal(t, peer, stalled)
+
+	// another peer takes over, the stalled one is passed over for now
+	assert.False(t, s.Start(peer))
+	assert.True(t, s.Start(other))
+}
+
+func TestLatestStateRoot(t *testing.T) {
+	_, ok := latestStateRoot([]core.Checkpoint{{Height: 5, Hash: types.RandomHash()}})
+	assert.False(t, ok)
+
+	c := core.Checkpoint{Height: 3, Hash: types.RandomHash(), StateRoot: types.RandomHash()}
+	latest, ok := latestStateRoot([]core.Checkpoint{
+		{Height: 1, Hash: types.RandomHash(), StateRoot: types.RandomHash()},
+		c,
+		{Height: 5, Hash: types.RandomHash()},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, c, latest)
+}
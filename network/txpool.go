@@ -41,6 +41,17 @@ func (p *TxPool) Add(tx *core.Transaction) {
 	}
 }
 
+// Prune evicts every transaction that can no longer be included in a block
+// with the given height and timestamp. It returns the number of evicted transactions.
+func (p *TxPool) Prune(height uint32, timestamp int64) int {
+	expired := func(tx *core.Transaction) bool {
+		return tx.Expired(height, timestamp)
+	}
+
+	p.pending.RemoveFunc(expired)
+	return p.all.RemoveFunc(expired)
+}
+
 func (p *TxPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
@@ -54,6 +65,23 @@ func (p *TxPool) ClearPending() {
 	p.pending.Clear()
 }
 
+// RemovePending removes the given transactions from the pending pool, they
+// are still known to the pool.
+func (p *TxPool) RemovePending(txx []*core.Transaction) {
+	for _, tx := range txx {
+		p.pending.Remove(tx.Hash(core.TxHasher{}))
+	}
+}
+
+// Restore makes the given transactions pending again, e.g. when the block
+// including them was reverted.
+func (p *TxPool) Restore(txx []*core.Transaction) {
+	for _, tx := range txx {
+		p.Add(tx)
+		p.pending.Add(tx)
+	}
+}
+
 func (p *TxPool) PendingCount() int {
 	return p.pending.Count()
 }
@@ -110,6 +138,24 @@ func (t *TxSortedMap) Remove(h types.Hash) {
 	delete(t.lookup, h)
 }
 
+// RemoveFunc removes every transaction for which fn returns true and returns
+// the number of removed transactions.
+func (t *TxSortedMap) RemoveFunc(fn func(*core.Transaction) bool) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	removed := 0
+	for hash, tx := range t.lookup {
+		if fn(tx) {
+			t.txx.Remove(tx)
+			delete(t.lookup, hash)
+			removed++
+		}
+	}
+
+	return removed
+}
+
 func (t *TxSortedMap) Count() int {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
@@ -3,19 +3,23 @@ package network
 import (
 	"github.com/stretchr/testify/assert"
 	"sharkchain/core"
-	"sharkchain/util"
+	"sharkchain/types"
 	"testing"
 )
 
+func newRandomTransaction(size int) *core.Transaction {
+	return core.NewTransaction(types.RandomBytes(size))
+}
+
 func TestTxMaxLength(t *testing.T) {
 	p := NewTxPool(1)
-	p.Add(util.NewRandomTransaction(10))
+	p.Add(newRandomTransaction(10))
 	assert.Equal(t, 1, p.all.Count())
 
-	p.Add(util.NewRandomTransaction(10))
-	p.Add(util.NewRandomTransaction(10))
-	p.Add(util.NewRandomTransaction(10))
-	tx := util.NewRandomTransaction(100)
+	p.Add(newRandomTransaction(10))
+	p.Add(newRandomTransaction(10))
+	p.Add(newRandomTransaction(10))
+	tx := newRandomTransaction(100)
 	p.Add(tx)
 	assert.Equal(t, 1, p.all.Count())
 	assert.True(t, p.Contains(tx.Hash(core.TxHasher{})))
@@ -26,7 +30,7 @@ func TestTxPoolAdd(t *testing.T) {
 	n := 10
 
 	for i := 1; i <= n; i++ {
-		tx := util.NewRandomTransaction(100)
+		tx := newRandomTransaction(100)
 		p.Add(tx)
 		// cannot add twice
 		p.Add(tx)
@@ -44,7 +48,7 @@ func TestTxPoolMaxLength(t *testing.T) {
 	txx := []*core.Transaction{}
 
 	for i := 0; i < n; i++ {
-		tx := util.NewRandomTransaction(100)
+		tx := newRandomTransaction(100)
 		p.Add(tx)
 
 		if i > n-(maxLen+1) {
@@ -62,12 +66,12 @@ func TestTxPoolMaxLength(t *testing.T) {
 
 func TestTxSortedMapFirst(t *testing.T) {
 	m := NewTxSortedMap()
-	first := util.NewRandomTransaction(100)
+	first := newRandomTransaction(100)
 	m.Add(first)
-	m.Add(util.NewRandomTransaction(10))
-	m.Add(util.NewRandomTransaction(10))
-	m.Add(util.NewRandomTransaction(10))
-	m.Add(util.NewRandomTransaction(10))
+	m.Add(newRandomTransaction(10))
+	m.Add(newRandomTransaction(10))
+	m.Add(newRandomTransaction(10))
+	m.Add(newRandomTransaction(10))
 	assert.Equal(t, first, m.First())
 }
 
@@ -76,7 +80,7 @@ func TestTxSortedMapAdd(t *testing.T) {
 	n := 100
 
 	for i := 0; i < n; i++ {
-		tx := util.NewRandomTransaction(100)
+		tx := newRandomTransaction(100)
 		m.Add(tx)
 		// cannot add the same twice
 		m.Add(tx)
@@ -96,7 +100,7 @@ func TestTxSortedMapAdd(t *testing.T) {
 func TestTxSortedMapRemove(t *testing.T) {
 	m := NewTxSortedMap()
 
-	tx := util.NewRandomTransaction(100)
+	tx := newRandomTransaction(100)
 	m.Add(tx)
 	assert.Equal(t, m.Count(), 1)
 
@@ -104,3 +108,27 @@ func TestTxSortedMapRemove(t *testing.T) {
 	assert.Equal(t, m.Count(), 0)
 	assert.False(t, m.Contains(tx.Hash(core.TxHasher{})))
 }
+
+func TestTxPoolPrune(t *testing.T) {
+	p := NewTxPool(10)
+
+	byHeight := newRandomTransaction(10)
+	byHeight.ValidUntilHeight = 5
+	byTime := newRandomTransaction(10)
+	byTime.ValidUntilTime = 1000
+	forever := newRandomTransaction(10)
+
+	p.Add(byHeight)
+	p.Add(byTime)
+	p.Add(forever)
+
+	assert.Equal(t, 0, p.Prune(5, 1000))
+	assert.Equal(t, 1, p.Prune(6, 1000))
+	assert.False(t, p.Contains(byHeight.Hash(core.TxHasher{})))
+
+	assert.Equal(t, 1, p.Prune(6, 1001))
+	assert.False(t, p.Contains(byTime.Hash(core.TxHasher{})))
+
+	assert.True(t, p.Contains(forever.Hash(core.TxHasher{})))
+	assert.Equal(t, 1, p.PendingCount())
+}
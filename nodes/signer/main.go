@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-kit/log"
+	"net"
+	"os"
+	"path/filepath"
+	"sharkchain/crypto"
+	"sharkchain/signer"
+	"strings"
+	"time"
+)
+
+// The signer process holds the key of a validator, nodes reach it through
+// ServerOpts.Signer set to a signer.RemoteSigner.
+func main() {
+	var (
+		network = flag.String("network", "unix", "network to listen on, unix or tcp")
+		listen  = flag.String("listen", "signer.sock", "socket path or loopback TCP address to listen on")
+		dataDir = flag.String("datadir", "signer", "directory of the key and the sign state")
+	)
+	flag.Parse()
+
+	logger := log.With(log.NewLogfmtLogger(os.Stderr), "component", "signer")
+
+	privKey, err := loadKey(filepath.Join(*dataDir, "key"))
+	if err != nil {
+		fail(logger, err)
+	}
+	guard, err := signer.OpenSignGuard(*dataDir)
+	if err != nil {
+		fail(logger, err)
+	}
+
+	// a socket left behind by a previous run would block the address, one
+	// still served belongs to a running signer which must not be replaced
+	if *network == "unix" {
+		if err := removeStaleSocket(*listen); err != nil {
+			fail(logger, err)
+		}
+	}
+	l, err := signer.Listen(*network, *listen)
+	if err != nil {
+		fail(logger, err)
+	}
+
+	logger.Log("msg", "signing", "validator", privKey.PublicKey().Address(), "network", *network, "addr", *listen)
+	if err := signer.NewServer(logger, privKey, guard).Serve(l); err != nil {
+		fail(logger, err)
+	}
+}
+
+// removeStaleSocket removes the socket unless a signer still accepts
+// connections on it.
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("a signer is already listening on %s", path)
+	}
+
+	return os.Remove(path)
+}
+
+// loadKey reads the hex encoded key, generating it on first start.
+func loadKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		privKey := crypto.GeneratePrivateKey()
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return crypto.PrivateKey{}, err
+		}
+		return privKey, os.WriteFile(path, []byte(hex.EncodeToString(privKey.Bytes())), 0o600)
+	}
+	if err != nil {
+		return crypto.PrivateKey{}, err
+	}
+
+	b, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return crypto.PrivateKey{}, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+
+	return crypto.NewPrivateKeyFromBytes(b)
+}
+
+func fail(logger log.Logger, err error) {
+	logger.Log("err", err)
+	os.Exit(1)
+}
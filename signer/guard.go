@@ -0,0 +1,190 @@
+package signer
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sharkchain/core"
+	"sharkchain/types"
+	"sync"
+)
+
+var ErrDoubleSign = errors.New("refusing to sign a conflicting block or vote")
+
+// ErrGuardLocked is returned by OpenSignGuard when another guard holds the
+// data directory.
+var ErrGuardLocked = errors.New("sign state is in use by another process")
+
+const (
+	// signStateFile is the name of the sign state in the data directory.
+	signStateFile = "sign_state.json"
+	// signLockFile is locked as long as a guard uses the data directory, the
+	// sign state itself gets replaced on every save.
+	signLockFile = "sign_state.lock"
+)
+
+// SignState is the last block and the last vote a validator signed.
+type SignState struct {
+	Height    uint32
+	BlockHash types.Hash
+
+	VoteHeight uint32
+	VoteRound  uint32
+	VoteType   core.VoteType
+	VoteHash   types.Hash
+}
+
+// SignGuard keeps a validator from signing two blocks at the same height or
+// two votes in the same step of a round, even across restarts or when the
+// same key runs twice on one data directory. Every signature is persisted
+// before it leaves the node.
+type SignGuard struct {
+	mu   sync.Mutex
+	path string
+	lock *os.File
+	last SignState
+}
+
+// OpenSignGuard locks the data directory and loads its sign state, creating
+// the directory when it does not exist yet. It returns ErrGuardLocked when
+// another guard, in this process or another one, holds the directory.
+func OpenSignGuard(dataDir string) (*SignGuard, error) {
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dataDir, signLockFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("%w: %s: %w", ErrGuardLocked, dataDir, err)
+	}
+
+	g := &SignGuard{path: filepath.Join(dataDir, signStateFile), lock: lock}
+	data, err := os.ReadFile(g.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &g.last); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("corrupt sign state %s: %w", g.path, err)
+	}
+
+	return g, nil
+}
+
+// Close releases the data directory, the guard must not be used afterwards.
+func (g *SignGuard) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.lock.Close()
+}
+
+// Last returns the last signed block.
+func (g *SignGuard) Last() SignState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.last
+}
+
+// Allow persists the block as signed, unless it is below the last signed
+// height or conflicts with the block signed at that height. The same block
+// may be signed again.
+func (g *SignGuard) Allow(h *core.Header) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	hash := core.BlockHasher{}.Hash(h)
+	switch {
+	case h.Height < g.last.Height:
+		return fmt.Errorf("%w: block (%d) is below the last signed height (%d)", ErrDoubleSign, h.Height, g.last.Height)
+	case h.Height == g.last.Height && hash != g.last.BlockHash:
+		return fmt.Errorf("%w: block (%s) at height (%d), already signed (%s)", ErrDoubleSign, hash, h.Height, g.last.BlockHash)
+	case h.Height == g.last.Height:
+		return nil
+	}
+
+	state := g.last
+	state.Height, state.BlockHash = h.Height, hash
+
+	return g.save(state)
+}
+
+// AllowVote persists the vote as signed, unless it is for an earlier step
+// than the last signed vote or for another block in the same step.
+func (g *SignGuard) AllowVote(v *core.Vote) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	last := g.last
+	if cmp := compareSteps(v.Height, v.Round, v.Type, last.VoteHeight, last.VoteRound, last.VoteType); cmp < 0 {
+		return fmt.Errorf("%w: %s (%d/%d) is before the last signed %s (%d/%d)", ErrDoubleSign, v.Type, v.Height, v.Round, last.VoteType, last.VoteHeight, last.VoteRound)
+	} else if cmp == 0 {
+		if v.BlockHash != last.VoteHash {
+			return fmt.Errorf("%w: %s (%d/%d) for (%s), already signed for (%s)", ErrDoubleSign, v.Type, v.Height, v.Round, v.BlockHash, last.VoteHash)
+		}
+		return nil
+	}
+
+	state := g.last
+	state.VoteHeight, state.VoteRound, state.VoteType, state.VoteHash = v.Height, v.Round, v.Type, v.BlockHash
+
+	return g.save(state)
+}
+
+// compareSteps orders the steps of the BFT rounds by height, round and vote
+// type.
+func compareSteps(height, round uint32, t core.VoteType, lastHeight, lastRound uint32, lastType core.VoteType) int {
+	if c := cmp.Compare(height, lastHeight); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(round, lastRound); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(t, lastType)
+}
+
+// save replaces the sign state file at once, a crash leaves either the old
+// or the new state behind.
+func (g *SignGuard) save(state SignState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := g.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, g.path); err != nil {
+		return err
+	}
+
+	g.last = state
+
+	return nil
+}
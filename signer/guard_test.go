@@ -0,0 +1,69 @@
+package signer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sharkchain/core"
+	"sharkchain/types"
+	"testing"
+)
+
+func headerAt(height uint32) *core.Header {
+	return &core.Header{Height: height, DataHash: types.RandomHash()}
+}
+
+func TestSignGuard(t *testing.T) {
+	g, err := OpenSignGuard(t.TempDir())
+	assert.Nil(t, err)
+
+	h := headerAt(5)
+	assert.Nil(t, g.Allow(h))
+	// proposed again in a later round
+	assert.Nil(t, g.Allow(h))
+	assert.Equal(t, uint32(5), g.Last().Height)
+	assert.Equal(t, core.BlockHasher{}.Hash(h), g.Last().BlockHash)
+
+	assert.ErrorIs(t, g.Allow(headerAt(5)), ErrDoubleSign)
+	assert.ErrorIs(t, g.Allow(headerAt(4)), ErrDoubleSign)
+	assert.Nil(t, g.Allow(headerAt(6)))
+}
+
+func TestSignGuardVotes(t *testing.T) {
+	g, err := OpenSignGuard(t.TempDir())
+	assert.Nil(t, err)
+
+	v := &core.Vote{Type: core.Prevote, Height: 3, Round: 1, BlockHash: types.RandomHash()}
+	assert.Nil(t, g.AllowVote(v))
+	assert.Nil(t, g.AllowVote(v))
+
+	// another block or nil in the same step
+	assert.ErrorIs(t, g.AllowVote(&core.Vote{Type: core.Prevote, Height: 3, Round: 1}), ErrDoubleSign)
+	// an earlier step
+	assert.ErrorIs(t, g.AllowVote(&core.Vote{Type: core.Precommit, Height: 3, Round: 0}), ErrDoubleSign)
+
+	assert.Nil(t, g.AllowVote(&core.Vote{Type: core.Precommit, Height: 3, Round: 1}))
+	assert.Nil(t, g.AllowVote(&core.Vote{Type: core.Prevote, Height: 3, Round: 2}))
+	assert.Nil(t, g.AllowVote(&core.Vote{Type: core.Prevote, Height: 4}))
+}
+
+func TestSignGuardAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	g, err := OpenSignGuard(dir)
+	assert.Nil(t, err)
+
+	h := headerAt(3)
+	assert.Nil(t, g.Allow(h))
+	v := &core.Vote{Type: core.Precommit, Height: 3, BlockHash: core.BlockHasher{}.Hash(h)}
+	assert.Nil(t, g.AllowVote(v))
+
+	// the same directory is not guarded twice at once
+	_, err = OpenSignGuard(dir)
+	assert.ErrorIs(t, err, ErrGuardLocked)
+	assert.Nil(t, g.Close())
+
+	g, err = OpenSignGuard(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), g.Last().Height)
+	assert.ErrorIs(t, g.Allow(headerAt(3)), ErrDoubleSign)
+	assert.Nil(t, g.Allow(h))
+	assert.ErrorIs(t, g.AllowVote(&core.Vote{Type: core.Precommit, Height: 3}), ErrDoubleSign)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package signer
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile can't lock the file on this platform, so a guard is never opened
+// without knowing it is the only one.
+func lockFile(f *os.File) error {
+	return errors.New("locking the sign state is not supported on this platform")
+}
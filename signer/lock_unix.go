@@ -0,0 +1,14 @@
+//go:build unix
+
+package signer
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file without waiting for it, the
+// lock goes away with the file descriptor.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
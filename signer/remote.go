@@ -0,0 +1,187 @@
+package signer
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sharkchain/core"
+	"sharkchain/crypto"
+	"sync"
+	"time"
+)
+
+// requestTimeout bounds a round trip to the signer, a validator waiting for
+// a hung signer would miss its turn anyway.
+const requestTimeout = 5 * time.Second
+
+type requestKind byte
+
+const (
+	requestPublicKey requestKind = iota + 1
+	requestHeader
+	requestVote
+	requestProposal
+	requestVRF
+)
+
+// request is sent gob encoded by the RemoteSigner, one at a time.
+type request struct {
+	Kind     requestKind
+	Header   *core.Header
+	Vote     *core.Vote
+	Proposal *core.BlockProposal
+	Alpha    []byte
+}
+
+type response struct {
+	PublicKey crypto.PublicKey
+	Signature *crypto.Signature
+	VRFProof  []byte
+	Error     string
+	// DoubleSign is set when the signer refused to sign a conflicting
+	// block or vote.
+	DoubleSign bool
+}
+
+// RemoteSigner signs with a key held by a separate signer process, see
+// Server. It talks to it over a Unix socket or a TCP connection on the
+// loopback interface, the requests are not authenticated: the signer signs
+// whatever does not conflict with what it signed before.
+type RemoteSigner struct {
+	network string
+	address string
+
+	mu        sync.Mutex
+	conn      net.Conn
+	enc       *gob.Encoder
+	dec       *gob.Decoder
+	publicKey crypto.PublicKey
+}
+
+// DialRemoteSigner connects to the signer listening at the given address,
+// network is "unix" or "tcp".
+func DialRemoteSigner(network, address string) (*RemoteSigner, error) {
+	r := &RemoteSigner{
+		network: network,
+		address: address,
+	}
+
+	resp, err := r.call(&request{Kind: requestPublicKey})
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.publicKey = resp.PublicKey
+
+	return r, nil
+}
+
+func (r *RemoteSigner) PublicKey() crypto.PublicKey {
+	return r.publicKey
+}
+
+// Sign always fails, the signer process only signs what it can check.
+func (r *RemoteSigner) Sign(data []byte) (*crypto.Signature, error) {
+	return nil, ErrRawSign
+}
+
+func (r *RemoteSigner) SignHeader(h *core.Header) (*crypto.Signature, error) {
+	hash := core.BlockHasher{}.Hash(h)
+	return r.sign(&request{Kind: requestHeader, Header: h}, hash.ToSlice())
+}
+
+func (r *RemoteSigner) SignVote(v *core.Vote) (*crypto.Signature, error) {
+	hash := v.Hash()
+	return r.sign(&request{Kind: requestVote, Vote: v}, hash.ToSlice())
+}
+
+func (r *RemoteSigner) SignProposal(p *core.BlockProposal) (*crypto.Signature, error) {
+	// the signer only needs the header to hash the proposal
+	proposal := *p
+	proposal.Block = &core.Block{Header: p.Block.Header}
+
+	hash := p.Hash()
+	return r.sign(&request{Kind: requestProposal, Proposal: &proposal}, hash.ToSlice())
+}
+
+// ProveVRF evaluates the VRF of the key, there is nothing to refuse: its
+// output only depends on the key and the input.
+func (r *RemoteSigner) ProveVRF(alpha []byte) ([]byte, error) {
+	resp, err := r.call(&request{Kind: requestVRF, Alpha: alpha})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := crypto.VerifyVRF(r.publicKey, alpha, resp.VRFProof); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+
+	return resp.VRFProof, nil
+}
+
+// sign sends the request and checks the returned signature against the hash
+// we expect to be signed.
+func (r *RemoteSigner) sign(req *request, hash []byte) (*crypto.Signature, error) {
+	resp, err := r.call(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Signature == nil || !resp.Signature.Verify(r.publicKey, hash) {
+		return nil, errors.New("remote signer returned an invalid signature")
+	}
+
+	return resp.Signature, nil
+}
+
+// call makes a round trip to the signer, connecting first if needed. A
+// failed connection is dropped and dialed again by the next call.
+func (r *RemoteSigner) call(req *request) (*response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		conn, err := net.DialTimeout(r.network, r.address, requestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("could not reach remote signer: %w", err)
+		}
+		r.conn, r.enc, r.dec = conn, gob.NewEncoder(conn), gob.NewDecoder(conn)
+	}
+
+	resp := &response{}
+	r.conn.SetDeadline(time.Now().Add(requestTimeout))
+	if err := r.enc.Encode(req); err != nil {
+		r.closeConn()
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	if err := r.dec.Decode(resp); err != nil {
+		r.closeConn()
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+
+	if resp.DoubleSign {
+		return nil, fmt.Errorf("%w: %s", ErrDoubleSign, resp.Error)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("remote signer: %s", resp.Error)
+	}
+
+	return resp, nil
+}
+
+func (r *RemoteSigner) closeConn() {
+	r.conn.Close()
+	r.conn, r.enc, r.dec = nil, nil, nil
+}
+
+func (r *RemoteSigner) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn, r.enc, r.dec = nil, nil, nil
+
+	return err
+}
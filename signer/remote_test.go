@@ -0,0 +1,103 @@
+package signer
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"sharkchain/core"
+	"sharkchain/crypto"
+	"sharkchain/types"
+	"testing"
+)
+
+func startSigner(t *testing.T, l net.Listener) crypto.PrivateKey {
+	privKey := crypto.GeneratePrivateKey()
+	guard, err := OpenSignGuard(t.TempDir())
+	assert.Nil(t, err)
+
+	go NewServer(log.NewNopLogger(), privKey, guard).Serve(l)
+	t.Cleanup(func() { l.Close() })
+
+	return privKey
+}
+
+func TestRemoteSigner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	privKey := startSigner(t, l)
+
+	r, err := DialRemoteSigner("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, privKey.PublicKey(), r.PublicKey())
+
+	b, err := core.NewBlock(headerAt(1), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(r))
+	assert.Nil(t, b.Verify())
+	assert.Equal(t, privKey.PublicKey(), b.Validator)
+
+	conflicting, err := core.NewBlock(headerAt(1), nil)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, conflicting.Sign(r), ErrDoubleSign)
+
+	v := &core.Vote{Type: core.Prevote, Height: 1, BlockHash: b.Hash(core.BlockHasher{})}
+	assert.Nil(t, v.Sign(r))
+	assert.Nil(t, v.Verify())
+
+	p := &core.BlockProposal{Height: 1, ValidRound: -1, Block: b}
+	assert.Nil(t, p.Sign(r))
+	assert.Nil(t, p.Verify())
+
+	proof, err := r.ProveVRF([]byte("slot"))
+	assert.Nil(t, err)
+	_, err = crypto.VerifyVRF(privKey.PublicKey(), []byte("slot"), proof)
+	assert.Nil(t, err)
+
+	_, err = r.Sign([]byte("anything"))
+	assert.ErrorIs(t, err, ErrRawSign)
+}
+
+func TestRemoteSignerUnixSocket(t *testing.T) {
+	// socket paths are limited to about a hundred bytes
+	dir, err := os.MkdirTemp("", "signer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "signer.sock")
+	l, err := Listen("unix", path)
+	assert.Nil(t, err)
+	startSigner(t, l)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	r, err := DialRemoteSigner("unix", path)
+	assert.Nil(t, err)
+	defer r.Close()
+
+	v := &core.Vote{Type: core.Precommit, Height: 2, BlockHash: types.RandomHash()}
+	assert.Nil(t, v.Sign(r))
+	assert.Nil(t, v.Verify())
+
+	// reconnects once the connection is gone
+	r.Close()
+	v = &core.Vote{Type: core.Precommit, Height: 3, BlockHash: types.RandomHash()}
+	assert.Nil(t, v.Sign(r))
+}
+
+func TestSignerOnlyServesLoopback(t *testing.T) {
+	for _, address := range []string{":0", "0.0.0.0:0"} {
+		_, err := Listen("tcp", address)
+		assert.NotNil(t, err)
+	}
+	l, err := Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	l.Close()
+
+	assert.True(t, fromOtherHost(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3000}))
+	assert.False(t, fromOtherHost(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3000}))
+	assert.False(t, fromOtherHost(&net.UnixAddr{Name: "signer.sock", Net: "unix"}))
+}
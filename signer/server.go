@@ -0,0 +1,163 @@
+package signer
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/go-kit/log"
+	"io"
+	"net"
+	"os"
+	"sharkchain/crypto"
+)
+
+// Server is the signer process side of a RemoteSigner. It holds the key of a
+// validator and signs blocks and votes the guard allows.
+type Server struct {
+	logger log.Logger
+	signer *GuardedSigner
+}
+
+func NewServer(logger log.Logger, privKey crypto.PrivateKey, guard *SignGuard) *Server {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	return &Server{
+		logger: logger,
+		signer: NewGuardedSigner(privKey, guard),
+	}
+}
+
+// Listen listens for the nodes on a Unix socket or a TCP address. The
+// requests are not authenticated, whoever connects gets the blocks and votes
+// it asks for signed, so TCP is only served on the loopback interface and
+// the socket only to its owner. A node on another host reaches the signer
+// through a tunnel, e.g. SSH.
+func Listen(network, address string) (net.Listener, error) {
+	switch network {
+	case "tcp":
+		addr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		if addr.IP == nil || !addr.IP.IsLoopback() {
+			return nil, fmt.Errorf("signer only listens on the loopback interface, not on %s", address)
+		}
+	case "unix":
+	default:
+		return nil, fmt.Errorf("signer listens on unix or tcp, not %s", network)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0o600); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// Serve answers the requests of every connection accepted by the listener
+// until it is closed. TCP connections from other hosts are refused.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fromOtherHost(conn.RemoteAddr()) {
+			s.logger.Log("msg", "refused connection from another host", "remote", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		go s.handleConn(conn)
+	}
+}
+
+// fromOtherHost reports whether the address is a TCP address off the
+// loopback interface.
+func fromOtherHost(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && !tcp.IP.IsLoopback()
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		dec = gob.NewDecoder(conn)
+		enc = gob.NewEncoder(conn)
+	)
+	for {
+		req := &request{}
+		if err := dec.Decode(req); err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logger.Log("msg", "could not read sign request", "remote", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+
+		resp := s.handle(req)
+		if err := enc.Encode(resp); err != nil {
+			s.logger.Log("msg", "could not send signature", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+	}
+}
+
+func (s *Server) handle(req *request) *response {
+	var (
+		sig *crypto.Signature
+		err error
+	)
+	switch req.Kind {
+	case requestPublicKey:
+		return &response{PublicKey: s.signer.PublicKey()}
+	case requestHeader:
+		if req.Header == nil {
+			err = errors.New("no header to sign")
+			break
+		}
+		sig, err = s.signer.SignHeader(req.Header)
+		if err == nil {
+			s.logger.Log("msg", "signed block", "height", req.Header.Height)
+		}
+	case requestVote:
+		if req.Vote == nil {
+			err = errors.New("no vote to sign")
+			break
+		}
+		sig, err = s.signer.SignVote(req.Vote)
+	case requestVRF:
+		proof, err := s.signer.ProveVRF(req.Alpha)
+		if err != nil {
+			return &response{Error: err.Error()}
+		}
+		return &response{VRFProof: proof}
+	case requestProposal:
+		if req.Proposal == nil || req.Proposal.Block == nil || req.Proposal.Block.Header == nil {
+			err = errors.New("no proposal to sign")
+			break
+		}
+		sig, err = s.signer.SignProposal(req.Proposal)
+	default:
+		err = fmt.Errorf("unknown sign request (%d)", req.Kind)
+	}
+
+	if err != nil {
+		s.logger.Log("msg", "refused to sign", "err", err)
+		return &response{Error: err.Error(), DoubleSign: errors.Is(err, ErrDoubleSign)}
+	}
+
+	return &response{Signature: sig}
+}
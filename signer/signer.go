@@ -0,0 +1,76 @@
+package signer
+
+import (
+	"errors"
+	"sharkchain/core"
+	"sharkchain/crypto"
+)
+
+// ErrRawSign is returned by signers that only sign what they can check.
+var ErrRawSign = errors.New("signer only signs blocks and consensus messages")
+
+// GuardedSigner signs blocks and votes with the wrapped signer once the
+// guard allowed them.
+type GuardedSigner struct {
+	signer crypto.Signer
+	guard  *SignGuard
+}
+
+func NewGuardedSigner(signer crypto.Signer, guard *SignGuard) *GuardedSigner {
+	return &GuardedSigner{
+		signer: signer,
+		guard:  guard,
+	}
+}
+
+func (s *GuardedSigner) PublicKey() crypto.PublicKey {
+	return s.signer.PublicKey()
+}
+
+func (s *GuardedSigner) Sign(data []byte) (*crypto.Signature, error) {
+	return nil, ErrRawSign
+}
+
+func (s *GuardedSigner) SignHeader(h *core.Header) (*crypto.Signature, error) {
+	if err := s.guard.Allow(h); err != nil {
+		return nil, err
+	}
+
+	if bs, ok := s.signer.(core.BlockSigner); ok {
+		return bs.SignHeader(h)
+	}
+	hash := core.BlockHasher{}.Hash(h)
+	return s.signer.Sign(hash.ToSlice())
+}
+
+func (s *GuardedSigner) SignVote(v *core.Vote) (*crypto.Signature, error) {
+	if err := s.guard.AllowVote(v); err != nil {
+		return nil, err
+	}
+
+	if bs, ok := s.signer.(core.BlockSigner); ok {
+		return bs.SignVote(v)
+	}
+	hash := v.Hash()
+	return s.signer.Sign(hash.ToSlice())
+}
+
+// ProveVRF is not guarded, the output only depends on the key and the input.
+func (s *GuardedSigner) ProveVRF(alpha []byte) ([]byte, error) {
+	prover, ok := s.signer.(crypto.VRFProver)
+	if !ok {
+		return nil, errors.New("signer can't evaluate the VRF")
+	}
+
+	return prover.ProveVRF(alpha)
+}
+
+// SignProposal is not guarded: a proposal may carry a block signed by
+// another validator and proposing is not slashed.
+func (s *GuardedSigner) SignProposal(p *core.BlockProposal) (*crypto.Signature, error) {
+	if bs, ok := s.signer.(core.BlockSigner); ok {
+		return bs.SignProposal(p)
+	}
+	hash := p.Hash()
+	return s.signer.Sign(hash.ToSlice())
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"log"
+	"path/filepath"
 	"sharkchain/crypto"
 	"sharkchain/network"
 )
@@ -13,6 +14,7 @@ func MakeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []strin
 		ListenAddr:    addr,
 		PrivateKey:    pk,
 		ID:            id,
+		DataDir:       filepath.Join("data", id),
 	}
 
 	s, err := network.NewServer(opts)